@@ -5,6 +5,30 @@ CREATE TABLE `channel` (
   UNIQUE KEY `name` (`name`)
 ) ENGINE=InnoDB AUTO_INCREMENT=4 DEFAULT CHARSET=utf8;
 
+CREATE TABLE `channel_calibration` (
+  `channel` int(11) NOT NULL,
+  `voxel_size_x` double NOT NULL COMMENT 'nm per full resolution voxel',
+  `voxel_size_y` double NOT NULL,
+  `voxel_size_z` double NOT NULL,
+  `origin_x` double NOT NULL DEFAULT 0 COMMENT 'nm position of voxel 0, 0, 0',
+  `origin_y` double NOT NULL DEFAULT 0,
+  `origin_z` double NOT NULL DEFAULT 0,
+  PRIMARY KEY (`channel`),
+  CONSTRAINT `fk_calibration_channel` FOREIGN KEY (`channel`) REFERENCES `channel` (`id`),
+  CONSTRAINT `positive_voxel_size` CHECK (`voxel_size_x` > 0 AND `voxel_size_y` > 0 AND `voxel_size_z` > 0)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `channel_mip` (
+  `channel` int(11) NOT NULL,
+  `mip` tinyint(3) unsigned NOT NULL COMMENT 'the resolution in urls, 0 is full resolution',
+  `downsample_x` int(10) NOT NULL COMMENT 'full resolution voxels per voxel at this mip',
+  `downsample_y` int(10) NOT NULL,
+  `downsample_z` int(10) NOT NULL,
+  PRIMARY KEY (`channel`, `mip`),
+  CONSTRAINT `fk_mip_channel` FOREIGN KEY (`channel`) REFERENCES `channel` (`id`),
+  CONSTRAINT `positive_downsample` CHECK (`downsample_x` > 0 AND `downsample_y` > 0 AND `downsample_z` > 0)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
 CREATE TABLE `voxel_set` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
   `boss_vset_id` bigint(20) unsigned NOT NULL,
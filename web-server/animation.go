@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// a few minutes of stimulus at typical frame rates, more would make gifs hundreds of MB
+const maxAnimationFrames = 5000
+
+var errTooManyFrames = fmt.Errorf("at most %d frames per animation, use ?frames= or ?t=", maxAnimationFrames)
+var errEmptyAnimation = errors.New("no stimulus frames in the window")
+
+var grayPalette = func() color.Palette {
+	res := make(color.Palette, 256)
+	for i := range res {
+		res[i] = color.Gray{Y: uint8(i)}
+	}
+	return res
+}()
+
+// animationFrames picks the window out of the movie and downsamples it, checking it isn't too long or empty
+func animationFrames(movie blobArray, window *frameWindow, factor int) (blobArray, error) {
+	if window != nil {
+		movie = movie.framesAlong(2, *window)
+	}
+
+	if movie.Shape[2] > maxAnimationFrames {
+		return movie, errTooManyFrames
+	}
+
+	// a window starting past the end of the movie, a gif needs at least one frame
+	if movie.Shape[2] == 0 {
+		return movie, errEmptyAnimation
+	}
+
+	return movie.downsample(factor), nil
+}
+
+// encodeGIF writes the movie as a looping grayscale gif at fps
+func encodeGIF(w *os.File, movie blobArray, fps float64) error {
+	// gif delays are in 100ths of a second and browsers slow anything under 2 right down
+	delay := int(math.Max(2, math.Round(100/fps)))
+
+	anim := gif.GIF{}
+
+	for n := 0; n < movie.Shape[2]; n++ {
+		img, err := movie.frameImage(n)
+		if err != nil {
+			return err
+		}
+
+		frame := image.NewPaletted(img.Rect, grayPalette)
+		copy(frame.Pix, img.Pix)
+
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	return gif.EncodeAll(w, &anim)
+}
+
+// cachedGIF renders a window of a scan's stimulus to ./cache/stimulus once and gives the file name
+func cachedGIF(scanID int, window *frameWindow, factor int) (string, error) {
+	if window == nil {
+		window = &frameWindow{Start: 0, Stop: -1}
+	}
+
+	filename := fmt.Sprintf("./cache/stimulus/%d_%d_%d_%d.gif", scanID, window.Start, window.Stop, factor)
+
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+
+	metadata, err := functionalStore.GetScanMetadata(scanID)
+	if err != nil {
+		return "", err
+	}
+
+	if metadata.Fps <= 0 {
+		return "", errNoFps
+	}
+
+	movie, err := getMovie(scanID)
+	if err != nil {
+		return "", err
+	}
+
+	movie, err = animationFrames(movie, window, factor)
+	if err != nil {
+		return "", err
+	}
+
+	return filename, writeCacheFile(filename, func(f *os.File) error {
+		return encodeGIF(f, movie, metadata.Fps)
+	})
+}
+
+// writeCacheFile writes a file under ./cache through a temporary name, so concurrent requests never see half of it
+func writeCacheFile(filename string, write func(*os.File) error) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// streamMJPEG plays the movie once as a multipart jpeg stream at fps, stopping when the client goes away
+func streamMJPEG(w http.ResponseWriter, r *http.Request, movie blobArray, fps float64) error {
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+parts.Boundary())
+
+	flusher, _ := w.(http.Flusher)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+	defer ticker.Stop()
+
+	for n := 0; n < movie.Shape[2]; n++ {
+		img, err := movie.frameImage(n)
+		if err != nil {
+			return err
+		}
+
+		part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"image/jpeg"}})
+		if err != nil {
+			return err
+		}
+
+		if err := jpeg.Encode(part, img, nil); err != nil {
+			return err
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-ticker.C:
+		}
+	}
+
+	return parts.Close()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"image/gif"
+	"image/jpeg"
+	"mime"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// useCacheDir runs the test in an empty directory, so files written to ./cache are thrown away
+func useCacheDir(t *testing.T) {
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func TestStimulusGIF(t *testing.T) {
+	useMovie(t)
+	useCacheDir(t)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/stimulus/1/gif/?frames=1:3", nil))
+
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/gif" {
+		t.Fatalf("status %d, content type %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	anim, err := gif.DecodeAll(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the scan is at 2 fps, so each frame shows for 50 hundredths of a second
+	if len(anim.Image) != 2 || anim.Delay[0] != 50 || anim.Image[0].Rect.Dx() != 3 || anim.Image[0].Rect.Dy() != 2 {
+		t.Fatalf("%d frames of %v, delays %v", len(anim.Image), anim.Image[0].Rect, anim.Delay)
+	}
+
+	if r, _, _, _ := anim.Image[1].At(2, 1).RGBA(); r>>8 != 212 {
+		t.Errorf("frame 2 at 2, 1 is %d, want 212", r>>8)
+	}
+
+	if _, err := os.Stat("cache/stimulus/1_1_3_1.gif"); err != nil {
+		t.Errorf("gif wasn't cached: %v", err)
+	}
+
+	runHandlerCases(t, []handlerCase{
+		// the scan has 4 frames but the movie only 3
+		{url: "/stimulus/1/gif/?frames=3:4", status: 400},
+		{url: "/stimulus/1/gif/?downsample=x", status: 400},
+		{url: "/stimulus/2/gif/", status: 404},
+	})
+}
+
+func TestStimulusMJPEG(t *testing.T) {
+	useMovie(t)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/stimulus/1/mjpeg/?frames=2:3&downsample=2", nil))
+
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != 200 || err != nil {
+		t.Fatalf("status %d, content type %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	parts := multipart.NewReader(bytes.NewReader(w.Body.Bytes()), params["boundary"])
+	frames := 0
+
+	for part, err := parts.NextPart(); err == nil; part, err = parts.NextPart() {
+		img, err := jpeg.Decode(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.Bounds().Dx() != 2 || img.Bounds().Dy() != 1 {
+			t.Errorf("frame is %v, want 2 x 1", img.Bounds())
+		}
+		frames++
+	}
+
+	if frames != 1 {
+		t.Errorf("%d frames, want 1", frames)
+	}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/stimulus/1/mjpeg/?frames=3:4", status: 400},
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const maxBatchIds = 50000
+
+var errTooManyBatchIds = fmt.Errorf("at most %d ids per request", maxBatchIds)
+
+// batchLookup maps each id to the body the single id endpoint would give, ids it would 404 on are left out
+type batchLookup func(ids []int, channelID int, coords coordinates) (map[int]interface{}, error)
+
+// batchRes keys results by id string, with null for ids that weren't found
+func batchRes(ids []int, found map[int]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(ids))
+
+	for _, id := range ids {
+		res[strconv.Itoa(id)] = found[id]
+	}
+
+	return res
+}
+
+func isSynapseBatch(ids []int, channelID int, coords coordinates) (map[int]interface{}, error) {
+	synapses, err := structuralStore.IsSynapseBatch(ids, channelID)
+
+	// like /is_synapse/, anything that isn't a synapse is false rather than missing
+	res := make(map[int]interface{})
+	for _, id := range ids {
+		res[id] = boolRes{Result: synapses[id]}
+	}
+
+	return res, err
+}
+
+func isNeuronBatch(ids []int, channelID int, coords coordinates) (map[int]interface{}, error) {
+	neurons, err := structuralStore.IsNeuronBatch(ids, channelID)
+
+	res := make(map[int]interface{})
+	for id := range neurons {
+		res[id] = boolRes{Result: true}
+	}
+
+	return res, err
+}
+
+func bboxBatch(ids []int, channelID int, coords coordinates) (map[int]interface{}, error) {
+	bboxes, err := structuralStore.GetBBoxBatch(ids, channelID)
+
+	res := make(map[int]interface{})
+	for id, bbox := range bboxes {
+		res[id] = coords.bbox(bbox)
+	}
+
+	return res, err
+}
+
+func keypointBatch(ids []int, channelID int, coords coordinates) (map[int]interface{}, error) {
+	keypoints, err := structuralStore.GetKeypointBatch(ids, channelID)
+
+	res := make(map[int]interface{})
+	for id, keypoint := range keypoints {
+		res[id] = KeypointRes{Keypoint: coords.point(keypoint)}
+	}
+
+	return res, err
+}
+
+func synapseParentBatch(ids []int, channelID int, coords coordinates) (map[int]interface{}, error) {
+	parents, err := structuralStore.GetSynapseParentsBatch(ids, channelID)
+
+	res := make(map[int]interface{})
+	for id, prePost := range parents {
+		res[id] = parentRes{ParentNeurons: map[string]int{
+			strconv.Itoa(prePost[0]): 1,
+			strconv.Itoa(prePost[1]): 2,
+		}}
+	}
+
+	return res, err
+}
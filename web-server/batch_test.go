@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func serveJSON(t *testing.T, method string, url string, body string) (int, interface{}) {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+
+	var res interface{}
+	if w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+
+	return w.Code, res
+}
+
+func TestBatchMatchesSingle(t *testing.T) {
+	useTestStores(t)
+
+	neurons, synapses := []int{101, 102, 999, 201}, []int{201, 203, 999, 101}
+
+	for _, c := range []struct {
+		batch  string
+		single string
+		ids    []int
+	}{
+		{"/is_synapse/c/e/syn/", "/is_synapse/c/e/syn/%d/", synapses},
+		{"/is_neuron/c/e/seg/", "/is_neuron/c/e/seg/%d/", neurons},
+		{"/bbox/c/e/seg/", "/bbox/c/e/seg/%d/", neurons},
+		{"/bbox/c/e/seg/?units=nm", "/bbox/c/e/seg/%d/?units=nm", neurons},
+		{"/neuron_keypoint/c/e/seg/1/", "/neuron_keypoint/c/e/seg/1/%d/", neurons},
+		{"/synapse_keypoint/c/e/syn/0/", "/synapse_keypoint/c/e/syn/0/%d/", synapses},
+		{"/synapse_parent/c/e/syn/", "/synapse_parent/c/e/syn/%d/", synapses},
+	} {
+		body, _ := json.Marshal(idsReq{Ids: c.ids})
+		status, batch := serveJSON(t, "POST", c.batch, string(body))
+
+		if status != 200 {
+			t.Errorf("POST %s: status %d", c.batch, status)
+			continue
+		}
+
+		results := batch.(map[string]interface{})
+
+		if len(results) != len(c.ids) {
+			t.Errorf("POST %s: %d results for %d ids", c.batch, len(results), len(c.ids))
+		}
+
+		// each id gets what its own request would give, and null where that would 404
+		for _, id := range c.ids {
+			url := fmt.Sprintf(c.single, id)
+			status, single := serveJSON(t, "GET", url, "")
+
+			if status == 404 {
+				single = nil
+			}
+
+			if got := results[fmt.Sprint(id)]; !reflect.DeepEqual(got, single) {
+				t.Errorf("POST %s gave %d %v, GET %s gave %v", c.batch, id, got, url, single)
+			}
+		}
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	useTestStores(t)
+
+	tooMany, _ := json.Marshal(idsReq{Ids: make([]int, maxBatchIds+1)})
+
+	runHandlerCases(t, []handlerCase{
+		{method: "POST", url: "/is_neuron/c/e/seg/", body: string(tooMany), status: 400},
+		{method: "POST", url: "/neuron_keypoint/c/e/seg/x/", body: `{"ids": [101]}`, status: 400},
+		{method: "POST", url: "/bbox/c/e/seg/?units=furlongs", body: `{"ids": [101]}`, status: 400},
+		{method: "POST", url: "/bbox/c/e/nope/", body: `{"ids": [101]}`, status: 404},
+		{method: "POST", url: "/bbox/c/e/seg/", body: `{"ids": []}`, status: 200, want: `{}`},
+	})
+}
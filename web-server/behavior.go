@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"math"
+)
+
+// behaviorColumns are the rows of the behavior table, in order
+var behaviorColumns = []string{"pupil_r", "pupil_x", "pupil_y", "treadmill"}
+
+// resample stretches data linearly over n frames, assuming both cover the whole scan
+// a frame between two samples where either is NaN is NaN
+func resample(data []float64, n int) []float64 {
+	res := make([]float64, n)
+
+	for i := range res {
+		switch {
+		case len(data) == 0:
+			res[i] = math.NaN()
+		case len(data) == 1 || n == 1:
+			res[i] = data[0]
+		default:
+			position := float64(i) * float64(len(data)-1) / float64(n-1)
+			left := int(position)
+			if left >= len(data)-1 {
+				res[i] = data[len(data)-1]
+				continue
+			}
+
+			fraction := position - float64(left)
+			if fraction == 0 {
+				res[i] = data[left]
+			} else {
+				res[i] = data[left]*(1-fraction) + data[left+1]*fraction
+			}
+		}
+	}
+
+	return res
+}
+
+// getBehavior decodes pupil and treadmill into one columns x frames array on the imaging frame clock
+// a missing recording is a row of NaN
+func getBehavior(scanID int) (blobArray, error) {
+	metadata, err := functionalStore.GetScanMetadata(scanID)
+	if err != nil {
+		return blobArray{}, err
+	}
+
+	getters := []pupilGetter{functionalStore.GetPupilR, functionalStore.GetPupilX, functionalStore.GetPupilY, functionalStore.GetTreadmill}
+	recordings := make([][]float64, len(getters))
+
+	frames := metadata.NFrames
+
+	for i, getter := range getters {
+		blob, err := getter(scanID)
+
+		if err == sql.ErrNoRows || err == nil && blob == nil {
+			continue
+		} else if err != nil {
+			return blobArray{}, err
+		}
+
+		array, err := decodeArray(blob)
+		if err != nil {
+			return blobArray{}, err
+		}
+
+		recordings[i] = array.ndarray().Data
+
+		// scans without nframes go by the longest recording
+		if metadata.NFrames <= 0 {
+			frames = Max2(frames, len(recordings[i]))
+		}
+	}
+
+	res := blobArray{Shape: []int{len(getters), frames}, Class: mxDoubleClass, Data: make([]byte, 8*len(getters)*frames)}
+
+	for i, recording := range recordings {
+		for frame, value := range resample(recording, frames) {
+			// fortran order, so each frame's columns sit together
+			binary.LittleEndian.PutUint64(res.Data[8*(i+frame*len(getters)):], math.Float64bits(value))
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func sameFloats(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if math.IsNaN(a[i]) || math.IsNaN(b[i]) {
+			if !math.IsNaN(a[i]) || !math.IsNaN(b[i]) {
+				return false
+			}
+		} else if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestResample(t *testing.T) {
+	nan := math.NaN()
+
+	for _, c := range []struct {
+		data []float64
+		n    int
+		want []float64
+	}{
+		{[]float64{0, 10}, 5, []float64{0, 2.5, 5, 7.5, 10}},
+		{[]float64{0, 1, 2, 3, 4}, 3, []float64{0, 2, 4}},
+		{[]float64{1, 2, 3}, 3, []float64{1, 2, 3}},
+		{[]float64{7}, 3, []float64{7, 7, 7}},
+		{[]float64{1, 2}, 1, []float64{1}},
+		{[]float64{}, 2, []float64{nan, nan}},
+		{[]float64{0, 1, 2, 3}, 0, []float64{}},
+		// only frames touching a NaN sample are lost
+		{[]float64{0, nan, 2, 3}, 7, []float64{0, nan, nan, nan, 2, 2.5, 3}},
+	} {
+		if got := resample(c.data, c.n); !sameFloats(got, c.want) {
+			t.Errorf("%v to %d: %v, want %v", c.data, c.n, got, c.want)
+		}
+	}
+}
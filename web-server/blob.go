@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"unicode/utf16"
+)
+
+// datajoint longblobs are "mYm\0" followed by one serialized matlab value, optionally zlib compressed behind "ZL123\0"
+
+var errBadBlob = errors.New("not a datajoint blob")
+var errUnsupportedBlob = errors.New("unsupported datajoint blob contents")
+
+type blobReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *blobReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errBadBlob
+	}
+
+	res := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return res, nil
+}
+
+func (r *blobReader) uint32() (uint32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *blobReader) uint64() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (r *blobReader) shape() ([]int, int, error) {
+	nDims, err := r.uint64()
+	if err != nil || nDims > 32 {
+		return nil, 0, errBadBlob
+	}
+
+	shape := make([]int, nDims)
+	n := 1
+
+	for i := range shape {
+		dim, err := r.uint64()
+		if err != nil || dim > uint64(len(r.data)) {
+			return nil, 0, errBadBlob
+		}
+		shape[i] = int(dim)
+		n *= shape[i]
+
+		// every element takes at least a byte
+		if n > len(r.data) {
+			return nil, 0, errBadBlob
+		}
+	}
+
+	return shape, n, nil
+}
+
+// element sizes, decoders and npy dtypes for the numeric matlab classes
+var mxNumericClasses = map[uint32]struct {
+	size   int
+	descr  string
+	decode func([]byte) float64
+}{
+	3:  {1, "|b1", func(b []byte) float64 { return float64(b[0]) }}, // logical
+	6:  {8, "<f8", func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }},
+	7:  {4, "<f4", func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }},
+	8:  {1, "|i1", func(b []byte) float64 { return float64(int8(b[0])) }},
+	9:  {1, "|u1", func(b []byte) float64 { return float64(b[0]) }},
+	10: {2, "<i2", func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) }},
+	11: {2, "<u2", func(b []byte) float64 { return float64(binary.LittleEndian.Uint16(b)) }},
+	12: {4, "<i4", func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) }},
+	13: {4, "<u4", func(b []byte) float64 { return float64(binary.LittleEndian.Uint32(b)) }},
+	14: {8, "<i8", func(b []byte) float64 { return float64(int64(binary.LittleEndian.Uint64(b))) }},
+	15: {8, "<u8", func(b []byte) float64 { return float64(binary.LittleEndian.Uint64(b)) }},
+}
+
+const mxCharClass = 4
+
+// blobArray is a numeric array as a blob stores it, elements of one matlab class in fortran order
+// the data is left undecoded so large arrays like the stimulus movie aren't blown up to float64
+type blobArray struct {
+	Shape []int
+	Class uint32
+	Data  []byte
+}
+
+func (a blobArray) len() int {
+	n := 1
+	for _, dim := range a.Shape {
+		n *= dim
+	}
+	return n
+}
+
+// at is the i-th element in fortran order
+func (a blobArray) at(i int) float64 {
+	class := mxNumericClasses[a.Class]
+	return class.decode(a.Data[i*class.size:])
+}
+
+// ndarray decodes every element into a row major float64 array
+func (a blobArray) ndarray() ndarray {
+	data := make([]float64, a.len())
+	for i := range data {
+		data[i] = a.at(i)
+	}
+	return fromColumnMajor(a.Shape, data)
+}
+
+// readArray reads the body of an "A" value, numeric arrays stay as blobArray and char arrays become strings
+func (r *blobReader) readArray() (interface{}, error) {
+	shape, n, err := r.shape()
+	if err != nil {
+		return nil, err
+	}
+
+	classID, err1 := r.uint32()
+	isComplex, err2 := r.uint32()
+
+	if err1 != nil || err2 != nil {
+		return nil, errBadBlob
+	}
+
+	if classID == mxCharClass {
+		return r.readChars(shape, n)
+	}
+
+	class, ok := mxNumericClasses[classID]
+
+	if !ok || isComplex != 0 {
+		return nil, errUnsupportedBlob
+	}
+
+	raw, err := r.next(n * class.size)
+	if err != nil {
+		return nil, err
+	}
+
+	return blobArray{Shape: shape, Class: classID, Data: raw}, nil
+}
+
+// matlab packs chars as utf-16 code units, a char matrix is one string per row
+func (r *blobReader) readChars(shape []int, n int) (interface{}, error) {
+	raw, err := r.next(2 * n)
+	if err != nil {
+		return nil, err
+	}
+
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(raw[2*i:])
+	}
+
+	if len(shape) != 2 {
+		return string(utf16.Decode(units)), nil
+	}
+
+	rows := make([]string, shape[0])
+	for row := range rows {
+		line := make([]uint16, shape[1])
+		for col := range line {
+			line[col] = units[row+col*shape[0]]
+		}
+		rows[row] = string(utf16.Decode(line))
+	}
+
+	if len(rows) == 1 {
+		return rows[0], nil
+	}
+	return rows, nil
+}
+
+// blobCell is a matlab cell array, values in fortran order
+type blobCell struct {
+	Shape  []int
+	Values []interface{}
+}
+
+// blobStruct is a matlab struct array, Values holds each element's fields in fortran order
+type blobStruct struct {
+	Shape  []int
+	Fields []string
+	Values [][]interface{}
+}
+
+// readSized reads a value prefixed with its length in bytes, as cell and struct members are
+func (r *blobReader) readSized() (interface{}, error) {
+	size, err := r.uint64()
+	if err != nil || size > uint64(len(r.data)) {
+		return nil, errBadBlob
+	}
+
+	body, err := r.next(int(size))
+	if err != nil {
+		return nil, err
+	}
+
+	return (&blobReader{data: body}).readValue()
+}
+
+func (r *blobReader) readCell() (interface{}, error) {
+	shape, n, err := r.shape()
+	if err != nil {
+		return nil, err
+	}
+
+	res := blobCell{Shape: shape, Values: make([]interface{}, n)}
+
+	for i := range res.Values {
+		res.Values[i], err = r.readSized()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (r *blobReader) readString() (string, error) {
+	end := bytes.IndexByte(r.data[r.pos:], 0)
+	if end < 0 {
+		return "", errBadBlob
+	}
+
+	res, _ := r.next(end + 1)
+	return string(res[:end]), nil
+}
+
+func (r *blobReader) readStruct() (interface{}, error) {
+	shape, n, err := r.shape()
+	if err != nil {
+		return nil, err
+	}
+
+	nFields, err := r.uint32()
+	if err != nil || int(nFields) > len(r.data) {
+		return nil, errBadBlob
+	}
+
+	res := blobStruct{Shape: shape, Fields: make([]string, nFields), Values: make([][]interface{}, n)}
+
+	for i := range res.Fields {
+		res.Fields[i], err = r.readString()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range res.Values {
+		res.Values[i] = make([]interface{}, nFields)
+
+		for j := range res.Values[i] {
+			res.Values[i][j], err = r.readSized()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// readValue reads one serialized value, dispatching on its type code
+func (r *blobReader) readValue() (interface{}, error) {
+	code, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch code[0] {
+	case 'A':
+		return r.readArray()
+	case 'C':
+		return r.readCell()
+	case 'S':
+		return r.readStruct()
+	}
+
+	return nil, errUnsupportedBlob
+}
+
+// blobPayload strips compression and the format header, leaving the serialized value
+func blobPayload(blob []byte) ([]byte, error) {
+	if bytes.HasPrefix(blob, []byte("ZL123\x00")) {
+		if len(blob) < 14 {
+			return nil, errBadBlob
+		}
+
+		size := binary.LittleEndian.Uint64(blob[6:14])
+
+		z, err := zlib.NewReader(bytes.NewReader(blob[14:]))
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+
+		blob, err = ioutil.ReadAll(z)
+		if err != nil {
+			return nil, err
+		}
+
+		if uint64(len(blob)) != size {
+			return nil, errBadBlob
+		}
+	}
+
+	if !bytes.HasPrefix(blob, []byte("mYm\x00")) && !bytes.HasPrefix(blob, []byte("dj0\x00")) {
+		return nil, errBadBlob
+	}
+
+	return blob[4:], nil
+}
+
+// decodeBlob decodes any blob into a blobArray, string, []string, blobCell or blobStruct
+func decodeBlob(blob []byte) (interface{}, error) {
+	payload, err := blobPayload(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	return (&blobReader{data: payload}).readValue()
+}
+
+// decodeArray decodes a blob holding a single numeric array, like the trace, spike and pupil columns
+func decodeArray(blob []byte) (blobArray, error) {
+	value, err := decodeBlob(blob)
+	if err != nil {
+		return blobArray{}, err
+	}
+
+	array, ok := value.(blobArray)
+	if !ok {
+		return blobArray{}, errUnsupportedBlob
+	}
+
+	return array, nil
+}
+
+// encodeStructBlob encodes a 1x1 struct of arrays, which datajoint.blob.unpack reads as a record with those fields
+func encodeStructBlob(names []string, values []ndarray) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("mYm\x00S")
+
+	for _, v := range []uint64{2, 1, 1} { // 2 dims, 1 x 1
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, uint32(len(names)))
+	for _, name := range names {
+		buf.WriteString(name + "\x00")
+	}
+
+	for _, value := range values {
+		field := value.djArray()
+		binary.Write(&buf, binary.LittleEndian, uint64(len(field)))
+		buf.Write(field)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestArrayBlobRoundTrip(t *testing.T) {
+	for _, a := range []ndarray{
+		{Shape: []int{1, 1}, Data: []float64{3}},
+		{Shape: []int{1, 4}, Data: []float64{1, -2, 3.5, 0}},
+		{Shape: []int{2, 3}, Data: []float64{1, 2, 3, 4, 5, 6}},
+		{Shape: []int{2, 3, 2}, Data: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+	} {
+		array, err := decodeArray(a.djBlob())
+		if err != nil {
+			t.Fatalf("%v: %v", a.Shape, err)
+		}
+
+		if got := array.ndarray(); !reflect.DeepEqual(got, a) {
+			t.Errorf("round trip of %v gave %v", a, got)
+		}
+	}
+}
+
+func TestCompressedBlob(t *testing.T) {
+	a := ndarray{Shape: []int{2, 2}, Data: []float64{1, 2, 3, 4}}
+	raw := a.djBlob()
+
+	var buf bytes.Buffer
+	buf.WriteString("ZL123\x00")
+	binary.Write(&buf, binary.LittleEndian, uint64(len(raw)))
+	z := zlib.NewWriter(&buf)
+	z.Write(raw)
+	z.Close()
+
+	array, err := decodeArray(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := array.ndarray(); !reflect.DeepEqual(got, a) {
+		t.Errorf("got %v, want %v", got, a)
+	}
+
+	// the stored length has to match what decompresses
+	corrupt := buf.Bytes()
+	corrupt[6]++
+	if _, err := decodeArray(corrupt); err != errBadBlob {
+		t.Errorf("wrong length gave %v, want errBadBlob", err)
+	}
+}
+
+func TestStructBlobRoundTrip(t *testing.T) {
+	blob := encodeStructBlob([]string{"onset", "direction"}, []ndarray{
+		{Shape: []int{1, 1}, Data: []float64{8}},
+		{Shape: []int{1, 2}, Data: []float64{45, 90}}})
+
+	value, err := decodeBlob(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := value.(blobStruct)
+	if !ok {
+		t.Fatalf("decoded a %T, want blobStruct", value)
+	}
+
+	if !reflect.DeepEqual(s.Fields, []string{"onset", "direction"}) || len(s.Values) != 1 {
+		t.Fatalf("fields %v with %d elements", s.Fields, len(s.Values))
+	}
+
+	if onset, ok := scalarField(s, 0, "onset"); !ok || onset != 8 {
+		t.Errorf("onset %v %v, want 8", onset, ok)
+	}
+
+	direction := s.Values[0][1].(blobArray).ndarray()
+	if !reflect.DeepEqual(direction.Data, []float64{45, 90}) {
+		t.Errorf("direction %v, want [45 90]", direction.Data)
+	}
+}
+
+func TestBadBlob(t *testing.T) {
+	for _, blob := range [][]byte{nil, []byte("not a blob"), []byte("ZL123\x00")} {
+		if _, err := decodeBlob(blob); err != errBadBlob {
+			t.Errorf("%q gave %v, want errBadBlob", blob, err)
+		}
+	}
+
+	// a struct is a blob, just not an array
+	if _, err := decodeArray(encodeStructBlob([]string{"a"}, []ndarray{{Shape: []int{1, 1}, Data: []float64{1}}})); err != errUnsupportedBlob {
+		t.Errorf("struct as an array gave %v, want errUnsupportedBlob", err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BossClient is every request the handlers make against boss (or anything serving the same api)
+type BossClient interface {
+	// ctx cancels the request to boss, usually the incoming request's context
+	GetUniqueIdsInRegion(ctx context.Context, channel string, bbox BBox, resolution uint64) (IdsInRegionRes, error)
+}
+
+var bossClient BossClient
+
+// bossTimeout ends a boss request that hangs, whether or not the caller cancels it
+const bossTimeout = 30 * time.Second
+
+var bossHTTPClient = &http.Client{Timeout: bossTimeout}
+
+// httpBossClient talks to a boss compatible service over http
+type httpBossClient struct {
+	config bossConfig
+}
+
+// we don't convert from string to number because we proxy this result for service 2 and 6 and JSON 64 bit integers need to be strings
+func (c *httpBossClient) GetUniqueIdsInRegion(ctx context.Context, channel string, bbox BBox, resolution uint64) (IdsInRegionRes, error) {
+	// adding 1 to max because boss ranges are inclusive exclusive
+	url := fmt.Sprintf(c.config.URL+"ids/%s/%d/%d:%d/%d:%d/%d:%d/", channel, resolution, bbox.MIN.X, bbox.MAX.X+1, bbox.MIN.Y, bbox.MAX.Y+1, bbox.MIN.Z, bbox.MAX.Z+1)
+
+	var ids IdsInRegionRes
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return ids, err
+	}
+	request.Header.Set("Authorization", c.config.AuthToken)
+
+	resp, err := bossHTTPClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return ids, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return ids, fmt.Errorf("http error, status code: %d url: %s", resp.StatusCode, url)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ids, err
+	}
+
+	err = json.Unmarshal(body, &ids)
+
+	return ids, err
+}
+
+// labeledVolume is a dense block of full resolution boss ids, 0 is background
+type labeledVolume struct {
+	Origin Vector3 `json:"origin"`
+	Size   Vector3 `json:"size"`
+	Labels []int   `json:"labels"` // x varies fastest, then y, then z
+}
+
+func (v labeledVolume) label(p Vector3) int {
+	rel := subVectors(p, v.Origin)
+
+	if !rel.Inside(BBox{MAX: subVectors(v.Size, Vector3{1, 1, 1})}) {
+		return 0
+	}
+
+	idx := rel.X + v.Size.X*(rel.Y+v.Size.Y*rel.Z)
+	if idx >= len(v.Labels) {
+		return 0
+	}
+
+	return v.Labels[idx]
+}
+
+// uniqueIds lists the labels in a bbox given in downsampled coordinates
+// each downsampled voxel takes the label of its first full resolution voxel
+func (v labeledVolume) uniqueIds(bbox BBox, calibration channelCalibration, resolution uint64) IdsInRegionRes {
+	extent := BBox{
+		MIN: calibration.downsample(v.Origin, resolution),
+		MAX: calibration.downsample(subVectors(addVectors(v.Origin, v.Size), Vector3{1, 1, 1}), resolution)}
+
+	res := IdsInRegionRes{Ids: make([]string, 0)}
+
+	region, err := bbox.Intersection(extent)
+
+	if err != nil {
+		return res
+	}
+
+	seen := make(map[int]bool)
+
+	for z := region.MIN.Z; z <= region.MAX.Z; z++ {
+		for y := region.MIN.Y; y <= region.MAX.Y; y++ {
+			for x := region.MIN.X; x <= region.MAX.X; x++ {
+				id := v.label(calibration.upsample(Vector3{x, y, z}, resolution))
+				if id != 0 {
+					seen[id] = true
+				}
+			}
+		}
+	}
+
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		res.Ids = append(res.Ids, strconv.Itoa(id))
+	}
+
+	return res
+}
+
+// localBoss is a stand in for boss, serving ids/{channel}/{res}/{x}/{y}/{z}/ from in memory volumes keyed by channel
+type localBoss struct {
+	Volumes map[string]labeledVolume `json:"volumes"`
+}
+
+func parseBossRange(s string) (int, int, error) {
+	bounds := strings.Split(s, ":")
+
+	if len(bounds) != 2 {
+		return 0, 0, errors.New("each range should be two integers seperated by a colon")
+	}
+
+	min, err1 := strconv.Atoi(bounds[0])
+	max, err2 := strconv.Atoi(bounds[1])
+
+	if err1 != nil {
+		return 0, 0, err1
+	}
+
+	return min, max, err2
+}
+
+func (b *localBoss) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// ids / collection / experiment / layer / resolution / x / y / z
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if len(parts) != 8 || parts[0] != "ids" {
+		httpError(w, http.StatusNotFound, nil)
+		return
+	}
+
+	channel := strings.Join(parts[1:4], "/")
+	volume, ok := b.Volumes[channel]
+
+	if !ok {
+		httpError(w, http.StatusNotFound, nil)
+		return
+	}
+
+	resolution, parseError := strconv.ParseUint(parts[4], 10, 0)
+
+	if parseError != nil {
+		httpError(w, http.StatusBadRequest, parseError)
+		return
+	}
+
+	var bbox BBox
+	var errs [3]error
+
+	// boss ranges are inclusive exclusive
+	bbox.MIN.X, bbox.MAX.X, errs[0] = parseBossRange(parts[5])
+	bbox.MIN.Y, bbox.MAX.Y, errs[1] = parseBossRange(parts[6])
+	bbox.MIN.Z, bbox.MAX.Z, errs[2] = parseBossRange(parts[7])
+	bbox.MAX = subVectors(bbox.MAX, Vector3{1, 1, 1})
+
+	for _, err := range errs {
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// downsample like the channel does in the database, if it is there
+	calibration := defaultCalibration
+
+	if structuralStore != nil {
+		if channelID, err := structuralStore.GetChannelFromString(channel); err == nil {
+			if c, err := getChannelCalibration(channelID); err == nil {
+				calibration = c
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(volume.uniqueIds(bbox, calibration, resolution))
+}
+
+func loadLocalBoss(path string) (*localBoss, error) {
+	res := &localBoss{}
+
+	file, openError := os.Open(path)
+	if openError != nil {
+		return res, openError
+	}
+	defer file.Close()
+
+	decodeError := json.NewDecoder(file).Decode(res)
+
+	return res, decodeError
+}
+
+// startLocalBoss serves the stand in on a free local port and returns its base url
+func startLocalBoss(b *localBoss) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return "", err
+	}
+
+	go http.Serve(listener, b)
+
+	return fmt.Sprintf("http://%s/", listener.Addr()), nil
+}
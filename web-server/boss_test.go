@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// useLocalBoss serves labeled volumes matching useTestStores from a local boss for the test
+// in c/e/seg neurons 101 and 102 take the first row of a 4x2x1 block and 103 most of the second
+// in c/e/syn synapse 201 has one voxel labeled at 2, 2, 0 and 202 at 9, 9, 1
+func useLocalBoss(t *testing.T) {
+	synapses := make([]int, 10*10*2)
+	synapses[2+10*2] = 201
+	synapses[9+10*9+10*10] = 202
+
+	url, err := startLocalBoss(&localBoss{Volumes: map[string]labeledVolume{
+		"c/e/seg": {Origin: Vector3{0, 0, 0}, Size: Vector3{4, 2, 1}, Labels: []int{101, 101, 102, 102, 0, 103, 103, 103}},
+		"c/e/syn": {Origin: Vector3{0, 0, 0}, Size: Vector3{10, 10, 2}, Labels: synapses},
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withBoss(t, &httpBossClient{bossConfig{URL: url}})
+}
+
+func TestLocalBossClient(t *testing.T) {
+	useTestStores(t)
+	useLocalBoss(t)
+
+	ids, err := bossClient.GetUniqueIdsInRegion(context.Background(), "c/e/seg", BBox{Vector3{0, 0, 0}, Vector3{1, 1, 0}}, 0)
+	if err != nil || len(ids.Ids) != 2 || ids.Ids[0] != "101" || ids.Ids[1] != "103" {
+		t.Errorf("got %v %v, want 101 and 103", ids.Ids, err)
+	}
+
+	if _, err := bossClient.GetUniqueIdsInRegion(context.Background(), "c/e/nope", BBox{}, 0); err == nil {
+		t.Error("a channel boss doesn't have gave no error")
+	}
+}
+
+func TestIdsHandlerBoss(t *testing.T) {
+	useTestStores(t)
+	useLocalBoss(t)
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/neuron_ids/c/e/seg/0/0,4/0,2/0,1/", status: 200, want: `{"ids":["101","102","103"]}`},
+		{url: "/neuron_ids/c/e/seg/0/0,1/1,2/0,1/", status: 200, want: `{"ids":[]}`},
+		{url: "/neuron_ids/c/e/seg/0/100,200/0,2/0,1/", status: 200, want: `{"ids":[]}`},
+		// at resolution 1 each voxel is the first of 2 in x and y
+		{url: "/neuron_ids/c/e/seg/1/0,2/0,1/0,1/", status: 200, want: `{"ids":["101","102"]}`},
+		{url: "/neuron_ids/c/e/seg/1/1,2/0,1/0,1/", status: 200, want: `{"ids":["102"]}`},
+		// nm are converted with the channel's calibration, 4 x 4 x 40 by default
+		{url: "/neuron_ids/c/e/seg/0/8,12/0,8/0,40/?units=nm", status: 200, want: `{"ids":["102","103"]}`},
+		// and the max is exclusive, the voxel at y 1 starts at 4 nm
+		{url: "/neuron_ids/c/e/seg/0/8,12/0,4/0,40/?units=nm", status: 200, want: `{"ids":["102"]}`},
+		{url: "/neuron_ids/c/e/seg/0/0,1,2/0,2/0,1/", status: 400},
+	})
+}
+
+func TestNeuronChildrenBoss(t *testing.T) {
+	useTestStores(t)
+	useLocalBoss(t)
+
+	runHandlerCases(t, []handlerCase{
+		// 201 and 202 only partly overlap the region, so boss decides, and only 201 has a voxel in it
+		{url: "/neuron_children/c/e/seg/0/1,8/1,8/0,2/101/", status: 200, want: `{"child_synapses":{"201":1}}`},
+		{url: "/neuron_children/c/e/seg/0/1,10/1,10/0,2/101/", status: 200, want: `{"child_synapses":{"201":1,"202":1}}`},
+		// checking bboxes alone doesn't ask boss
+		{url: "/neuron_children/c/e/seg/0/1,8/1,8/0,2/101/?filter=bbox", status: 200, want: `{"child_synapses":{"201":1,"202":1}}`},
+		{url: "/neuron_children/c/e/seg/0/1,8/1,8/0,2/999/", status: 404},
+	})
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxResolution keeps downsampling factors from overflowing
+const maxResolution = 30
+
+// maxScale bounds each dimension of an extrapolated downsampling factor, the most the default scheme reaches
+const maxScale = 1 << maxResolution
+
+var errBadUnits = errors.New("units should be voxels or nm")
+
+// channelCalibration places a channel's full resolution voxels in physical space
+type channelCalibration struct {
+	VoxelSize Vector3f  `json:"voxel_size"` // nm per full resolution voxel
+	Origin    Vector3f  `json:"origin"`     // nm position of voxel 0, 0, 0
+	Mips      []Vector3 `json:"mips"`       // full resolution voxels per voxel at each resolution, empty for 2x in x and y per level
+}
+
+// listedMips is Mips with the default levels spelled out, down to the one a whole extent fits in one voxel of
+func (c channelCalibration) listedMips(extent *BBox) []Vector3 {
+	if len(c.Mips) > 0 {
+		return c.Mips
+	}
+
+	res := []Vector3{c.scale(0)}
+
+	for level := uint64(1); extent != nil && level <= maxResolution; level++ {
+		prev := res[len(res)-1]
+
+		if extent.MIN.X/prev.X == extent.MAX.X/prev.X && extent.MIN.Y/prev.Y == extent.MAX.Y/prev.Y {
+			break
+		}
+
+		res = append(res, c.scale(level))
+	}
+
+	return res
+}
+
+// defaultCalibration is what channels without a channel_calibration row get
+var defaultCalibration = channelCalibration{VoxelSize: Vector3f{4, 4, 40}}
+
+// calibrations of each channel, read the first time a channel is asked for
+var calibrations = struct {
+	sync.Mutex
+	byChannel map[int]channelCalibration
+}{byChannel: make(map[int]channelCalibration)}
+
+// getChannelCalibration is GetChannelCalibration checked once and kept for the life of the process
+func getChannelCalibration(channelID int) (channelCalibration, error) {
+	calibrations.Lock()
+	defer calibrations.Unlock()
+
+	if calibration, ok := calibrations.byChannel[channelID]; ok {
+		return calibration, nil
+	}
+
+	calibration, err := structuralStore.GetChannelCalibration(channelID)
+	if err != nil {
+		return calibration, err
+	}
+
+	if err := calibration.check(); err != nil {
+		return calibration, fmt.Errorf("channel %d: %v", channelID, err)
+	}
+
+	calibrations.byChannel[channelID] = calibration
+
+	return calibration, nil
+}
+
+// check rejects calibrations that would divide by zero or flip coordinates
+// each mip has to downsample at least as much as the one before, so extrapolated levels never shrink to 0
+func (c channelCalibration) check() error {
+	for _, size := range []float64{c.VoxelSize.X, c.VoxelSize.Y, c.VoxelSize.Z} {
+		if !(size > 0) || math.IsInf(size, 0) {
+			return fmt.Errorf("voxel size %v should be positive", c.VoxelSize)
+		}
+	}
+
+	prev := Vector3{1, 1, 1}
+
+	for mip, scale := range c.Mips {
+		if scale.X < prev.X || scale.Y < prev.Y || scale.Z < prev.Z {
+			return fmt.Errorf("mip %d downsamples by %v, less than 1 or the mip before", mip, scale)
+		}
+		prev = scale
+	}
+
+	return nil
+}
+
+// Vector3f is a position in nm
+type Vector3f struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// BBoxf is a bbox in nm
+type BBoxf struct {
+	MIN Vector3f `json:"min"`
+	MAX Vector3f `json:"max"`
+}
+
+// scale is how many full resolution voxels one voxel at resolution spans in each dimension
+// levels past the listed mips repeat the last step
+func (c channelCalibration) scale(resolution uint64) Vector3 {
+	if resolution > maxResolution {
+		resolution = maxResolution
+	}
+
+	if len(c.Mips) == 0 {
+		return Vector3{1 << resolution, 1 << resolution, 1}
+	}
+
+	if resolution < uint64(len(c.Mips)) {
+		return c.Mips[resolution]
+	}
+
+	last := c.Mips[len(c.Mips)-1]
+	step := Vector3{2, 2, 1}
+
+	if len(c.Mips) > 1 {
+		prev := c.Mips[len(c.Mips)-2]
+		step = Vector3{last.X / prev.X, last.Y / prev.Y, last.Z / prev.Z}
+	}
+
+	for level := uint64(len(c.Mips)); level <= resolution; level++ {
+		last = Vector3{scaleStep(last.X, step.X), scaleStep(last.Y, step.Y), scaleStep(last.Z, step.Z)}
+	}
+
+	return last
+}
+
+// scaleStep multiplies a downsampling factor by step, clamped to maxScale before it can overflow
+// a listed factor already past maxScale is kept as is
+func scaleStep(factor int, step int) int {
+	if factor >= maxScale {
+		return factor
+	} else if step > maxScale/factor {
+		return maxScale
+	}
+	return factor * step
+}
+
+// downsample converts a full resolution vector to coordinates at resolution
+func (c channelCalibration) downsample(v Vector3, resolution uint64) Vector3 {
+	s := c.scale(resolution)
+	return Vector3{v.X / s.X, v.Y / s.Y, v.Z / s.Z}
+}
+
+// upsample converts a vector at resolution to the full resolution voxel at its corner
+func (c channelCalibration) upsample(v Vector3, resolution uint64) Vector3 {
+	s := c.scale(resolution)
+	return Vector3{v.X * s.X, v.Y * s.Y, v.Z * s.Z}
+}
+
+// toNm is the position of the corner of a full resolution voxel
+func (c channelCalibration) toNm(v Vector3) Vector3f {
+	return Vector3f{
+		c.Origin.X + float64(v.X)*c.VoxelSize.X,
+		c.Origin.Y + float64(v.Y)*c.VoxelSize.Y,
+		c.Origin.Z + float64(v.Z)*c.VoxelSize.Z,
+	}
+}
+
+// fromNm is the full resolution voxel containing a position
+func (c channelCalibration) fromNm(p Vector3f) Vector3 {
+	return Vector3{
+		int(math.Floor((p.X - c.Origin.X) / c.VoxelSize.X)),
+		int(math.Floor((p.Y - c.Origin.Y) / c.VoxelSize.Y)),
+		int(math.Floor((p.Z - c.Origin.Z) / c.VoxelSize.Z)),
+	}
+}
+
+// lastBefore is the last full resolution voxel starting before a position, fromNm for a max exclusive bound
+func (c channelCalibration) lastBefore(p Vector3f) Vector3 {
+	return Vector3{
+		int(math.Ceil((p.X-c.Origin.X)/c.VoxelSize.X)) - 1,
+		int(math.Ceil((p.Y-c.Origin.Y)/c.VoxelSize.Y)) - 1,
+		int(math.Ceil((p.Z-c.Origin.Z)/c.VoxelSize.Z)) - 1,
+	}
+}
+
+// coordinates is how a request gives and wants positions, voxels at resolution or nm
+type coordinates struct {
+	calibration channelCalibration
+	resolution  uint64
+	nm          bool
+}
+
+// parseUnits reads ?units=, voxels when unset
+func parseUnits(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("units") {
+	case "", "voxels":
+		return false, nil
+	case "nm":
+		return true, nil
+	}
+	return false, errBadUnits
+}
+
+func getCoordinates(channelID int, resolution uint64, nm bool) (coordinates, error) {
+	calibration, err := getChannelCalibration(channelID)
+	return coordinates{calibration, resolution, nm}, err
+}
+
+// point is a full resolution position as the request wants it
+func (c coordinates) point(v Vector3) [3]float64 {
+	if c.nm {
+		p := c.calibration.toNm(v)
+		return [...]float64{p.X, p.Y, p.Z}
+	}
+
+	v = c.calibration.downsample(v, c.resolution)
+	return [...]float64{float64(v.X), float64(v.Y), float64(v.Z)}
+}
+
+// bbox is a full resolution bbox as the request wants it
+func (c coordinates) bbox(b BBox) interface{} {
+	if c.nm {
+		return BBoxf{c.calibration.toNm(b.MIN), c.calibration.toNm(b.MAX)}
+	}
+
+	return BBox{c.calibration.downsample(b.MIN, c.resolution), c.calibration.downsample(b.MAX, c.resolution)}
+}
+
+// fromNm is the voxel at the request's resolution containing a position
+func (c coordinates) fromNm(p Vector3f) Vector3 {
+	return c.calibration.downsample(c.calibration.fromNm(p), c.resolution)
+}
+
+// full converts a vector at the request's resolution to full resolution
+func (c coordinates) full(v Vector3) Vector3 {
+	return c.calibration.upsample(v, c.resolution)
+}
+
+// parseRegion reads the xrange, yrange and zrange params into voxels at the request's resolution
+// nm ranges are min inclusive and max exclusive like voxel ranges, a voxel starting at max is left out
+func (c coordinates) parseRegion(ps httprouter.Params) (BBox, error) {
+	if !c.nm {
+		return parseBBox(ps)
+	}
+
+	var region BBoxf
+	var errs [3]error
+
+	region.MIN.X, region.MAX.X, errs[0] = parseFloatRange(ps.ByName("xrange"))
+	region.MIN.Y, region.MAX.Y, errs[1] = parseFloatRange(ps.ByName("yrange"))
+	region.MIN.Z, region.MAX.Z, errs[2] = parseFloatRange(ps.ByName("zrange"))
+
+	for _, err := range errs {
+		if err != nil {
+			return BBox{}, err
+		}
+	}
+
+	return BBox{c.fromNm(region.MIN), c.calibration.downsample(c.calibration.lastBefore(region.MAX), c.resolution)}, nil
+}
+
+// parsePoint reads the x, y and z params into a full resolution voxel
+func (c coordinates) parsePoint(ps httprouter.Params) (Vector3, error) {
+	var p Vector3f
+	var errs [3]error
+
+	p.X, errs[0] = strconv.ParseFloat(ps.ByName("x"), 64)
+	p.Y, errs[1] = strconv.ParseFloat(ps.ByName("y"), 64)
+	p.Z, errs[2] = strconv.ParseFloat(ps.ByName("z"), 64)
+
+	for _, err := range errs {
+		if err != nil {
+			return Vector3{}, err
+		}
+	}
+
+	if c.nm {
+		return c.calibration.fromNm(p), nil
+	}
+
+	// voxels may be given as whole numbers only
+	v := Vector3{int(p.X), int(p.Y), int(p.Z)}
+
+	if float64(v.X) != p.X || float64(v.Y) != p.Y || float64(v.Z) != p.Z {
+		return Vector3{}, errors.New("voxel coordinates should be integers")
+	}
+
+	return c.full(v), nil
+}
+
+func parseFloatRange(s string) (float64, float64, error) {
+	bounds := strings.Split(s, ",")
+
+	if len(bounds) != 2 {
+		return 0, 0, errors.New("each range should be two numbers seperated by a comma")
+	}
+
+	min, err1 := strconv.ParseFloat(bounds[0], 64)
+	max, err2 := strconv.ParseFloat(bounds[1], 64)
+
+	if err1 != nil {
+		return 0, 0, err1
+	}
+
+	return min, max, err2
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestCalibrationScale(t *testing.T) {
+	anisotropic := channelCalibration{VoxelSize: Vector3f{4, 4, 40}, Mips: []Vector3{{1, 1, 1}, {2, 2, 1}, {4, 4, 2}}}
+
+	for _, c := range []struct {
+		calibration channelCalibration
+		resolution  uint64
+		want        Vector3
+	}{
+		// no mips is 2x in x and y per level
+		{defaultCalibration, 0, Vector3{1, 1, 1}},
+		{defaultCalibration, 3, Vector3{8, 8, 1}},
+		{defaultCalibration, 100, Vector3{1 << maxResolution, 1 << maxResolution, 1}},
+		// listed mips are used as is
+		{anisotropic, 1, Vector3{2, 2, 1}},
+		{anisotropic, 2, Vector3{4, 4, 2}},
+		// past them the last step repeats
+		{anisotropic, 3, Vector3{8, 8, 4}},
+		{anisotropic, 4, Vector3{16, 16, 8}},
+		// a single mip repeats 2x in x and y
+		{channelCalibration{VoxelSize: Vector3f{1, 1, 1}, Mips: []Vector3{{3, 3, 3}}}, 2, Vector3{12, 12, 3}},
+		// big steps stop at maxScale instead of overflowing
+		{channelCalibration{VoxelSize: Vector3f{1, 1, 1}, Mips: []Vector3{{1, 1, 1}, {1 << 20, 1 << 20, 1}}}, maxResolution, Vector3{maxScale, maxScale, 1}},
+		{channelCalibration{VoxelSize: Vector3f{1, 1, 1}, Mips: []Vector3{{1, 1, 1}, {3, maxScale, 1}}}, 5, Vector3{243, maxScale, 1}},
+	} {
+		if got := c.calibration.scale(c.resolution); got != c.want {
+			t.Errorf("%v at %d: %v, want %v", c.calibration.Mips, c.resolution, got, c.want)
+		}
+	}
+
+	if got := anisotropic.downsample(Vector3{17, 9, 5}, 2); got != (Vector3{4, 2, 2}) {
+		t.Errorf("downsample gave %v", got)
+	}
+
+	if got := anisotropic.upsample(Vector3{4, 2, 2}, 2); got != (Vector3{16, 8, 4}) {
+		t.Errorf("upsample gave %v", got)
+	}
+}
+
+func TestCalibrationNm(t *testing.T) {
+	c := channelCalibration{VoxelSize: Vector3f{4, 4, 40}, Origin: Vector3f{100, -100, 0}}
+
+	if got := c.toNm(Vector3{1, 2, 3}); got != (Vector3f{104, -92, 120}) {
+		t.Errorf("toNm gave %v", got)
+	}
+
+	for _, p := range []Vector3{{0, 0, 0}, {1, 2, 3}, {-5, 7, 11}} {
+		if got := c.fromNm(c.toNm(p)); got != p {
+			t.Errorf("%v went to %v and back to %v", p, c.toNm(p), got)
+		}
+	}
+
+	// positions inside a voxel belong to it
+	if got := c.fromNm(Vector3f{103.9, -96.1, 39.9}); got != (Vector3{0, 0, 0}) {
+		t.Errorf("fromNm gave %v", got)
+	}
+}
+
+func TestCalibrationCheck(t *testing.T) {
+	for _, c := range []channelCalibration{
+		defaultCalibration,
+		{VoxelSize: Vector3f{1, 1, 1}, Mips: []Vector3{{1, 1, 1}, {2, 2, 1}, {2, 2, 2}}},
+	} {
+		if err := c.check(); err != nil {
+			t.Errorf("%v: %v", c, err)
+		}
+	}
+
+	for _, c := range []channelCalibration{
+		{VoxelSize: Vector3f{0, 4, 40}},
+		{VoxelSize: Vector3f{4, -4, 40}},
+		{VoxelSize: Vector3f{4, 4, math.NaN()}},
+		{VoxelSize: Vector3f{4, 4, math.Inf(1)}},
+		{VoxelSize: Vector3f{4, 4, 40}, Mips: []Vector3{{0, 1, 1}}},
+		{VoxelSize: Vector3f{4, 4, 40}, Mips: []Vector3{{1, 1, 1}, {4, 4, 1}, {2, 2, 1}}},
+	} {
+		if err := c.check(); err == nil {
+			t.Errorf("%v passed", c)
+		}
+	}
+}
+
+func TestCalibrationRegion(t *testing.T) {
+	useTestStores(t)
+
+	for _, c := range []struct {
+		resolution uint64
+		nm         bool
+		ranges     [3]string
+		want       BBox
+	}{
+		// voxel ranges are max exclusive
+		{0, false, [3]string{"8,12", "0,4", "0,2"}, BBox{Vector3{8, 0, 0}, Vector3{11, 3, 1}}},
+		// and so are nm ones, a max on a voxel boundary leaves that voxel out
+		{0, true, [3]string{"8,12", "0,4", "0,40"}, BBox{Vector3{2, 0, 0}, Vector3{2, 0, 0}}},
+		{0, true, [3]string{"8,12.5", "0,3.9", "0,40.1"}, BBox{Vector3{2, 0, 0}, Vector3{3, 0, 1}}},
+		// at mip 1 voxels are 8 nm in x and y
+		{1, true, [3]string{"8,32", "0,16", "0,40"}, BBox{Vector3{1, 0, 0}, Vector3{3, 1, 0}}},
+	} {
+		coords, err := getCoordinates(1, c.resolution, c.nm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ps := httprouter.Params{{Key: "xrange", Value: c.ranges[0]}, {Key: "yrange", Value: c.ranges[1]}, {Key: "zrange", Value: c.ranges[2]}}
+
+		if got, err := coords.parseRegion(ps); err != nil || got != c.want {
+			t.Errorf("%v at %d, nm %v: %v %v, want %v", c.ranges, c.resolution, c.nm, got, err, c.want)
+		}
+	}
+}
+
+func TestListedMips(t *testing.T) {
+	listed := []Vector3{{1, 1, 1}, {2, 2, 2}}
+	if got := (channelCalibration{Mips: listed}).listedMips(nil); !reflect.DeepEqual(got, listed) {
+		t.Errorf("listed mips came out as %v", got)
+	}
+
+	if got := defaultCalibration.listedMips(nil); !reflect.DeepEqual(got, []Vector3{{1, 1, 1}}) {
+		t.Errorf("no extent gave %v, want mip 0 only", got)
+	}
+
+	// 3 to 9 fits in one voxel of 16
+	want := []Vector3{{1, 1, 1}, {2, 2, 1}, {4, 4, 1}, {8, 8, 1}, {16, 16, 1}}
+	if got := defaultCalibration.listedMips(&BBox{Vector3{3, 3, 0}, Vector3{9, 4, 100}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
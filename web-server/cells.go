@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+type cellBatchGetter func(scanID int, slice int, cellIDs []int) ([]cellData, error)
+
+// stackedCells is one row per cell and slice, Index rows are [requested id, em_id, slice]
+type stackedCells struct {
+	Data  ndarray
+	Index ndarray
+}
+
+// getStackedCells decodes a blob per cell and stacks them into cells x frames, shorter rows are padded with NaN
+// emIDs maps each requested id to its em_id, rows follow the order of ids then slice
+func getStackedCells(batch cellBatchGetter, scanID int, slice int, ids []int, emIDs map[int]int) (stackedCells, error) {
+	ids = dedupeIds(ids)
+
+	lookup := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if emID, ok := emIDs[id]; ok {
+			lookup = append(lookup, emID)
+		}
+	}
+
+	cells, err := batch(scanID, slice, lookup)
+	if err != nil {
+		return stackedCells{}, err
+	}
+
+	byEmID := make(map[int][]cellData)
+	for _, cell := range cells {
+		byEmID[cell.EmID] = append(byEmID[cell.EmID], cell)
+	}
+
+	rows := make([][]float64, 0, len(cells))
+	index := make([]float64, 0, 3*len(cells))
+	frames := 0
+
+	for _, id := range ids {
+		emID, ok := emIDs[id]
+		if !ok {
+			continue
+		}
+
+		slices := byEmID[emID]
+		sort.Slice(slices, func(i, j int) bool { return slices[i].Slice < slices[j].Slice })
+
+		for _, cell := range slices {
+			array, err := decodeArray(cell.Data)
+			if err != nil {
+				return stackedCells{}, err
+			}
+
+			data := array.ndarray().Data
+
+			rows = append(rows, data)
+			index = append(index, float64(id), float64(emID), float64(cell.Slice))
+			frames = Max2(frames, len(data))
+		}
+	}
+
+	res := stackedCells{
+		Data:  newNdarray(len(rows), frames),
+		Index: ndarray{Shape: []int{len(rows), 3}, Data: index}}
+
+	for i, row := range rows {
+		for j := 0; j < frames; j++ {
+			if j < len(row) {
+				res.Data.Data[i*frames+j] = row[j]
+			} else {
+				res.Data.Data[i*frames+j] = math.NaN()
+			}
+		}
+	}
+
+	return res, nil
+}
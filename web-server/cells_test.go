@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStackedCells(t *testing.T) {
+	_, functional := useTestStores(t)
+	functional.Cells = append(functional.Cells,
+		memoryCell{Scan: 1, Slice: 2, EmID: 1000, Trace: ndarray{Shape: []int{1, 2}, Data: []float64{5, 6}}.djBlob()},
+		memoryCell{Scan: 1, Slice: 1, EmID: 3000, Trace: ndarray{Shape: []int{1, 3}, Data: []float64{7, 8, 9}}.djBlob()})
+
+	// 102 has no em_id and 5000 no trace, so both are left out, and repeats are dropped
+	stacked, err := getStackedCells(functional.GetTraceBatch, 1, allSlices, []int{3, 1, 2, 5, 1}, map[int]int{1: 1000, 3: 3000, 5: 5000})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rows follow the requested ids, then slice, shorter rows padded with NaN
+	nan := math.NaN()
+	if !reflect.DeepEqual(stacked.Data.Shape, []int{3, 4}) || !sameFloats(stacked.Data.Data, []float64{7, 8, 9, nan, 1, 2, 3, 4, 5, 6, nan, nan}) {
+		t.Errorf("data %v %v", stacked.Data.Shape, stacked.Data.Data)
+	}
+
+	if !reflect.DeepEqual(stacked.Index.Data, []float64{3, 3000, 1, 1, 1000, 1, 1, 1000, 2}) {
+		t.Errorf("index %v", stacked.Index.Data)
+	}
+}
+
+func TestCellBatchFormats(t *testing.T) {
+	useTestStores(t)
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, httptest.NewRequest("POST", url, strings.NewReader(`{"ids": [101, 102]}`)))
+		return w
+	}
+
+	if w := serve("/traces/c/e/seg/1/?format=npz"); w.Code != 200 || !bytes.HasPrefix(w.Body.Bytes(), []byte("PK")) {
+		t.Errorf("npz gave %d %q", w.Code, w.Body.Bytes())
+	}
+
+	w := serve("/traces/c/e/seg/1/")
+	value, err := decodeBlob(w.Body.Bytes())
+
+	if w.Code != 200 || err != nil {
+		t.Fatalf("blob gave %d %v", w.Code, err)
+	}
+
+	if s, ok := value.(blobStruct); !ok || !reflect.DeepEqual(s.Fields, []string{"data", "index"}) {
+		t.Errorf("blob %v isn't a struct of data and index", value)
+	}
+
+	// only blob and npz can hold the stacked arrays, anything else is refused rather than ignored
+	runHandlerCases(t, []handlerCase{
+		{method: "POST", url: "/traces/c/e/seg/1/?format=blob", body: `{"ids": [101]}`, status: 200},
+		{method: "POST", url: "/traces/c/e/seg/1/?format=npy", body: `{"ids": [101]}`, status: 400},
+		{method: "POST", url: "/traces_functional/1/?format=json", body: `{"ids": [1000]}`, status: 400},
+		{method: "POST", url: "/spikes_functional/1/?format=npzz", body: `{"ids": [1000]}`, status: 400},
+		{method: "POST", url: "/traces_functional/x/", body: `{"ids": [1000]}`, status: 400},
+		{method: "POST", url: "/traces/c/e/nope/1/", body: `{"ids": [101]}`, status: 404},
+	})
+}
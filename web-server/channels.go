@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ChannelRes is one row of the channel table with what's in it
+type ChannelRes struct {
+	ID          int                `json:"id"`
+	Name        string             `json:"name"` // collection/experiment/layer
+	Type        string             `json:"type"` // segmentation, synapse, mixed or empty
+	VoxelSets   int                `json:"voxel_sets"`
+	Neurons     int                `json:"neurons"`
+	Synapses    int                `json:"synapses"`
+	Extent      *BBox              `json:"extent"` // full resolution bounds of every voxel set, null when there are none
+	Calibration channelCalibration `json:"calibration"`
+	Scans       []int              `json:"scans"` // functional scans with masks of the channel's neurons
+}
+
+func channelType(channel ChannelRes) string {
+	if channel.Neurons > 0 && channel.Synapses > 0 {
+		return "mixed"
+	} else if channel.Neurons > 0 {
+		return "segmentation"
+	} else if channel.Synapses > 0 {
+		return "synapse"
+	}
+	return "empty"
+}
+
+// channelListTTL is how long the channel listing is kept before the next request rebuilds it
+// it only counts rows, so data imported since shows up without a restart, unlike the spatial indexes
+const channelListTTL = 10 * time.Minute
+
+// the channel listing, built by the first request since it scans every table
+var channelList = struct {
+	sync.Mutex
+	channels []ChannelRes
+	built    time.Time
+}{}
+
+// getChannels is listChannels kept for channelListTTL, callers shouldn't modify it
+func getChannels() ([]ChannelRes, error) {
+	channelList.Lock()
+	defer channelList.Unlock()
+
+	if channelList.channels != nil && time.Since(channelList.built) < channelListTTL {
+		return channelList.channels, nil
+	}
+
+	channels, err := listChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	channelList.channels, channelList.built = channels, time.Now()
+
+	return channels, nil
+}
+
+// listChannels lists every channel, linking each to the scans its neurons were imaged in by em_id
+func listChannels() ([]ChannelRes, error) {
+	channels, err := structuralStore.GetChannels()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range channels {
+		channel := &channels[i]
+		channel.Type = channelType(*channel)
+
+		channel.Calibration, err = getChannelCalibration(channel.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		channel.Calibration.Mips = channel.Calibration.listedMips(channel.Extent)
+
+		channel.Scans = make([]int, 0)
+
+		if channel.Neurons == 0 {
+			continue
+		}
+
+		emIDs, err := structuralStore.GetChannelEmIDs(channel.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(emIDs) == 0 {
+			continue
+		}
+
+		channel.Scans, err = functionalStore.GetScansForCells(emIDs)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return channels, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChannelTypes(t *testing.T) {
+	structural, functional := useTestStores(t)
+	structural.Channels["c/e/mixed"] = 3
+	structural.Channels["c/e/empty"] = 4
+
+	// a neuron imaged in scan 2 and a synapse onto it, both in channel 3
+	structural.VoxelSets = append(structural.VoxelSets,
+		memoryVoxelSet{ID: 90, BossID: 301, Channel: 3, BBox: BBox{Vector3{1, 2, 3}, Vector3{4, 5, 6}}},
+		memoryVoxelSet{ID: 91, BossID: 302, Channel: 3, BBox: BBox{Vector3{0, 4, 4}, Vector3{2, 8, 4}}})
+	structural.Neurons = append(structural.Neurons, memoryNeuron{ID: 90, VoxelSet: 90, EmID: intPointer(7000)})
+	structural.Synapses = append(structural.Synapses, memorySynapse{ID: 90, VoxelSet: 91, Pre: 90, Post: 90})
+	functional.Cells = append(functional.Cells, memoryCell{Scan: 2, Slice: 1, EmID: 7000})
+
+	channels, err := getChannels()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]ChannelRes)
+	for _, channel := range channels {
+		byName[channel.Name] = channel
+	}
+
+	mixed, empty := byName["c/e/mixed"], byName["c/e/empty"]
+
+	if mixed.Type != "mixed" || mixed.Neurons != 1 || mixed.Synapses != 1 || len(mixed.Scans) != 1 || mixed.Scans[0] != 2 {
+		t.Errorf("mixed channel %+v", mixed)
+	}
+
+	if mixed.Extent == nil || *mixed.Extent != (BBox{Vector3{0, 2, 3}, Vector3{4, 8, 6}}) {
+		t.Errorf("mixed extent %v, want the union of both voxel sets", mixed.Extent)
+	}
+
+	if empty.Type != "empty" || empty.Extent != nil || empty.Scans == nil || len(empty.Scans) != 0 || len(empty.Calibration.Mips) != 1 {
+		t.Errorf("empty channel %+v", empty)
+	}
+}
+
+func TestChannelListRefresh(t *testing.T) {
+	structural, _ := useTestStores(t)
+
+	if _, err := getChannels(); err != nil {
+		t.Fatal(err)
+	}
+
+	structural.Neurons = structural.Neurons[:4]
+
+	// kept until it's older than channelListTTL
+	channels, err := getChannels()
+	if err != nil || channels[0].Neurons != 5 {
+		t.Fatalf("listing changed before it expired: %v %v", channels, err)
+	}
+
+	channelList.built = channelList.built.Add(-channelListTTL)
+
+	channels, err = getChannels()
+	if err != nil || channels[0].Neurons != 4 {
+		t.Errorf("expired listing has %d neurons in channel 1 %v, want 4", channels[0].Neurons, err)
+	}
+
+	if time.Since(channelList.built) > time.Minute {
+		t.Errorf("rebuilt listing is dated %v", channelList.built)
+	}
+}
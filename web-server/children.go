@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"strconv"
+)
+
+// maxBossWorkers bounds the boss ids requests in flight for one neuron_children request
+const maxBossWorkers = 8
+
+// maxCutoutVoxels stops merged cutouts from growing past what boss answers quickly
+const maxCutoutVoxels = 512 * 512 * 64
+
+// synapseKey boss ids are only unique within a channel
+type synapseKey struct {
+	Channel string
+	BossID  int
+}
+
+// cutout is one boss ids request covering the intersections of several synapses with the region
+type cutout struct {
+	Channel  string
+	BBox     BBox
+	Synapses []int
+}
+
+// addToCutout merges the intersection into the first cutout of the channel it overlaps, or starts a new one
+// every cutout stays inside the region, so any synapse id boss finds in it has a voxel in the region
+func addToCutout(cutouts []*cutout, channel string, intersection BBox, bossID int) []*cutout {
+	for _, c := range cutouts {
+		if c.Channel != channel || !c.BBox.Overlaps(intersection) {
+			continue
+		}
+
+		merged := c.BBox.Union(intersection)
+
+		if merged.Volume() > maxCutoutVoxels {
+			continue
+		}
+
+		c.BBox = merged
+		c.Synapses = append(c.Synapses, bossID)
+
+		return cutouts
+	}
+
+	return append(cutouts, &cutout{channel, intersection, []int{bossID}})
+}
+
+// synapsesInRegion filter is "keypoint" to only check the keypoint, "bbox" to only check the bbox overlaps
+// and anything else to ask BOSS for the ids in the overlap
+func synapsesInRegion(ctx context.Context, synapses []neuronSynapse, region BBox, resolution uint64, filter string) (map[synapseKey]bool, error) {
+	if filter == "keypoint" {
+		return keypointsInRegion(synapses, region, resolution)
+	}
+
+	res := make(map[synapseKey]bool)
+	cutouts := make([]*cutout, 0)
+
+	calibrations, err := synapseCalibrations(synapses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, synapse := range synapses {
+		key := synapseKey{synapse.Channel, synapse.BossID}
+		calibration := calibrations[synapse.Channel]
+
+		synapseBbox := BBox{
+			MIN: calibration.downsample(synapse.BBox.MIN, resolution),
+			MAX: calibration.downsample(synapse.BBox.MAX, resolution)}
+
+		if filter == "bbox" {
+			res[key] = synapseBbox.Overlaps(region)
+			continue
+		}
+
+		if synapseBbox.Inside(region) {
+			res[key] = true
+			continue
+		}
+
+		// otherwise check each voxel in intersection
+		intersection, err := synapseBbox.Intersection(region)
+
+		if err != nil {
+			continue // no overlap, skip
+		}
+
+		cutouts = addToCutout(cutouts, synapse.Channel, intersection, synapse.BossID)
+	}
+
+	return res, fetchCutouts(ctx, cutouts, resolution, res)
+}
+
+// synapseCalibrations looks up the calibration of each channel the synapses are in once
+func synapseCalibrations(synapses []neuronSynapse) (map[string]channelCalibration, error) {
+	res := make(map[string]channelCalibration)
+
+	for _, synapse := range synapses {
+		if _, ok := res[synapse.Channel]; ok {
+			continue
+		}
+
+		channelID, err := structuralStore.GetChannelFromString(synapse.Channel)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res[synapse.Channel], err = getChannelCalibration(channelID)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// keypointsInRegion looks up the keypoints a channel at a time
+func keypointsInRegion(synapses []neuronSynapse, region BBox, resolution uint64) (map[synapseKey]bool, error) {
+	res := make(map[synapseKey]bool)
+	byChannel := make(map[string][]int)
+
+	for _, synapse := range synapses {
+		byChannel[synapse.Channel] = append(byChannel[synapse.Channel], synapse.BossID)
+	}
+
+	for channel, bossIDs := range byChannel {
+		channelID, err := structuralStore.GetChannelFromString(channel)
+
+		if err != nil {
+			return nil, err
+		}
+
+		calibration, err := getChannelCalibration(channelID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keypoints, err := structuralStore.GetKeypointBatch(bossIDs, channelID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for bossID, keypoint := range keypoints {
+			res[synapseKey{channel, bossID}] = calibration.downsample(keypoint, resolution).Inside(region)
+		}
+	}
+
+	return res, nil
+}
+
+type cutoutResult struct {
+	cutout *cutout
+	ids    map[int]bool
+	err    error
+}
+
+func cutoutIds(ctx context.Context, client BossClient, c *cutout, resolution uint64) (map[int]bool, error) {
+	ids, err := client.GetUniqueIdsInRegion(ctx, c.Channel, c.BBox, resolution)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int]bool, len(ids.Ids))
+
+	for _, bossIDStr := range ids.Ids {
+		bossID, parseError := strconv.Atoi(bossIDStr)
+
+		if parseError != nil {
+			return nil, parseError
+		}
+
+		res[bossID] = true
+	}
+
+	return res, nil
+}
+
+// fetchCutouts asks boss for the ids in each cutout with at most maxBossWorkers requests at once
+// and marks the synapses found in res, stopping early on the first error or when ctx is done
+func fetchCutouts(ctx context.Context, cutouts []*cutout, resolution uint64, res map[synapseKey]bool) error {
+	if len(cutouts) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan *cutout)
+	// buffered so workers never block on a caller that has already returned
+	results := make(chan cutoutResult, len(cutouts))
+
+	workers := Min2(maxBossWorkers, len(cutouts))
+	// workers can outlive an early return, so they keep the client they started with
+	client := bossClient
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for c := range jobs {
+				ids, err := cutoutIds(ctx, client, c, resolution)
+				results <- cutoutResult{c, ids, err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+
+		for _, c := range cutouts {
+			select {
+			case jobs <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for range cutouts {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case result := <-results:
+			if result.err != nil {
+				return result.err // network error, break
+			}
+
+			for _, bossID := range result.cutout.Synapses {
+				if result.ids[bossID] {
+					res[synapseKey{result.cutout.Channel, bossID}] = true
+				}
+			}
+		}
+	}
+
+	return nil
+}
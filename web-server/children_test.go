@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBoss answers every region with the same ids, counting the requests in flight
+type fakeBoss struct {
+	ids   []string
+	err   error
+	delay time.Duration
+
+	mu       sync.Mutex
+	calls    int
+	inFlight int
+	most     int
+}
+
+func (b *fakeBoss) GetUniqueIdsInRegion(ctx context.Context, channel string, bbox BBox, resolution uint64) (IdsInRegionRes, error) {
+	b.mu.Lock()
+	b.calls++
+	b.inFlight++
+	if b.inFlight > b.most {
+		b.most = b.inFlight
+	}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.inFlight--
+		b.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(b.delay):
+	case <-ctx.Done():
+		return IdsInRegionRes{}, ctx.Err()
+	}
+
+	return IdsInRegionRes{Ids: b.ids}, b.err
+}
+
+func withBoss(t *testing.T, b BossClient) {
+	previous := bossClient
+	bossClient = b
+	t.Cleanup(func() { bossClient = previous })
+}
+
+func manyCutouts(n int) []*cutout {
+	res := make([]*cutout, n)
+	for i := range res {
+		res[i] = &cutout{"c/e/syn", BBox{Vector3{i, 0, 0}, Vector3{i, 0, 0}}, []int{201 + i%3}}
+	}
+	return res
+}
+
+func TestFetchCutouts(t *testing.T) {
+	boss := &fakeBoss{ids: []string{"201", "999"}, delay: 5 * time.Millisecond}
+	withBoss(t, boss)
+
+	res := make(map[synapseKey]bool)
+	if err := fetchCutouts(context.Background(), manyCutouts(40), 0, res); err != nil {
+		t.Fatal(err)
+	}
+
+	// only synapses boss found in their own cutouts are marked
+	want := map[synapseKey]bool{{"c/e/syn", 201}: true}
+	if len(res) != len(want) || !res[synapseKey{"c/e/syn", 201}] {
+		t.Errorf("marked %v, want %v", res, want)
+	}
+
+	if boss.calls != 40 || boss.most > maxBossWorkers {
+		t.Errorf("%d calls with up to %d at once, want 40 with at most %d", boss.calls, boss.most, maxBossWorkers)
+	}
+
+	if err := fetchCutouts(context.Background(), nil, 0, res); err != nil || boss.calls != 40 {
+		t.Errorf("no cutouts gave %v after %d calls", err, boss.calls)
+	}
+}
+
+func TestFetchCutoutsError(t *testing.T) {
+	boom := errors.New("boom")
+	withBoss(t, &fakeBoss{err: boom})
+
+	if err := fetchCutouts(context.Background(), manyCutouts(20), 0, make(map[synapseKey]bool)); err != boom {
+		t.Errorf("got %v, want the boss error", err)
+	}
+
+	withBoss(t, &fakeBoss{ids: []string{"not a number"}})
+
+	if err := fetchCutouts(context.Background(), manyCutouts(1), 0, make(map[synapseKey]bool)); err == nil {
+		t.Error("an id that isn't a number was accepted")
+	}
+}
+
+func TestFetchCutoutsCancel(t *testing.T) {
+	boss := &fakeBoss{ids: []string{"201"}, delay: time.Minute}
+	withBoss(t, boss)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := fetchCutouts(ctx, manyCutouts(40), 0, make(map[synapseKey]bool)); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the deadline", err)
+	}
+
+	if time.Since(start) > 10*time.Second {
+		t.Error("fetchCutouts waited for boss after the request was cancelled")
+	}
+}
+
+func TestAddToCutout(t *testing.T) {
+	cutouts := make([]*cutout, 0)
+	cutouts = addToCutout(cutouts, "a", BBox{Vector3{0, 0, 0}, Vector3{5, 5, 0}}, 1)
+	cutouts = addToCutout(cutouts, "a", BBox{Vector3{3, 3, 0}, Vector3{8, 8, 0}}, 2)
+	cutouts = addToCutout(cutouts, "b", BBox{Vector3{3, 3, 0}, Vector3{8, 8, 0}}, 3)
+	cutouts = addToCutout(cutouts, "a", BBox{Vector3{20, 20, 0}, Vector3{21, 21, 0}}, 4)
+	cutouts = addToCutout(cutouts, "a", BBox{Vector3{0, 0, 0}, Vector3{1000, 1000, 100}}, 5)
+
+	if len(cutouts) != 4 {
+		t.Fatalf("%d cutouts, want 4", len(cutouts))
+	}
+
+	// overlapping boxes of a channel merge, other channels and far away boxes don't
+	merged := cutouts[0]
+	if merged.BBox != (BBox{Vector3{0, 0, 0}, Vector3{8, 8, 0}}) || len(merged.Synapses) != 2 {
+		t.Errorf("merged cutout %+v", *merged)
+	}
+
+	// nothing merges past maxCutoutVoxels
+	if len(cutouts[3].Synapses) != 1 || cutouts[3].Synapses[0] != 5 {
+		t.Errorf("large cutout %+v", *cutouts[3])
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// limits on a connectivity walk, so a single request can't pull in the whole connectome
+const maxConnectivityDepth = 5
+const maxConnectivityFrontier = 5000
+
+var errBadDirection = errors.New("direction should be pre, post or both")
+
+type connectivityNode struct {
+	ID       int `json:"id"`
+	Distance int `json:"distance"`
+}
+
+type connectivityEdge struct {
+	Pre      int `json:"pre"`
+	Post     int `json:"post"`
+	Synapses int `json:"synapses"`
+}
+
+// ConnectivityRes boop
+type ConnectivityRes struct {
+	Nodes []connectivityNode `json:"nodes"`
+	Edges []connectivityEdge `json:"edges"`
+	// Truncated is set when a hop found more than maxConnectivityFrontier new neurons, that hop is left out
+	Truncated bool `json:"truncated"`
+}
+
+// getConnectivity walks the synapse graph breadth first from a neuron for up to depth hops
+// direction is "pre" (follow inputs), "post" (follow outputs) or "both"
+func getConnectivity(neuronID int, bossID int, depth int, direction string, functionalOnly bool) (ConnectivityRes, error) {
+	var followPre, followPost bool
+
+	switch direction {
+	case "pre":
+		followPre = true
+	case "post":
+		followPost = true
+	case "both":
+		followPre, followPost = true, true
+	default:
+		return ConnectivityRes{}, errBadDirection
+	}
+
+	distances := map[int]int{neuronID: 0}
+	bossIDs := map[int]int{neuronID: bossID}
+	edges := make(map[[2]int]int)
+
+	res := ConnectivityRes{}
+
+	frontier := []int{neuronID}
+
+	for hop := 1; hop <= depth && len(frontier) > 0; hop++ {
+		found := make([]synapticEdge, 0)
+
+		if followPre {
+			inputs, err := structuralStore.GetSynapticEdges(frontier, true, functionalOnly)
+			if err != nil {
+				return res, err
+			}
+			found = append(found, inputs...)
+		}
+
+		if followPost {
+			outputs, err := structuralStore.GetSynapticEdges(frontier, false, functionalOnly)
+			if err != nil {
+				return res, err
+			}
+			found = append(found, outputs...)
+		}
+
+		next := make([]int, 0)
+		added := make(map[int]bool)
+
+		for _, edge := range found {
+			for _, id := range [2]int{edge.Pre, edge.Post} {
+				if _, seen := distances[id]; !seen && !added[id] {
+					added[id] = true
+					next = append(next, id)
+				}
+			}
+		}
+
+		// checked before anything from this hop goes in, so the limit bounds the response
+		if len(next) > maxConnectivityFrontier {
+			res.Truncated = true
+			break
+		}
+
+		for _, edge := range found {
+			// an edge between two frontier neurons shows up from both ends
+			edges[[2]int{edge.Pre, edge.Post}] = edge.Synapses
+
+			bossIDs[edge.Pre] = edge.PreBossID
+			bossIDs[edge.Post] = edge.PostBossID
+		}
+
+		for _, id := range next {
+			distances[id] = hop
+		}
+
+		frontier = next
+	}
+
+	res.Nodes = make([]connectivityNode, 0, len(distances))
+	for id, distance := range distances {
+		res.Nodes = append(res.Nodes, connectivityNode{ID: bossIDs[id], Distance: distance})
+	}
+
+	sort.Slice(res.Nodes, func(i, j int) bool {
+		if res.Nodes[i].Distance != res.Nodes[j].Distance {
+			return res.Nodes[i].Distance < res.Nodes[j].Distance
+		}
+		return res.Nodes[i].ID < res.Nodes[j].ID
+	})
+
+	res.Edges = make([]connectivityEdge, 0, len(edges))
+	for key, synapses := range edges {
+		res.Edges = append(res.Edges, connectivityEdge{Pre: bossIDs[key[0]], Post: bossIDs[key[1]], Synapses: synapses})
+	}
+
+	sort.Slice(res.Edges, func(i, j int) bool {
+		if res.Edges[i].Pre != res.Edges[j].Pre {
+			return res.Edges[i].Pre < res.Edges[j].Pre
+		}
+		return res.Edges[i].Post < res.Edges[j].Post
+	})
+
+	return res, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConnectivityHandler(t *testing.T) {
+	useTestStores(t)
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/connectivity/c/e/seg/101/", status: 200, want: `{"nodes":[{"id":101,"distance":0},{"id":102,"distance":1},{"id":105,"distance":1}],` +
+			`"edges":[{"pre":101,"post":102,"synapses":2},{"pre":105,"post":101,"synapses":1}],"truncated":false}`},
+		{url: "/connectivity/c/e/seg/101/?direction=post&depth=2", status: 200, want: `{"nodes":[{"id":101,"distance":0},{"id":102,"distance":1},{"id":103,"distance":2}],` +
+			`"edges":[{"pre":101,"post":102,"synapses":2},{"pre":102,"post":103,"synapses":1}],"truncated":false}`},
+		// the edge between 104 and 102 is found from 104 after 102 was already reached
+		{url: "/connectivity/c/e/seg/101/?depth=5", status: 200, want: `{"nodes":[{"id":101,"distance":0},{"id":102,"distance":1},{"id":105,"distance":1},{"id":103,"distance":2},{"id":104,"distance":2}],` +
+			`"edges":[{"pre":101,"post":102,"synapses":2},{"pre":102,"post":103,"synapses":1},{"pre":103,"post":104,"synapses":1},{"pre":104,"post":102,"synapses":1},{"pre":105,"post":101,"synapses":1}],"truncated":false}`},
+		// only partners with functional data are followed
+		{url: "/connectivity/c/e/seg/101/?functional=true", status: 200, want: `{"nodes":[{"id":101,"distance":0},{"id":105,"distance":1}],` +
+			`"edges":[{"pre":105,"post":101,"synapses":1}],"truncated":false}`},
+
+		{url: "/connectivity/c/e/seg/101/?depth=0", status: 400},
+		{url: "/connectivity/c/e/seg/101/?depth=6", status: 400},
+		{url: "/connectivity/c/e/seg/101/?depth=x", status: 400},
+		{url: "/connectivity/c/e/seg/101/?direction=up", status: 400},
+		{url: "/connectivity/c/e/seg/999/", status: 404},
+	})
+}
+
+func TestConnectivityFrontier(t *testing.T) {
+	structural, _ := useTestStores(t)
+
+	// 102 has one more output than a hop may add
+	for i := 0; i <= maxConnectivityFrontier; i++ {
+		id := 1000 + i
+		structural.VoxelSets = append(structural.VoxelSets, memoryVoxelSet{ID: id, BossID: id, Channel: 1})
+		structural.Neurons = append(structural.Neurons, memoryNeuron{ID: id, VoxelSet: id})
+		structural.Synapses = append(structural.Synapses, memorySynapse{ID: id, Pre: 2, Post: id})
+	}
+
+	res, err := getConnectivity(1, 101, 3, "post", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the hop that overflows is left out entirely, the ones before it are kept
+	if !res.Truncated || len(res.Nodes) != 2 || res.Nodes[1].ID != 102 || len(res.Edges) != 1 {
+		t.Errorf("truncated %v with %d nodes and %d edges, want the first hop only", res.Truncated, len(res.Nodes), len(res.Edges))
+	}
+}
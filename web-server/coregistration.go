@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+var errNotCoregistered = errors.New("no neuron has this em_id")
+
+// getCoregisteredNeurons is every neuron imaged as the functional cell emID, a 404 if there are none
+func getCoregisteredNeurons(emID int) ([]coregisteredNeuron, error) {
+	neurons, err := structuralStore.GetCoregisteredNeurons(emID)
+
+	if err == nil && len(neurons) == 0 {
+		err = errNotCoregistered
+	}
+
+	return neurons, err
+}
+
+// getMaskNeurons is getCoregisteredNeurons for a ROI, checking the ROI is in the slice first
+func getMaskNeurons(scanID int, slice int, emID int) ([]coregisteredNeuron, error) {
+	if _, err := functionalStore.HasMask(scanID, slice, emID); err != nil {
+		return nil, err
+	}
+
+	return getCoregisteredNeurons(emID)
+}
+
+// getCoregisteredChannel lists every neuron in a channel that has an em_id
+func getCoregisteredChannel(channel string, channelID int) ([]coregisteredNeuron, error) {
+	res := make([]coregisteredNeuron, 0)
+
+	err := structuralStore.EachCoregisteredNeuron(channelID, func(node graphNode) error {
+		res = append(res, coregisteredNeuron{
+			Channel:  channel,
+			BossID:   node.BossID,
+			EmID:     *node.EmID,
+			Keypoint: node.Keypoint,
+			BBox:     node.BBox})
+		return nil
+	})
+
+	sort.Slice(res, func(i, j int) bool { return res[i].BossID < res[j].BossID })
+
+	return res, err
+}
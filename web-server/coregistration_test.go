@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// coregisteredJSON is how neuron i of useTestStores is listed
+func coregisteredJSON(i int) string {
+	return fmt.Sprintf(`{"channel":"c/e/seg","boss_id":%d,"em_id":%d,"keypoint":{"x":%d,"y":%d,"z":%d},`+
+		`"bbox":{"min":{"x":%d,"y":%d,"z":%d},"max":{"x":%d,"y":%d,"z":%d}}}`,
+		100+i, 1000*i, 10*i, 10*i, i, 10*i-5, 10*i-5, i-1, 10*i+5, 10*i+5, i+1)
+}
+
+func TestCoregistration(t *testing.T) {
+	structural, _ := useTestStores(t)
+
+	// em_id 1000 is also imaged as a neuron of the other channel
+	structural.VoxelSets = append(structural.VoxelSets, memoryVoxelSet{ID: 99, BossID: 901, Channel: 2, Keypoint: Vector3{1, 2, 3}})
+	structural.Neurons = append(structural.Neurons, memoryNeuron{ID: 99, VoxelSet: 99, EmID: intPointer(1000)})
+
+	other := `{"channel":"c/e/syn","boss_id":901,"em_id":1000,"keypoint":{"x":1,"y":2,"z":3},"bbox":{"min":{"x":0,"y":0,"z":0},"max":{"x":0,"y":0,"z":0}}}`
+
+	runHandlerCases(t, []handlerCase{
+		// ordered by channel then boss id
+		{url: "/coregistered_neurons/1000/", status: 200, want: `[` + coregisteredJSON(1) + `,` + other + `]`},
+		{url: "/coregistered_neurons/3000/", status: 200, want: `[` + coregisteredJSON(3) + `]`},
+		{url: "/coregistered_neurons/2000/", status: 404},
+		{url: "/coregistered_neurons/x/", status: 400},
+
+		// only the ROI in the slice is looked up
+		{url: "/coregistered_neurons_for_mask/1/1/1000/", status: 200, want: `[` + coregisteredJSON(1) + `,` + other + `]`},
+		{url: "/coregistered_neurons_for_mask/1/2/1000/", status: 404},
+		{url: "/coregistered_neurons_for_mask/1/1/3000/", status: 404},
+
+		// neurons without an em_id are left out
+		{url: "/coregistered/c/e/seg/", status: 200, want: `[` + coregisteredJSON(1) + `,` + coregisteredJSON(3) + `,` + coregisteredJSON(5) + `]`},
+		{url: "/coregistered/c/e/nope/", status: 404},
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// the connected neurons of a slice are as many as the ids a request may give at most, a denser slice needs its ids POSTed
+var errTooManyConnected = fmt.Errorf("more than %d connected functional neurons in the slice, POST the ids to correlate", maxSubgraphIds)
+
+type correlationPair struct {
+	Pre         int       `json:"pre"`
+	Post        int       `json:"post"`
+	Synapses    int       `json:"synapses"`
+	Correlation jsonFloat `json:"correlation"`
+}
+
+// CorrelationRes boop
+type CorrelationRes struct {
+	// boss ids of the cells with data in the slice, in the order of the matrix rows and columns
+	Ids   []int `json:"ids"`
+	EmIDs []int `json:"em_ids"`
+	// pearson correlation over the frames both cells have, null where it's undefined or the pair was left out
+	Matrix [][]jsonFloat `json:"matrix"`
+	// synaptically connected pairs, only when asked for connected pairs
+	Pairs []correlationPair `json:"pairs,omitempty"`
+}
+
+// cellBatchSignal is cellSignal for many cells at once
+func cellBatchSignal(signal string) (cellBatchGetter, error) {
+	switch signal {
+	case "", "trace":
+		return functionalStore.GetTraceBatch, nil
+	case "spike":
+		return functionalStore.GetSpikeBatch, nil
+	}
+	return nil, errBadSignal
+}
+
+// pearson correlates two signals over the frames where neither is NaN
+func pearson(a []float64, b []float64) float64 {
+	var n, sumA, sumB float64
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if !math.IsNaN(a[i]) && !math.IsNaN(b[i]) {
+			sumA += a[i]
+			sumB += b[i]
+			n++
+		}
+	}
+
+	if n < 2 {
+		return math.NaN()
+	}
+
+	meanA, meanB := sumA/n, sumB/n
+	var cov, varA, varB float64
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if !math.IsNaN(a[i]) && !math.IsNaN(b[i]) {
+			cov += (a[i] - meanA) * (b[i] - meanB)
+			varA += (a[i] - meanA) * (a[i] - meanA)
+			varB += (b[i] - meanB) * (b[i] - meanB)
+		}
+	}
+
+	return cov / math.Sqrt(varA*varB)
+}
+
+// connectedFunctionalNeurons is every neuron imaged in the slice that has a synapse to or from another one that is
+func connectedFunctionalNeurons(scanID int, slice int, channelID int) ([]int, error) {
+	cells, err := functionalStore.GetSliceCells(scanID, slice)
+	if err != nil {
+		return nil, err
+	}
+
+	imaged := make(map[int]bool, len(cells))
+	for _, emID := range cells {
+		imaged[emID] = true
+	}
+
+	graph, err := functionalGraph(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	inSlice := make(map[int]bool)
+	for _, node := range graph.Nodes {
+		inSlice[node.BossID] = imaged[node.EmID]
+	}
+
+	res := make([]int, 0)
+	for _, edge := range graph.Edges {
+		if edge.Pre != edge.Post && inSlice[edge.Pre] && inSlice[edge.Post] {
+			res = append(res, edge.Pre, edge.Post)
+		}
+	}
+
+	res = dedupeIds(res)
+	sort.Ints(res)
+
+	return res, nil
+}
+
+// getCorrelations correlates the signal of every pair of neurons imaged in the slice
+// bossIDs defaults to connectedFunctionalNeurons, when connected only synaptically connected pairs are correlated
+func getCorrelations(batch cellBatchGetter, scanID int, slice int, bossIDs []int, channelID int, window *frameWindow, connected bool) (CorrelationRes, error) {
+	res := CorrelationRes{Ids: make([]int, 0), EmIDs: make([]int, 0), Matrix: make([][]jsonFloat, 0)}
+
+	var err error
+	if len(bossIDs) == 0 {
+		bossIDs, err = connectedFunctionalNeurons(scanID, slice, channelID)
+		if err != nil {
+			return res, err
+		}
+
+		if len(bossIDs) > maxSubgraphIds {
+			return res, errTooManyConnected
+		}
+	}
+
+	bossIDs = dedupeIds(bossIDs)
+	if len(bossIDs) > maxSubgraphIds {
+		return res, errTooManyIds
+	}
+
+	emIDs, err := structuralStore.GetFunctionalIDBatch(bossIDs, channelID)
+	if err != nil {
+		return res, err
+	}
+
+	lookup := make([]int, 0, len(emIDs))
+	for _, emID := range emIDs {
+		lookup = append(lookup, emID)
+	}
+
+	cells, err := batch(scanID, slice, lookup)
+	if err != nil {
+		return res, err
+	}
+
+	signals := make(map[int][]float64)
+	for _, cell := range cells {
+		array, err := decodeArray(cell.Data)
+		if err != nil {
+			return res, err
+		}
+
+		if window != nil {
+			array = array.frames(*window)
+		}
+		signals[cell.EmID] = array.ndarray().Data
+	}
+
+	rows := make([][]float64, 0)
+	index := make(map[int]int)
+
+	for _, id := range bossIDs {
+		emID, ok := emIDs[id]
+		if signal, imaged := signals[emID]; ok && imaged {
+			index[id] = len(rows)
+			res.Ids = append(res.Ids, id)
+			res.EmIDs = append(res.EmIDs, emID)
+			rows = append(rows, signal)
+		}
+	}
+
+	for range rows {
+		row := make([]jsonFloat, len(rows))
+		for j := range row {
+			row[j] = jsonFloat(math.NaN())
+		}
+		res.Matrix = append(res.Matrix, row)
+	}
+
+	if !connected {
+		for i := range rows {
+			for j := i; j < len(rows); j++ {
+				r := jsonFloat(pearson(rows[i], rows[j]))
+				res.Matrix[i][j], res.Matrix[j][i] = r, r
+			}
+		}
+
+		return res, nil
+	}
+
+	synapses, err := structuralStore.GetInducedSynapses(res.Ids, channelID)
+	if err != nil {
+		return res, err
+	}
+
+	counts := make(map[[2]int]int)
+	for _, synapse := range synapses {
+		counts[[2]int{synapse.Pre, synapse.Post}]++
+	}
+
+	res.Pairs = make([]correlationPair, 0, len(counts))
+
+	for pair, count := range counts {
+		i, j := index[pair[0]], index[pair[1]]
+		r := jsonFloat(pearson(rows[i], rows[j]))
+
+		res.Matrix[i][j], res.Matrix[j][i] = r, r
+		res.Pairs = append(res.Pairs, correlationPair{Pre: pair[0], Post: pair[1], Synapses: count, Correlation: r})
+	}
+
+	sort.Slice(res.Pairs, func(i, j int) bool {
+		if res.Pairs[i].Pre != res.Pairs[j].Pre {
+			return res.Pairs[i].Pre < res.Pairs[j].Pre
+		}
+		return res.Pairs[i].Post < res.Pairs[j].Post
+	})
+
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPearson(t *testing.T) {
+	nan := math.NaN()
+
+	for _, c := range []struct {
+		a, b []float64
+		want float64
+	}{
+		{[]float64{1, 2, 3}, []float64{2, 4, 6}, 1},
+		{[]float64{1, 2, 3}, []float64{3, 2, 1}, -1},
+		{[]float64{1, 2, 3, 4}, []float64{1, 3, 2, 4}, 0.8},
+		// frames where either is NaN are left out, and the longer signal is cut to the shorter
+		{[]float64{1, nan, 3, 100}, []float64{2, 50, 6}, 1},
+		// fewer than two frames or no variance is undefined
+		{[]float64{1}, []float64{1}, nan},
+		{[]float64{1, nan}, []float64{1, 2}, nan},
+		{[]float64{1, 1, 1}, []float64{1, 2, 3}, nan},
+	} {
+		if got := pearson(c.a, c.b); !sameFloats([]float64{got}, []float64{c.want}) {
+			t.Errorf("pearson(%v, %v) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestCorrelationHandler(t *testing.T) {
+	_, functional := useTestStores(t)
+
+	// 105 synapses onto 101, both imaged in slice 1, 103 is imaged too but connected to neither
+	functional.Cells = append(functional.Cells,
+		memoryCell{Scan: 1, Slice: 1, EmID: 5000, Trace: ndarray{Shape: []int{1, 4}, Data: []float64{8, 6, 4, 2}}.djBlob()},
+		memoryCell{Scan: 1, Slice: 1, EmID: 3000, Trace: ndarray{Shape: []int{1, 4}, Data: []float64{1, 3, 2, 4}}.djBlob()})
+	functional.Scans[2] = memoryScan{}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/correlations/c/e/seg/1/1/", status: 200,
+			want: `{"ids":[101,105],"em_ids":[1000,5000],"matrix":[[1,-1],[-1,1]]}`},
+		{url: "/correlations/c/e/seg/1/1/?connected=true", status: 200,
+			want: `{"ids":[101,105],"em_ids":[1000,5000],"matrix":[[null,-1],[-1,null]],"pairs":[{"pre":105,"post":101,"synapses":1,"correlation":-1}]}`},
+		{method: "POST", url: "/correlations/c/e/seg/1/1/", body: `{"ids":[101,103]}`, status: 200,
+			want: `{"ids":[101,103],"em_ids":[1000,3000],"matrix":[[1,0.8],[0.8,1]]}`},
+		{url: "/correlations/c/e/seg/1/1/?frames=0:2", status: 200,
+			want: `{"ids":[101,105],"em_ids":[1000,5000],"matrix":[[1,-1],[-1,1]]}`},
+
+		{url: "/correlations/c/e/seg/2/1/?t=0:1", status: 422},
+		{url: "/correlations/c/e/seg/1/1/?signal=x", status: 400},
+		{url: "/correlations/c/e/seg/1/1/?frames=x", status: 400},
+		{url: "/correlations/c/e/none/1/1/", status: 404},
+	})
+}
+
+func TestCorrelationTooManyConnected(t *testing.T) {
+	// every neuron past the first synapses onto it, one more than a request may correlate
+	n := maxSubgraphIds + 2
+	edges := make([][2]int, 0)
+	for i := 2; i <= n; i++ {
+		edges = append(edges, [2]int{i, 1})
+	}
+
+	structural := useGraph(t, n, edges)
+	functional := functionalStore.(*memoryFunctionalStore)
+
+	for i := range structural.Neurons {
+		emID := 1000 + i
+		structural.Neurons[i].EmID = &emID
+		functional.Cells = append(functional.Cells, memoryCell{Scan: 1, Slice: 2, EmID: emID,
+			Trace: ndarray{Shape: []int{1, 2}, Data: []float64{1, 2}}.djBlob()})
+	}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/correlations/c/e/seg/1/2/", status: 422},
+		{method: "POST", url: "/correlations/c/e/seg/1/2/", body: `{"ids":[101,102]}`, status: 200},
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var errBadExportFormat = errors.New("format should be graphml, gexf, csv or networkx")
+
+// graphNodeAttributes names the exported node columns, in the order graphNode.attributes gives them
+var graphNodeAttributes = []string{
+	"em_id", "size",
+	"keypoint_x", "keypoint_y", "keypoint_z",
+	"x_min", "y_min", "z_min",
+	"x_max", "y_max", "z_max",
+}
+
+func (n graphNode) attributes() []*int {
+	ints := []int{
+		n.Keypoint.X, n.Keypoint.Y, n.Keypoint.Z,
+		n.BBox.MIN.X, n.BBox.MIN.Y, n.BBox.MIN.Z,
+		n.BBox.MAX.X, n.BBox.MAX.Y, n.BBox.MAX.Z,
+	}
+
+	res := []*int{n.EmID, n.Size}
+	for i := range ints {
+		res = append(res, &ints[i])
+	}
+
+	return res
+}
+
+// graphWriter writes one export format, called as header, every node, edgesStart, every edge, footer
+type graphWriter interface {
+	header() error
+	node(n graphNode) error
+	edgesStart() error
+	edge(e synapticEdge) error
+	footer() error
+}
+
+var exportContentTypes = map[string]string{
+	"graphml":  "application/xml",
+	"gexf":     "application/xml",
+	"csv":      "text/csv",
+	"networkx": "application/json",
+}
+
+func newGraphWriter(format string, w *bufio.Writer, channel string) (graphWriter, error) {
+	switch format {
+	case "graphml":
+		return &graphmlWriter{w: w}, nil
+	case "gexf":
+		return &gexfWriter{w: w}, nil
+	case "csv":
+		return &csvEdgeWriter{w: w}, nil
+	case "networkx":
+		return &networkxWriter{w: w, channel: channel}, nil
+	}
+	return nil, errBadExportFormat
+}
+
+// exportGraph streams every neuron and synaptic edge of a channel to out
+func exportGraph(out io.Writer, format string, channel string, channelID int) error {
+	w := bufio.NewWriter(out)
+
+	gw, err := newGraphWriter(format, w, channel)
+	if err != nil {
+		return err
+	}
+
+	if err := gw.header(); err != nil {
+		return err
+	}
+
+	if err := structuralStore.EachNeuron(channelID, gw.node); err != nil {
+		return err
+	}
+
+	if err := gw.edgesStart(); err != nil {
+		return err
+	}
+
+	if err := structuralStore.EachSynapticEdge(channelID, gw.edge); err != nil {
+		return err
+	}
+
+	if err := gw.footer(); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+type graphmlWriter struct {
+	w *bufio.Writer
+}
+
+func (g *graphmlWriter) header() error {
+	g.w.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	g.w.WriteString("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+
+	for _, name := range graphNodeAttributes {
+		fmt.Fprintf(g.w, "  <key id=\"%s\" for=\"node\" attr.name=\"%s\" attr.type=\"long\"/>\n", name, name)
+	}
+	g.w.WriteString("  <key id=\"synapses\" for=\"edge\" attr.name=\"synapses\" attr.type=\"int\"/>\n")
+	g.w.WriteString("  <key id=\"synapse_size\" for=\"edge\" attr.name=\"synapse_size\" attr.type=\"long\"/>\n")
+
+	_, err := g.w.WriteString("  <graph edgedefault=\"directed\">\n")
+	return err
+}
+
+func (g *graphmlWriter) node(n graphNode) error {
+	fmt.Fprintf(g.w, "    <node id=\"%d\">", n.BossID)
+
+	for i, value := range n.attributes() {
+		if value != nil {
+			fmt.Fprintf(g.w, "<data key=\"%s\">%d</data>", graphNodeAttributes[i], *value)
+		}
+	}
+
+	_, err := g.w.WriteString("</node>\n")
+	return err
+}
+
+func (g *graphmlWriter) edgesStart() error {
+	return nil
+}
+
+func (g *graphmlWriter) edge(e synapticEdge) error {
+	_, err := fmt.Fprintf(g.w, "    <edge source=\"%d\" target=\"%d\"><data key=\"synapses\">%d</data><data key=\"synapse_size\">%d</data></edge>\n",
+		e.PreBossID, e.PostBossID, e.Synapses, e.Size)
+	return err
+}
+
+func (g *graphmlWriter) footer() error {
+	_, err := g.w.WriteString("  </graph>\n</graphml>\n")
+	return err
+}
+
+type gexfWriter struct {
+	w     *bufio.Writer
+	edges int
+}
+
+func (g *gexfWriter) header() error {
+	g.w.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	g.w.WriteString("<gexf xmlns=\"http://www.gexf.net/1.2draft\" version=\"1.2\">\n")
+	g.w.WriteString("  <graph mode=\"static\" defaultedgetype=\"directed\">\n")
+	g.w.WriteString("    <attributes class=\"node\">\n")
+
+	for i, name := range graphNodeAttributes {
+		fmt.Fprintf(g.w, "      <attribute id=\"%d\" title=\"%s\" type=\"long\"/>\n", i, name)
+	}
+
+	g.w.WriteString("    </attributes>\n")
+	g.w.WriteString("    <attributes class=\"edge\">\n")
+	g.w.WriteString("      <attribute id=\"synapse_size\" title=\"synapse_size\" type=\"long\"/>\n")
+	g.w.WriteString("    </attributes>\n")
+
+	_, err := g.w.WriteString("    <nodes>\n")
+	return err
+}
+
+func (g *gexfWriter) node(n graphNode) error {
+	fmt.Fprintf(g.w, "      <node id=\"%d\" label=\"%d\"><attvalues>", n.BossID, n.BossID)
+
+	for i, value := range n.attributes() {
+		if value != nil {
+			fmt.Fprintf(g.w, "<attvalue for=\"%d\" value=\"%d\"/>", i, *value)
+		}
+	}
+
+	_, err := g.w.WriteString("</attvalues></node>\n")
+	return err
+}
+
+func (g *gexfWriter) edgesStart() error {
+	_, err := g.w.WriteString("    </nodes>\n    <edges>\n")
+	return err
+}
+
+func (g *gexfWriter) edge(e synapticEdge) error {
+	_, err := fmt.Fprintf(g.w, "      <edge id=\"%d\" source=\"%d\" target=\"%d\" weight=\"%d\"><attvalues><attvalue for=\"synapse_size\" value=\"%d\"/></attvalues></edge>\n",
+		g.edges, e.PreBossID, e.PostBossID, e.Synapses, e.Size)
+	g.edges++
+	return err
+}
+
+func (g *gexfWriter) footer() error {
+	_, err := g.w.WriteString("    </edges>\n  </graph>\n</gexf>\n")
+	return err
+}
+
+// csvEdgeWriter is a plain edge list, nodes are skipped
+type csvEdgeWriter struct {
+	w *bufio.Writer
+}
+
+func (c *csvEdgeWriter) header() error {
+	_, err := c.w.WriteString("pre,post,synapses,synapse_size\n")
+	return err
+}
+
+func (c *csvEdgeWriter) node(n graphNode) error {
+	return nil
+}
+
+func (c *csvEdgeWriter) edgesStart() error {
+	return nil
+}
+
+func (c *csvEdgeWriter) edge(e synapticEdge) error {
+	_, err := fmt.Fprintf(c.w, "%d,%d,%d,%d\n", e.PreBossID, e.PostBossID, e.Synapses, e.Size)
+	return err
+}
+
+func (c *csvEdgeWriter) footer() error {
+	return nil
+}
+
+// networkxWriter writes the node link json read by networkx.readwrite.json_graph.node_link_graph
+type networkxWriter struct {
+	w       *bufio.Writer
+	channel string
+	first   bool
+}
+
+func (n *networkxWriter) header() error {
+	channel, _ := json.Marshal(n.channel)
+	n.first = true
+
+	_, err := fmt.Fprintf(n.w, "{\"directed\": true, \"multigraph\": false, \"graph\": {\"channel\": %s},\n\"nodes\": [\n", channel)
+	return err
+}
+
+// separator puts a comma before every element of a list but the first
+func (n *networkxWriter) separator() {
+	if !n.first {
+		n.w.WriteString(",\n")
+	}
+	n.first = false
+}
+
+func (n *networkxWriter) node(node graphNode) error {
+	n.separator()
+
+	n.w.WriteString("{\"id\": " + strconv.Itoa(node.BossID))
+
+	for i, value := range node.attributes() {
+		if value != nil {
+			fmt.Fprintf(n.w, ", \"%s\": %d", graphNodeAttributes[i], *value)
+		}
+	}
+
+	_, err := n.w.WriteString("}")
+	return err
+}
+
+func (n *networkxWriter) edgesStart() error {
+	n.first = true
+	_, err := n.w.WriteString("\n],\n\"links\": [\n")
+	return err
+}
+
+func (n *networkxWriter) edge(e synapticEdge) error {
+	n.separator()
+
+	_, err := fmt.Fprintf(n.w, "{\"source\": %d, \"target\": %d, \"weight\": %d, \"synapse_size\": %d}",
+		e.PreBossID, e.PostBossID, e.Synapses, e.Size)
+	return err
+}
+
+func (n *networkxWriter) footer() error {
+	_, err := n.w.WriteString("\n]}\n")
+	return err
+}
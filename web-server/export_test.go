@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"testing"
+)
+
+// exportBody is the export of a two neuron graph, 101 imaged as em_id 7 with two synapses onto 102
+func exportBody(t *testing.T, format string) string {
+	structural := useGraph(t, 2, [][2]int{{1, 2}, {1, 2}})
+	structural.Neurons[0].EmID = intPointer(7)
+	structural.VoxelSets[0].Keypoint = Vector3{1, 2, 3}
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/export/c/e/seg/?format="+format, nil))
+
+	if w.Code != 200 {
+		t.Fatalf("%s: status %d", format, w.Code)
+	}
+
+	return w.Body.String()
+}
+
+type xmlValue struct {
+	Key   string `xml:"key,attr"`
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+	Text  string `xml:",chardata"`
+}
+
+type xmlElement struct {
+	ID     string     `xml:"id,attr"`
+	Source string     `xml:"source,attr"`
+	Target string     `xml:"target,attr"`
+	Weight string     `xml:"weight,attr"`
+	Data   []xmlValue `xml:"data"`
+	Values []xmlValue `xml:"attvalues>attvalue"`
+}
+
+// values maps graphml data keys or gexf attvalue ids to their value
+func (e xmlElement) values() map[string]string {
+	res := make(map[string]string)
+	for _, d := range e.Data {
+		res[d.Key] = d.Text
+	}
+	for _, v := range e.Values {
+		res[v.For] = v.Value
+	}
+	return res
+}
+
+func TestExportGraphml(t *testing.T) {
+	var graphml struct {
+		Nodes []xmlElement `xml:"graph>node"`
+		Edges []xmlElement `xml:"graph>edge"`
+	}
+
+	if err := xml.Unmarshal([]byte(exportBody(t, "graphml")), &graphml); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(graphml.Nodes) != 2 || len(graphml.Edges) != 1 {
+		t.Fatalf("%d nodes and %d edges, want 2 and 1", len(graphml.Nodes), len(graphml.Edges))
+	}
+
+	first, second := graphml.Nodes[0].values(), graphml.Nodes[1].values()
+
+	if graphml.Nodes[0].ID != "101" || first["em_id"] != "7" || first["keypoint_z"] != "3" || first["size"] != "1" {
+		t.Errorf("node %s %v", graphml.Nodes[0].ID, first)
+	}
+
+	// no em_id is left out rather than written as 0
+	if _, ok := second["em_id"]; ok || len(second) != len(graphNodeAttributes)-1 {
+		t.Errorf("node %s %v", graphml.Nodes[1].ID, second)
+	}
+
+	edge := graphml.Edges[0]
+	if edge.Source != "101" || edge.Target != "102" || edge.values()["synapses"] != "2" || edge.values()["synapse_size"] != "2" {
+		t.Errorf("edge %+v", edge)
+	}
+}
+
+func TestExportGexf(t *testing.T) {
+	var gexf struct {
+		Nodes []xmlElement `xml:"graph>nodes>node"`
+		Edges []xmlElement `xml:"graph>edges>edge"`
+	}
+
+	if err := xml.Unmarshal([]byte(exportBody(t, "gexf")), &gexf); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gexf.Nodes) != 2 || len(gexf.Edges) != 1 {
+		t.Fatalf("%d nodes and %d edges, want 2 and 1", len(gexf.Nodes), len(gexf.Edges))
+	}
+
+	// attributes are numbered in graphNodeAttributes order, em_id is 0 and keypoint_z 4
+	if first := gexf.Nodes[0].values(); gexf.Nodes[0].ID != "101" || first["0"] != "7" || first["4"] != "3" {
+		t.Errorf("node %s %v", gexf.Nodes[0].ID, first)
+	}
+
+	edge := gexf.Edges[0]
+	if edge.ID != "0" || edge.Source != "101" || edge.Target != "102" || edge.Weight != "2" || edge.values()["synapse_size"] != "2" {
+		t.Errorf("edge %+v", edge)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	if got, want := exportBody(t, "csv"), "pre,post,synapses,synapse_size\n101,102,2,2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportNetworkx(t *testing.T) {
+	var graph struct {
+		Directed bool                     `json:"directed"`
+		Graph    map[string]string        `json:"graph"`
+		Nodes    []map[string]int         `json:"nodes"`
+		Links    []map[string]interface{} `json:"links"`
+	}
+
+	if err := json.Unmarshal([]byte(exportBody(t, "networkx")), &graph); err != nil {
+		t.Fatal(err)
+	}
+
+	if !graph.Directed || graph.Graph["channel"] != "c/e/seg" || len(graph.Nodes) != 2 || len(graph.Links) != 1 {
+		t.Fatalf("%+v", graph)
+	}
+
+	if graph.Nodes[0]["id"] != 101 || graph.Nodes[0]["em_id"] != 7 || graph.Nodes[0]["keypoint_y"] != 2 {
+		t.Errorf("node %v", graph.Nodes[0])
+	}
+
+	if link := graph.Links[0]; link["source"] != 101.0 || link["target"] != 102.0 || link["weight"] != 2.0 {
+		t.Errorf("link %v", link)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	useTestStores(t)
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/export/c/e/seg/?format=dot", status: 400},
+		{url: "/export/c/e/nope/", status: 404},
+	})
+}
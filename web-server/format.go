@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// functional endpoints send the datajoint blob as is unless ?format= or the Accept header asks for something else
+
+var errBadFormat = errors.New("format should be blob, npy, json, csv or float32")
+
+var blobContentTypes = map[string]string{
+	"blob":    "application/octet-stream",
+	"npy":     "application/x-npy",
+	"json":    "application/json",
+	"csv":     "text/csv",
+	"float32": "application/x-float32",
+}
+
+var errBadBatchFormat = errors.New("format should be blob or npz")
+
+// batchContentTypes are the outputs of the stacked cell batch endpoints
+var batchContentTypes = map[string]string{
+	"blob": "application/octet-stream",
+	"npz":  "application/x-npz",
+}
+
+// responseFormat picks the output format, ?format= wins over Accept and anything unrecognized in Accept means blob
+func responseFormat(r *http.Request) (string, error) {
+	return negotiateFormat(r, blobContentTypes, errBadFormat)
+}
+
+// batchFormat is responseFormat for the stacked cell batch endpoints
+func batchFormat(r *http.Request) (string, error) {
+	return negotiateFormat(r, batchContentTypes, errBadBatchFormat)
+}
+
+// negotiateFormat picks one of contentTypes' formats, badFormat is returned for a ?format= that isn't one
+func negotiateFormat(r *http.Request, contentTypes map[string]string, badFormat error) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := contentTypes[format]; !ok {
+			return "", badFormat
+		}
+		return format, nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		for format, contentType := range contentTypes {
+			if mediaType == contentType || mediaType == "application/npy" && format == "npy" {
+				return format, nil
+			}
+		}
+	}
+
+	return "blob", nil
+}
+
+// writeBlob answers with a blob in the given format, decoding it unless the raw blob was asked for
+func writeBlob(w http.ResponseWriter, blob []byte, format string) {
+	if format == "blob" {
+		w.Header().Set("Content-Type", blobContentTypes[format])
+		w.Write(blob)
+		return
+	}
+
+	value, err := decodeBlob(blob)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	array, isArray := value.(blobArray)
+
+	// only json can hold strings, cells and structs
+	if !isArray && format != "json" {
+		httpError(w, http.StatusNotAcceptable, errUnsupportedBlob)
+		return
+	}
+
+	if !isArray {
+		w.Header().Set("Content-Type", blobContentTypes[format])
+		json.NewEncoder(w).Encode(blobJSON(value))
+		return
+	}
+
+	writeArray(w, array, format)
+}
+
+// writeArray writes a decoded array in any of the formats, blob re-encoding it
+func writeArray(w http.ResponseWriter, a blobArray, format string) {
+	w.Header().Set("Content-Type", blobContentTypes[format])
+
+	if format == "blob" {
+		w.Write(a.blob())
+		return
+	}
+
+	dims := make([]string, len(a.Shape))
+	for i, dim := range a.Shape {
+		dims[i] = strconv.Itoa(dim)
+	}
+	w.Header().Set("X-Array-Shape", strings.Join(dims, ","))
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	switch format {
+	case "npy":
+		// the blob is already in fortran order, so the data goes out untouched
+		out.Write(npyHeader(mxNumericClasses[a.Class].descr, true, a.Shape))
+		out.Write(a.Data)
+	case "json":
+		a.writeJSON(out, 0, 0)
+		out.WriteString("\n")
+	case "csv":
+		a.writeCSV(out)
+	case "float32":
+		w.Header().Set("X-Array-Order", "C")
+
+		var buf [4]byte
+		a.eachRowMajor(func(v float64) {
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(v)))
+			out.Write(buf[:])
+		})
+	}
+}
+
+// strides of each dimension in the fortran ordered data
+func (a blobArray) strides() []int {
+	res := make([]int, len(a.Shape))
+	stride := 1
+	for d, dim := range a.Shape {
+		res[d] = stride
+		stride *= dim
+	}
+	return res
+}
+
+// eachRowMajor calls fn on every element in row major order
+func (a blobArray) eachRowMajor(fn func(float64)) {
+	if a.len() == 0 {
+		return
+	}
+
+	strides := a.strides()
+	index := make([]int, len(a.Shape))
+
+	for {
+		offset := 0
+		for d, i := range index {
+			offset += i * strides[d]
+		}
+		fn(a.at(offset))
+
+		d := len(index) - 1
+		for ; d >= 0; d-- {
+			index[d]++
+			if index[d] < a.Shape[d] {
+				break
+			}
+			index[d] = 0
+		}
+
+		if d < 0 {
+			return
+		}
+	}
+}
+
+// json has no NaN or infinity, those become null
+func appendJSONFloat(buf []byte, v float64) []byte {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return append(buf, "null"...)
+	}
+	return strconv.AppendFloat(buf, v, 'g', -1, 64)
+}
+
+// writeJSON writes the array as nested lists, one level per dimension
+func (a blobArray) writeJSON(w *bufio.Writer, dim int, offset int) {
+	if dim == len(a.Shape) {
+		var buf [32]byte
+		w.Write(appendJSONFloat(buf[:0], a.at(offset)))
+		return
+	}
+
+	stride := a.strides()[dim]
+
+	w.WriteByte('[')
+	for i := 0; i < a.Shape[dim]; i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		a.writeJSON(w, dim+1, offset+i*stride)
+	}
+	w.WriteByte(']')
+}
+
+// writeCSV writes a row per index of the first dimension, the others are flattened in row major order
+func (a blobArray) writeCSV(w io.Writer) {
+	columns := 1
+	for _, dim := range a.Shape[1:] {
+		columns *= dim
+	}
+
+	var buf []byte
+	column := 0
+
+	a.eachRowMajor(func(v float64) {
+		if column > 0 {
+			buf = append(buf, ',')
+		}
+		if !math.IsNaN(v) {
+			buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+		}
+
+		column++
+		if column == columns {
+			buf = append(buf, '\n')
+			w.Write(buf)
+			buf, column = buf[:0], 0
+		}
+	})
+}
+
+// blobJSON turns a decoded value into something encoding/json can write
+// 1x1 arrays inside cells and structs become plain numbers, as datajoint gives them back
+func blobJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case blobArray:
+		if v.len() == 1 {
+			return jsonFloat(v.at(0))
+		}
+		return v.nested(0, 0)
+	case blobCell:
+		res := make([]interface{}, len(v.Values))
+		for i, cell := range v.Values {
+			res[i] = blobJSON(cell)
+		}
+		return res
+	case blobStruct:
+		res := make([]map[string]interface{}, len(v.Values))
+		for i, fields := range v.Values {
+			res[i] = make(map[string]interface{})
+			for j, name := range v.Fields {
+				res[i][name] = blobJSON(fields[j])
+			}
+		}
+		if len(res) == 1 {
+			return res[0]
+		}
+		return res
+	}
+
+	return value
+}
+
+// jsonFloat is a float64 that encodes NaN and infinity as null
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	return appendJSONFloat(nil, float64(f)), nil
+}
+
+func (a blobArray) nested(dim int, offset int) interface{} {
+	if dim == len(a.Shape) {
+		return jsonFloat(a.at(offset))
+	}
+
+	stride := a.strides()[dim]
+
+	res := make([]interface{}, a.Shape[dim])
+	for i := range res {
+		res[i] = a.nested(dim+1, offset+i*stride)
+	}
+	return res
+}
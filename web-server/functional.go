@@ -2,43 +2,48 @@ package main
 
 import (
 	"database/sql"
+	"sort"
 	"strconv"
 )
 
-var functionalDb *sql.DB
+// mysqlFunctionalStore is the FunctionalStore backed by the functional MySQL database
+type mysqlFunctionalStore struct {
+	db *sql.DB
+}
 
 type pupilGetter func(int) ([]byte, error)
 
 // pupil information is separated into 3 longblobs, pupil_r (radius), pupil_x (x pos), pupil_y (y_pos)
-func getPupilR(scanID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetPupilR(scanID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select pupil_r from pupil where scan_idx = ?`, scanID).Scan(&res)
+	err := s.db.QueryRow(`select pupil_r from pupil where scan_idx = ?`, scanID).Scan(&res)
 
 	return res, err
 }
 
-func getPupilX(scanID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetPupilX(scanID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select pupil_x from pupil where scan_idx = ?`, scanID).Scan(&res)
+	err := s.db.QueryRow(`select pupil_x from pupil where scan_idx = ?`, scanID).Scan(&res)
 
 	return res, err
 }
 
-func getPupilY(scanID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetPupilY(scanID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select pupil_y from pupil where scan_idx = ?`, scanID).Scan(&res)
+	err := s.db.QueryRow(`select pupil_y from pupil where scan_idx = ?`, scanID).Scan(&res)
 
 	return res, err
 }
 
-func getScans() ([]int, error) {
-	scans, err2 := functionalDb.Query(`SELECT scan_idx from scan ORDER BY scan_idx asc`)
+func (s *mysqlFunctionalStore) GetScans() ([]int, error) {
+	scans, err2 := s.db.Query(`SELECT scan_idx from scan ORDER BY scan_idx asc`)
 
 	res := make([]int, 0)
 
 	if err2 != nil {
 		return res, err2
 	}
+	defer scans.Close()
 
 	for scans.Next() {
 		var scanIdx int
@@ -76,10 +81,10 @@ type ScanMetadataRes struct {
 	SliceOffsets []int   `json:"sliceOffsets"`
 }
 
-func getScanMetadata(scanID int) (ScanMetadataRes, error) {
+func (s *mysqlFunctionalStore) GetScanMetadata(scanID int) (ScanMetadataRes, error) {
 	res := ScanMetadataRes{}
 
-	err := functionalDb.QueryRow(`select
+	err := s.db.QueryRow(`select
 			depth, laser_power, wavelength, filename, nframes, px_width, px_height, um_width, um_height, bidirectional, fps, zoom, nchannels, nslices, fill_fraction, raster_phase
 		from
 			scan,
@@ -92,11 +97,12 @@ func getScanMetadata(scanID int) (ScanMetadataRes, error) {
 		return res, err
 	}
 
-	slices, err2 := functionalDb.Query(`SELECT z_offset from slice where scan_idx = ? ORDER BY slice asc`, scanID)
+	slices, err2 := s.db.Query(`SELECT z_offset from slice where scan_idx = ? ORDER BY slice asc`, scanID)
 
 	if err2 != nil {
 		return res, err2
 	}
+	defer slices.Close()
 
 	zOffsets := make([]int, 0)
 
@@ -116,58 +122,73 @@ func getScanMetadata(scanID int) (ScanMetadataRes, error) {
 	return res, err
 }
 
-func getStimulus(scanID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetSliceOffset(scanID int, slice int) (int, error) {
+	var res int
+	err := s.db.QueryRow(`select z_offset from slice where scan_idx = ? and slice = ?`, scanID, slice).Scan(&res)
+
+	return res, err
+}
+
+func (s *mysqlFunctionalStore) GetStimulus(scanID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select movie from stimulus where scan_idx = ?`, scanID).Scan(&res)
+	err := s.db.QueryRow(`select movie from stimulus where scan_idx = ?`, scanID).Scan(&res)
 
 	return res, err
 }
 
-func getStimulusConditions(scanID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetStimulusConditions(scanID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select conditions from stimulus where scan_idx = ?`, scanID).Scan(&res)
+	err := s.db.QueryRow(`select conditions from stimulus where scan_idx = ?`, scanID).Scan(&res)
 
 	return res, err
 }
 
-func getTreadmill(scanID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetTreadmill(scanID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select treadmill_speed from treadmill where scan_idx = ?`, scanID).Scan(&res)
+	err := s.db.QueryRow(`select treadmill_speed from treadmill where scan_idx = ?`, scanID).Scan(&res)
 
 	return res, err
 }
 
 type cellDataGetter func(int, int, int) ([]byte, error)
 
-func getTrace(scanID int, slice int, cellID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetTrace(scanID int, slice int, cellID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select trace from trace where scan_idx = ? and slice = ? and em_id = ?`, scanID, slice, cellID).Scan(&res)
+	err := s.db.QueryRow(`select trace from trace where scan_idx = ? and slice = ? and em_id = ?`, scanID, slice, cellID).Scan(&res)
 
 	return res, err
 }
 
-func getSpike(scanID int, slice int, cellID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetSpike(scanID int, slice int, cellID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select rate from __spike where scan_idx = ? and slice = ? and em_id = ?`, scanID, slice, cellID).Scan(&res)
+	err := s.db.QueryRow(`select rate from __spike where scan_idx = ? and slice = ? and em_id = ?`, scanID, slice, cellID).Scan(&res)
 
 	return res, err
 }
 
-func getMask(scanID int, slice int, cellID int) ([]byte, error) {
+func (s *mysqlFunctionalStore) GetMask(scanID int, slice int, cellID int) ([]byte, error) {
 	var res []byte
-	err := functionalDb.QueryRow(`select mask_pixels from mask where scan_idx = ? and slice = ? and em_id = ?`, scanID, slice, cellID).Scan(&res)
+	err := s.db.QueryRow(`select mask_pixels from mask where scan_idx = ? and slice = ? and em_id = ?`, scanID, slice, cellID).Scan(&res)
 
 	return res, err
 }
 
-func getSlicesForCell(cellID int) (map[string][]int, error) {
+func (s *mysqlFunctionalStore) HasMask(scanID int, slice int, cellID int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`select 1 from mask where scan_idx = ? and slice = ? and em_id = ?`, scanID, slice, cellID).Scan(&exists)
+
+	return exists, err
+}
+
+func (s *mysqlFunctionalStore) GetSlicesForCell(cellID int) (map[string][]int, error) {
 	res := make(map[string][]int)
 
-	slices, err := functionalDb.Query(`select scan_idx, slice from mask where em_id = ?`, cellID)
+	slices, err := s.db.Query(`select scan_idx, slice from mask where em_id = ?`, cellID)
 
 	if err != nil {
 		return res, err
 	}
+	defer slices.Close()
 
 	for slices.Next() {
 		var scanIdx int
@@ -184,3 +205,170 @@ func getSlicesForCell(cellID int) (map[string][]int, error) {
 
 	return res, err
 }
+
+func (s *mysqlFunctionalStore) GetSlicesForCells(cellIDs []int) (map[int]map[string][]int, error) {
+	res := make(map[int]map[string][]int)
+
+	for _, chunk := range chunkIds(cellIDs) {
+		rows, err := s.db.Query(`select em_id, scan_idx, slice from mask where em_id in (`+placeholders(len(chunk))+`)`, intArgs(chunk)...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var emID, scanIdx, slice int
+			err2 := rows.Scan(&emID, &scanIdx, &slice)
+
+			if err2 != nil {
+				rows.Close()
+				return nil, err2
+			}
+
+			if res[emID] == nil {
+				res[emID] = make(map[string][]int)
+			}
+			res[emID][strconv.Itoa(scanIdx)] = append(res[emID][strconv.Itoa(scanIdx)], slice)
+		}
+
+		rows.Close()
+
+		if err3 := rows.Err(); err3 != nil {
+			return nil, err3
+		}
+	}
+
+	return res, nil
+}
+
+func (s *mysqlFunctionalStore) GetScansForCells(cellIDs []int) ([]int, error) {
+	scans := make(map[int]bool)
+
+	for _, chunk := range chunkIds(cellIDs) {
+		rows, err := s.db.Query(`select distinct scan_idx from mask where em_id in (`+placeholders(len(chunk))+`)`, intArgs(chunk)...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var scanIdx int
+			err2 := rows.Scan(&scanIdx)
+
+			if err2 != nil {
+				rows.Close()
+				return nil, err2
+			}
+
+			scans[scanIdx] = true
+		}
+
+		rows.Close()
+
+		if err3 := rows.Err(); err3 != nil {
+			return nil, err3
+		}
+	}
+
+	res := make([]int, 0, len(scans))
+	for scanIdx := range scans {
+		res = append(res, scanIdx)
+	}
+	sort.Ints(res)
+
+	return res, nil
+}
+
+// getCellBatch reads one blob column of a per cell table for many cells in one go
+func (s *mysqlFunctionalStore) getCellBatch(table string, column string, scanID int, slice int, cellIDs []int) ([]cellData, error) {
+	res := make([]cellData, 0)
+
+	for _, chunk := range chunkIds(cellIDs) {
+		args := append([]interface{}{scanID, slice, slice}, intArgs(chunk)...)
+
+		rows, err := s.db.Query(`select slice, em_id, `+column+` from `+table+`
+			where scan_idx = ? and (? = -1 or slice = ?) and em_id in (`+placeholders(len(chunk))+`)`, args...)
+
+		if err != nil {
+			return res, err
+		}
+
+		for rows.Next() {
+			var cell cellData
+			err2 := rows.Scan(&cell.Slice, &cell.EmID, &cell.Data)
+
+			if err2 != nil {
+				rows.Close()
+				return res, err2
+			}
+
+			res = append(res, cell)
+		}
+
+		rows.Close()
+
+		if err3 := rows.Err(); err3 != nil {
+			return res, err3
+		}
+	}
+
+	return res, nil
+}
+
+func (s *mysqlFunctionalStore) GetTraceBatch(scanID int, slice int, cellIDs []int) ([]cellData, error) {
+	return s.getCellBatch("trace", "trace", scanID, slice, cellIDs)
+}
+
+func (s *mysqlFunctionalStore) GetSpikeBatch(scanID int, slice int, cellIDs []int) ([]cellData, error) {
+	return s.getCellBatch("__spike", "rate", scanID, slice, cellIDs)
+}
+
+func (s *mysqlFunctionalStore) GetSliceCells(scanID int, slice int) ([]int, error) {
+	res := make([]int, 0)
+
+	rows, err := s.db.Query(`select distinct em_id from mask
+		where scan_idx = ? and (? = -1 or slice = ?) order by em_id`, scanID, slice, slice)
+
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var emID int
+		err2 := rows.Scan(&emID)
+
+		if err2 != nil {
+			return res, err2
+		}
+
+		res = append(res, emID)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *mysqlFunctionalStore) GetSliceMasks(scanID int, slice int) ([]cellData, error) {
+	res := make([]cellData, 0)
+
+	rows, err := s.db.Query(`select slice, em_id, mask_pixels from mask
+		where scan_idx = ? and (? = -1 or slice = ?) order by em_id, slice`, scanID, slice, slice)
+
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cell cellData
+		err2 := rows.Scan(&cell.Slice, &cell.EmID, &cell.Data)
+
+		if err2 != nil {
+			return res, err2
+		}
+
+		res = append(res, cell)
+	}
+
+	return res, rows.Err()
+}
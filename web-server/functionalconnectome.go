@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+type functionalNode struct {
+	BossID int `json:"boss_id"`
+	EmID   int `json:"em_id"`
+	// slices the cell was imaged in, keyed by scan
+	Slices map[string][]int `json:"slices"`
+	// synapses to and from other functional neurons
+	InSynapses  int `json:"in_synapses"`
+	OutSynapses int `json:"out_synapses"`
+}
+
+type functionalEdge struct {
+	Pre         int `json:"pre"`
+	Post        int `json:"post"`
+	Synapses    int `json:"synapses"`
+	SynapseSize int `json:"synapse_size"`
+}
+
+// FunctionalConnectomeRes boop
+type FunctionalConnectomeRes struct {
+	Nodes []functionalNode `json:"nodes"`
+	Edges []functionalEdge `json:"edges"`
+}
+
+// getFunctionalConnectome is the connectivity among every neuron of a channel that has an em_id, keyed by boss id
+func getFunctionalConnectome(channelID int) (FunctionalConnectomeRes, error) {
+	graph, err := functionalGraph(channelID)
+	if err != nil {
+		return graph, err
+	}
+
+	// the graph is shared, so the slices go on a copy of its nodes
+	res := FunctionalConnectomeRes{Nodes: append([]functionalNode(nil), graph.Nodes...), Edges: graph.Edges}
+
+	emIDs := make([]int, len(res.Nodes))
+	for i, node := range res.Nodes {
+		emIDs[i] = node.EmID
+	}
+
+	slices, err := functionalStore.GetSlicesForCells(emIDs)
+	if err != nil {
+		return res, err
+	}
+
+	for i, node := range res.Nodes {
+		res.Nodes[i].Slices = slices[node.EmID]
+		if res.Nodes[i].Slices == nil {
+			res.Nodes[i].Slices = make(map[string][]int)
+		}
+	}
+
+	return res, nil
+}
+
+// functional graphs of each channel, built the first time a channel is asked for
+// like the spatial indexes, a restart picks up newly imported data
+var functionalGraphs = struct {
+	sync.Mutex
+	byChannel map[int]FunctionalConnectomeRes
+}{byChannel: make(map[int]FunctionalConnectomeRes)}
+
+// functionalGraph is getFunctionalConnectome without the slices, computed once per channel, callers shouldn't modify it
+func functionalGraph(channelID int) (FunctionalConnectomeRes, error) {
+	functionalGraphs.Lock()
+	defer functionalGraphs.Unlock()
+
+	if graph, ok := functionalGraphs.byChannel[channelID]; ok {
+		return graph, nil
+	}
+
+	graph, err := buildFunctionalGraph(channelID)
+	if err != nil {
+		return graph, err
+	}
+
+	functionalGraphs.byChannel[channelID] = graph
+
+	return graph, nil
+}
+
+// buildFunctionalGraph reads the coregistered neurons of a channel and the edges among them
+func buildFunctionalGraph(channelID int) (FunctionalConnectomeRes, error) {
+	res := FunctionalConnectomeRes{Nodes: make([]functionalNode, 0), Edges: make([]functionalEdge, 0)}
+
+	index := make(map[int]int)
+
+	err := structuralStore.EachCoregisteredNeuron(channelID, func(node graphNode) error {
+		index[node.BossID] = len(res.Nodes)
+		res.Nodes = append(res.Nodes, functionalNode{BossID: node.BossID, EmID: *node.EmID})
+		return nil
+	})
+
+	if err != nil {
+		return res, err
+	}
+
+	err = structuralStore.EachCoregisteredEdge(channelID, func(edge synapticEdge) error {
+		pre, preOk := index[edge.PreBossID]
+		post, postOk := index[edge.PostBossID]
+
+		if preOk && postOk {
+			res.Nodes[pre].OutSynapses += edge.Synapses
+			res.Nodes[post].InSynapses += edge.Synapses
+			res.Edges = append(res.Edges, functionalEdge{
+				Pre: edge.PreBossID, Post: edge.PostBossID, Synapses: edge.Synapses, SynapseSize: edge.Size})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return res, err
+	}
+
+	sort.Slice(res.Nodes, func(i, j int) bool { return res.Nodes[i].BossID < res.Nodes[j].BossID })
+	sort.Slice(res.Edges, func(i, j int) bool {
+		if res.Edges[i].Pre != res.Edges[j].Pre {
+			return res.Edges[i].Pre < res.Edges[j].Pre
+		}
+		return res.Edges[i].Post < res.Edges[j].Post
+	})
+
+	return res, nil
+}
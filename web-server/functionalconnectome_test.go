@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFunctionalConnectome(t *testing.T) {
+	useTestStores(t)
+
+	// 101, 103 and 105 have em_ids, only 105 to 101 joins two of them
+	runHandlerCases(t, []handlerCase{
+		{url: "/functional_connectome/c/e/seg/", status: 200, want: `{"nodes":[` +
+			`{"boss_id":101,"em_id":1000,"slices":{"1":[1]},"in_synapses":1,"out_synapses":0},` +
+			`{"boss_id":103,"em_id":3000,"slices":{},"in_synapses":0,"out_synapses":0},` +
+			`{"boss_id":105,"em_id":5000,"slices":{},"in_synapses":0,"out_synapses":1}],` +
+			`"edges":[{"pre":105,"post":101,"synapses":1,"synapse_size":5}]}`},
+		{url: "/functional_connectome/c/e/none/", status: 404},
+	})
+}
+
+func TestFunctionalGraphCache(t *testing.T) {
+	structural, _ := useTestStores(t)
+
+	if _, err := getFunctionalConnectome(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the graph is kept after the first request, without the slices added to the response
+	structural.Synapses = nil
+
+	graph, err := functionalGraph(1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(graph.Edges) != 1 || len(graph.Nodes) != 3 {
+		t.Errorf("%d nodes and %d edges, want the graph from before the synapses were removed", len(graph.Nodes), len(graph.Edges))
+	}
+
+	for _, node := range graph.Nodes {
+		if node.Slices != nil {
+			t.Errorf("cached node %d has slices %v", node.BossID, node.Slices)
+		}
+	}
+}
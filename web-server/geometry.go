@@ -0,0 +1,114 @@
+package main
+
+// MaskGeometryRes is where a functional ROI sits, in pixels of its scan and in microns
+type MaskGeometryRes struct {
+	EmID   int `json:"em_id"`
+	Slice  int `json:"slice"`
+	Pixels int `json:"pixels"`
+	// x, y of the mean pixel, 0 based from the top left of the scan
+	Centroid [2]float64 `json:"centroid"`
+	AreaUm2  float64    `json:"area_um2"`
+	// centroid scaled by the scan's um per pixel, z the slice's z_offset
+	PositionUm [3]float64 `json:"position_um"`
+}
+
+// maskScale is the scan geometry a mask needs to be placed in microns
+type maskScale struct {
+	width, height   int
+	umPerPxX        float64
+	umPerPxY        float64
+	zOffsetsBySlice map[int]int
+}
+
+func getMaskScale(scanID int) (maskScale, error) {
+	metadata, err := functionalStore.GetScanMetadata(scanID)
+	if err != nil {
+		return maskScale{}, err
+	}
+
+	if metadata.PxWidth <= 0 || metadata.PxHeight <= 0 {
+		return maskScale{}, errNoScanSize
+	}
+
+	return maskScale{
+		width:           metadata.PxWidth,
+		height:          metadata.PxHeight,
+		umPerPxX:        metadata.UmWidth / float64(metadata.PxWidth),
+		umPerPxY:        metadata.UmHeight / float64(metadata.PxHeight),
+		zOffsetsBySlice: make(map[int]int)}, nil
+}
+
+func (m maskScale) geometry(scanID int, cell cellData) (MaskGeometryRes, error) {
+	res := MaskGeometryRes{EmID: cell.EmID, Slice: cell.Slice}
+
+	zOffset, ok := m.zOffsetsBySlice[cell.Slice]
+	if !ok {
+		var err error
+		zOffset, err = functionalStore.GetSliceOffset(scanID, cell.Slice)
+		if err != nil {
+			return res, err
+		}
+		m.zOffsetsBySlice[cell.Slice] = zOffset
+	}
+
+	pixels, err := maskPixels(cell.Data, m.width, m.height)
+	if err != nil {
+		return res, err
+	}
+
+	res.Pixels = len(pixels)
+	res.AreaUm2 = float64(len(pixels)) * m.umPerPxX * m.umPerPxY
+
+	if len(pixels) > 0 {
+		for _, pixel := range pixels {
+			res.Centroid[0] += float64(pixel % m.width)
+			res.Centroid[1] += float64(pixel / m.width)
+		}
+		res.Centroid[0] /= float64(len(pixels))
+		res.Centroid[1] /= float64(len(pixels))
+	}
+
+	res.PositionUm = [3]float64{res.Centroid[0] * m.umPerPxX, res.Centroid[1] * m.umPerPxY, float64(zOffset)}
+
+	return res, nil
+}
+
+// getMaskGeometry places one cell's ROI in a slice
+func getMaskGeometry(scanID int, slice int, emID int) (MaskGeometryRes, error) {
+	scale, err := getMaskScale(scanID)
+	if err != nil {
+		return MaskGeometryRes{}, err
+	}
+
+	mask, err := functionalStore.GetMask(scanID, slice, emID)
+	if err != nil {
+		return MaskGeometryRes{}, err
+	}
+
+	return scale.geometry(scanID, cellData{Slice: slice, EmID: emID, Data: mask})
+}
+
+// getSliceMaskGeometry places every ROI in a slice, or in the whole scan for allSlices
+func getSliceMaskGeometry(scanID int, slice int) ([]MaskGeometryRes, error) {
+	res := make([]MaskGeometryRes, 0)
+
+	scale, err := getMaskScale(scanID)
+	if err != nil {
+		return res, err
+	}
+
+	cells, err := functionalStore.GetSliceMasks(scanID, slice)
+	if err != nil {
+		return res, err
+	}
+
+	for _, cell := range cells {
+		geometry, err := scale.geometry(scanID, cell)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, geometry)
+	}
+
+	return res, nil
+}
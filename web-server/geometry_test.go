@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMaskGeometry(t *testing.T) {
+	useMasks(t)
+
+	scan := functionalStore.(*memoryFunctionalStore).Scans[1]
+	scan.Metadata.UmWidth, scan.Metadata.UmHeight = 10, 12
+	scan.ZOffsets = map[int]int{1: -30}
+	functionalStore.(*memoryFunctionalStore).Scans[1] = scan
+
+	// 2 um per pixel across and 3 down, the square is centered on pixel 2, 1
+	square := `{"em_id":1000,"slice":1,"pixels":9,"centroid":[2,1],"area_um2":54,"position_um":[4,3,-30]}`
+	corner := `{"em_id":3000,"slice":1,"pixels":1,"centroid":[4,3],"area_um2":6,"position_um":[8,9,-30]}`
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/mask_geometry_functional/1/1/1000/", status: 200, want: square},
+		{url: "/mask_geometry/c/e/seg/1/1/101/", status: 200, want: square},
+		{url: "/slice_mask_geometry/1/1/", status: 200, want: `[` + square + `,` + corner + `]`},
+		{url: "/slice_mask_geometry/1/2/", status: 200, want: `[]`},
+
+		{url: "/mask_geometry_functional/1/1/2000/", status: 404},
+		{url: "/mask_geometry/c/e/seg/1/1/102/", status: 404},
+		{url: "/mask_geometry_functional/1/x/1000/", status: 400},
+		{url: "/slice_mask_geometry/2/1/", status: 404},
+	})
+}
+
+func TestMaskGeometryNoOffset(t *testing.T) {
+	useMasks(t)
+
+	// a slice without a z_offset can't be placed
+	runHandlerCases(t, []handlerCase{
+		{url: "/mask_geometry_functional/1/1/1000/", status: 404},
+	})
+}
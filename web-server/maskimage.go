@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+var errBadMaskMode = errors.New("mode should be binary, weighted or outline")
+var errNoScanSize = errors.New("scan has no px_width and px_height")
+
+// maskPixels decodes mask_pixels into 0 based indices of a row major px_height x px_width image
+// the stored indices are 1 based, anything that falls outside the image is dropped
+func maskPixels(blob []byte, width int, height int) ([]int, error) {
+	array, err := decodeArray(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]int, 0, array.len())
+
+	for i := 0; i < array.len(); i++ {
+		pixel := int(array.at(i)) - 1
+		if pixel >= 0 && pixel < width*height {
+			res = append(res, pixel)
+		}
+	}
+
+	return res, nil
+}
+
+// outline keeps the pixels of a mask that touch a pixel outside it, or the edge of the image
+func outline(pixels []int, width int, height int) []int {
+	inside := make(map[int]bool, len(pixels))
+	for _, pixel := range pixels {
+		inside[pixel] = true
+	}
+
+	res := make([]int, 0)
+
+	for _, pixel := range pixels {
+		x, y := pixel%width, pixel/width
+
+		if x == 0 || y == 0 || x == width-1 || y == height-1 ||
+			!inside[pixel-1] || !inside[pixel+1] || !inside[pixel-width] || !inside[pixel+width] {
+			res = append(res, pixel)
+		}
+	}
+
+	return res
+}
+
+// sliceActivity weights each cell of a slice by its mean spike rate, the most active cell at 1
+// mask only stores which pixels are in an ROI and not per pixel weights, so weighted images shade whole ROIs by activity
+// cells without spikes, or slices where no cell is active, weigh 0
+func sliceActivity(scanID int, slice int) (map[int]float64, error) {
+	cellIDs, err := functionalStore.GetSliceCells(scanID, slice)
+	if err != nil {
+		return nil, err
+	}
+
+	spikes, err := functionalStore.GetSpikeBatch(scanID, slice, cellIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int]float64)
+	largest := 0.0
+
+	for _, cell := range spikes {
+		array, err := decodeArray(cell.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		sum, n := 0.0, 0
+		for i := 0; i < array.len(); i++ {
+			if v := array.at(i); !math.IsNaN(v) && !math.IsInf(v, 0) {
+				sum += v
+				n++
+			}
+		}
+
+		if n > 0 {
+			res[cell.EmID] = math.Max(0, sum/float64(n))
+			largest = math.Max(largest, res[cell.EmID])
+		}
+	}
+
+	for emID := range res {
+		if largest > 0 {
+			res[emID] /= largest
+		}
+	}
+
+	return res, nil
+}
+
+// renderMask draws one ROI on black, white for binary and outline and at weight of white for weighted
+func renderMask(pixels []int, width int, height int, mode string, weight float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	if mode == "outline" {
+		pixels = outline(pixels, width, height)
+	}
+
+	value := uint8(255)
+	if mode == "weighted" {
+		value = uint8(math.Round(255 * weight))
+	}
+
+	for _, pixel := range pixels {
+		img.Pix[pixel] = value
+	}
+
+	return img
+}
+
+// labelColor is a bright color that stays the same for an em_id across images
+func labelColor(emID int) color.RGBA {
+	hash := uint32(emID) * 2654435761
+
+	// hsv with full saturation and value, so no label comes out too dark to see on black
+	hue := float64((hash>>16)%360) / 60
+	c := uint8(255 * (1 - math.Abs(math.Mod(hue, 2)-1)))
+
+	switch int(hue) {
+	case 0:
+		return color.RGBA{255, c, 0, 255}
+	case 1:
+		return color.RGBA{c, 255, 0, 255}
+	case 2:
+		return color.RGBA{0, 255, c, 255}
+	case 3:
+		return color.RGBA{0, c, 255, 255}
+	case 4:
+		return color.RGBA{c, 0, 255, 255}
+	}
+	return color.RGBA{255, 0, c, 255}
+}
+
+// renderSliceMasks composites every cell of a slice, later em_ids drawn over earlier ones where they overlap
+// weighted mode darkens each cell's label color by its weight
+func renderSliceMasks(cells []cellData, width int, height int, mode string, weights map[int]float64) (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i+3] = 255
+	}
+
+	for _, cell := range cells {
+		pixels, err := maskPixels(cell.Data, width, height)
+		if err != nil {
+			return nil, err
+		}
+
+		if mode == "outline" {
+			pixels = outline(pixels, width, height)
+		}
+
+		c := labelColor(cell.EmID)
+		if mode == "weighted" {
+			weight := weights[cell.EmID]
+			c = color.RGBA{uint8(math.Round(float64(c.R) * weight)), uint8(math.Round(float64(c.G) * weight)), uint8(math.Round(float64(c.B) * weight)), 255}
+		}
+
+		for _, pixel := range pixels {
+			copy(img.Pix[4*pixel:], []uint8{c.R, c.G, c.B, c.A})
+		}
+	}
+
+	return img, nil
+}
+
+// maskImageMode reads ?mode=, binary by default
+func maskImageMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return "binary", nil
+	case "binary", "weighted", "outline":
+		return mode, nil
+	}
+	return "", errBadMaskMode
+}
+
+// scanSize is the px_width and px_height masks index into
+func scanSize(scanID int) (int, int, error) {
+	metadata, err := functionalStore.GetScanMetadata(scanID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if metadata.PxWidth <= 0 || metadata.PxHeight <= 0 {
+		return 0, 0, errNoScanSize
+	}
+
+	return metadata.PxWidth, metadata.PxHeight, nil
+}
+
+// getMaskImage renders one cell's ROI in a slice, emID being the functional id
+func getMaskImage(scanID int, slice int, emID int, mode string) (*image.Gray, error) {
+	width, height, err := scanSize(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := functionalStore.GetMask(scanID, slice, emID)
+	if err != nil {
+		return nil, err
+	}
+
+	pixels, err := maskPixels(blob, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	weight := 1.0
+
+	if mode == "weighted" {
+		activity, err := sliceActivity(scanID, slice)
+		if err != nil {
+			return nil, err
+		}
+		weight = activity[emID]
+	}
+
+	return renderMask(pixels, width, height, mode, weight), nil
+}
+
+// getSliceMasksImage renders every cell's ROI in a slice in its label color
+func getSliceMasksImage(scanID int, slice int, mode string) (*image.RGBA, error) {
+	width, height, err := scanSize(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	cells, err := functionalStore.GetSliceMasks(scanID, slice)
+	if err != nil {
+		return nil, err
+	}
+
+	var weights map[int]float64
+
+	if mode == "weighted" {
+		weights, err = sliceActivity(scanID, slice)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return renderSliceMasks(cells, width, height, mode, weights)
+}
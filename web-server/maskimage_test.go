@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+// useMasks gives scan 1 a 5 x 4 image with em_id 1000 a 3 x 3 square at the top and 3000 the bottom right pixel
+// 3000 spikes four times as much as 1000 on average
+func useMasks(t *testing.T) {
+	_, functional := useTestStores(t)
+
+	scan := functional.Scans[1]
+	scan.Metadata.PxWidth, scan.Metadata.PxHeight = 5, 4
+	functional.Scans[1] = scan
+
+	// 1 based, row major, 0 and 21 are outside the image
+	square := ndarray{Shape: []int{11, 1}, Data: []float64{2, 3, 4, 7, 8, 9, 12, 13, 14, 0, 21}}
+
+	functional.Cells[0].Mask = square.djBlob()
+	functional.Cells[0].Spike = ndarray{Shape: []int{1, 3}, Data: []float64{0, 2, math.NaN()}}.djBlob()
+
+	functional.Cells = append(functional.Cells, memoryCell{Scan: 1, Slice: 1, EmID: 3000,
+		Mask:  ndarray{Shape: []int{1, 1}, Data: []float64{20}}.djBlob(),
+		Spike: ndarray{Shape: []int{1, 2}, Data: []float64{3, 5}}.djBlob()})
+}
+
+func serveImage(t *testing.T, url string) image.Image {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+
+	if w.Code != 200 {
+		t.Fatalf("%s: status %d", url, w.Code)
+	}
+
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("%s: %v", url, err)
+	}
+
+	if img.Bounds() != image.Rect(0, 0, 5, 4) {
+		t.Fatalf("%s: image is %v, want 5 x 4", url, img.Bounds())
+	}
+
+	return img
+}
+
+// grayRows draws an image as rows of '#' for white, '.' for black and '+' for anything between
+func grayRows(img image.Image) string {
+	res := ""
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			switch gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y; gray {
+			case 255:
+				res += "#"
+			case 0:
+				res += "."
+			default:
+				res += "+"
+			}
+		}
+		res += "\n"
+	}
+	return res
+}
+
+func TestMaskImage(t *testing.T) {
+	useMasks(t)
+
+	for _, c := range []struct {
+		url  string
+		want string
+	}{
+		// mask_pixels go along rows of px_width first
+		{"/mask_functional/1/1/1000/image.png", ".###.\n.###.\n.###.\n.....\n"},
+		{"/mask/c/e/seg/1/1/101/image.png?mode=binary", ".###.\n.###.\n.###.\n.....\n"},
+		{"/mask_functional/1/1/1000/image.png?mode=outline", ".###.\n.#.#.\n.###.\n.....\n"},
+		{"/mask_functional/1/1/3000/image.png?mode=weighted", ".....\n.....\n.....\n....#\n"},
+		{"/mask_functional/1/1/1000/image.png?mode=weighted", ".+++.\n.+++.\n.+++.\n.....\n"},
+	} {
+		if got := grayRows(serveImage(t, c.url)); got != c.want {
+			t.Errorf("%s:\n%s\nwant\n%s", c.url, got, c.want)
+		}
+	}
+
+	// 1000 spikes a quarter as much as the most active cell of the slice
+	if gray := serveImage(t, "/mask_functional/1/1/1000/image.png?mode=weighted").(*image.Gray).GrayAt(2, 1).Y; gray != 64 {
+		t.Errorf("weighted 1000 is %d, want 64", gray)
+	}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/mask_functional/1/1/1000/image.png?mode=heatmap", status: 400},
+		{url: "/mask_functional/1/1/2000/image.png", status: 404},
+		{url: "/mask/c/e/seg/1/1/102/image.png", status: 404},
+	})
+}
+
+func TestSliceMasksImage(t *testing.T) {
+	useMasks(t)
+
+	img := serveImage(t, "/slice_masks/1/1.png")
+	if img.At(2, 1) != labelColor(1000) || img.At(4, 3) != labelColor(3000) || img.At(0, 0) != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("slice labels %v %v %v", img.At(2, 1), img.At(4, 3), img.At(0, 0))
+	}
+
+	img = serveImage(t, "/slice_masks/1/1.png?mode=outline")
+	if img.At(2, 1) != (color.RGBA{0, 0, 0, 255}) || img.At(1, 1) != labelColor(1000) {
+		t.Errorf("slice outline %v %v", img.At(2, 1), img.At(1, 1))
+	}
+
+	// weighted darkens the label color by the cell's weight
+	img = serveImage(t, "/slice_masks/1/1.png?mode=weighted")
+	full, quarter := labelColor(1000), img.At(2, 1).(color.RGBA)
+	if img.At(4, 3) != labelColor(3000) || quarter.R != uint8(math.Round(float64(full.R)/4)) || quarter.G != uint8(math.Round(float64(full.G)/4)) {
+		t.Errorf("weighted slice labels %v %v, want %v at a quarter", img.At(4, 3), quarter, full)
+	}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/slice_masks/1/1.png?mode=heatmap", status: 400},
+		{url: "/slice_masks/2/1.png", status: 404},
+	})
+}
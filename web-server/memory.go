@@ -0,0 +1,857 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// memoryVoxelSet mirrors a row of the voxel_set table
+type memoryVoxelSet struct {
+	ID       int     `json:"id"`
+	BossID   int     `json:"boss_vset_id"`
+	Size     int     `json:"size"`
+	Keypoint Vector3 `json:"key_point"`
+	BBox     BBox    `json:"bbox"`
+	Channel  int     `json:"channel"`
+}
+
+// memoryNeuron mirrors a row of the neuron table, a nil EmID is a neuron with no functional data
+type memoryNeuron struct {
+	ID       int  `json:"id"`
+	VoxelSet int  `json:"voxel_set"`
+	EmID     *int `json:"em_id"`
+}
+
+// memorySynapse mirrors a row of the synapse table
+type memorySynapse struct {
+	ID       int `json:"id"`
+	VoxelSet int `json:"voxel_set"`
+	Pre      int `json:"pre"`
+	Post     int `json:"post"`
+}
+
+// memoryStructuralStore is a StructuralStore held entirely in memory, used to run the server without MySQL
+type memoryStructuralStore struct {
+	Channels     map[string]int             `json:"channels"`
+	Calibrations map[int]channelCalibration `json:"calibrations"`
+	VoxelSets    []memoryVoxelSet           `json:"voxel_sets"`
+	Neurons      []memoryNeuron             `json:"neurons"`
+	Synapses     []memorySynapse            `json:"synapses"`
+}
+
+func (s *memoryStructuralStore) voxelSet(bossID int, channelID int) (memoryVoxelSet, bool) {
+	for _, vs := range s.VoxelSets {
+		if vs.BossID == bossID && vs.Channel == channelID {
+			return vs, true
+		}
+	}
+	return memoryVoxelSet{}, false
+}
+
+func (s *memoryStructuralStore) voxelSetByID(id int) (memoryVoxelSet, bool) {
+	for _, vs := range s.VoxelSets {
+		if vs.ID == id {
+			return vs, true
+		}
+	}
+	return memoryVoxelSet{}, false
+}
+
+func (s *memoryStructuralStore) neuronByID(id int) (memoryNeuron, bool) {
+	for _, n := range s.Neurons {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return memoryNeuron{}, false
+}
+
+func (s *memoryStructuralStore) neuron(bossID int, channelID int) (memoryNeuron, bool) {
+	vs, ok := s.voxelSet(bossID, channelID)
+	if !ok {
+		return memoryNeuron{}, false
+	}
+
+	for _, n := range s.Neurons {
+		if n.VoxelSet == vs.ID {
+			return n, true
+		}
+	}
+	return memoryNeuron{}, false
+}
+
+func (s *memoryStructuralStore) synapse(bossID int, channelID int) (memorySynapse, bool) {
+	vs, ok := s.voxelSet(bossID, channelID)
+	if !ok {
+		return memorySynapse{}, false
+	}
+
+	for _, syn := range s.Synapses {
+		if syn.VoxelSet == vs.ID {
+			return syn, true
+		}
+	}
+	return memorySynapse{}, false
+}
+
+func (s *memoryStructuralStore) channelName(channelID int) string {
+	for name, id := range s.Channels {
+		if id == channelID {
+			return name
+		}
+	}
+	return ""
+}
+
+// neuronBossID is the boss id of the neuron with the given neuron.id
+func (s *memoryStructuralStore) neuronBossID(neuronID int) (int, bool) {
+	n, ok := s.neuronByID(neuronID)
+	if !ok {
+		return 0, false
+	}
+
+	vs, ok := s.voxelSetByID(n.VoxelSet)
+	return vs.BossID, ok
+}
+
+func (s *memoryStructuralStore) GetChannelFromString(name string) (int, error) {
+	channelID, ok := s.Channels[name]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return channelID, nil
+}
+
+func (s *memoryStructuralStore) GetChannelCalibration(channelID int) (channelCalibration, error) {
+	calibration, ok := s.Calibrations[channelID]
+	if !ok {
+		return defaultCalibration, nil
+	}
+	return calibration, nil
+}
+
+func (s *memoryStructuralStore) GetChannels() ([]ChannelRes, error) {
+	res := make([]ChannelRes, 0, len(s.Channels))
+	byID := make(map[int]int) // channel id to index in res
+
+	for name, channelID := range s.Channels {
+		byID[channelID] = len(res)
+		res = append(res, ChannelRes{ID: channelID, Name: name})
+	}
+
+	neurons := make(map[int]bool)
+	for _, n := range s.Neurons {
+		neurons[n.VoxelSet] = true
+	}
+
+	synapses := make(map[int]bool)
+	for _, syn := range s.Synapses {
+		synapses[syn.VoxelSet] = true
+	}
+
+	for _, vs := range s.VoxelSets {
+		i, ok := byID[vs.Channel]
+		if !ok {
+			continue
+		}
+
+		channel := &res[i]
+		channel.VoxelSets++
+
+		if neurons[vs.ID] {
+			channel.Neurons++
+		}
+
+		if synapses[vs.ID] {
+			channel.Synapses++
+		}
+
+		if channel.Extent == nil {
+			extent := vs.BBox
+			channel.Extent = &extent
+		} else {
+			*channel.Extent = channel.Extent.Union(vs.BBox)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetChannelEmIDs(channelID int) ([]int, error) {
+	seen := make(map[int]bool)
+	res := make([]int, 0)
+
+	for _, n := range s.Neurons {
+		vs, ok := s.voxelSetByID(n.VoxelSet)
+
+		if !ok || vs.Channel != channelID || n.EmID == nil || seen[*n.EmID] {
+			continue
+		}
+
+		seen[*n.EmID] = true
+		res = append(res, *n.EmID)
+	}
+
+	return res, nil
+}
+
+func (s *memoryStructuralStore) IsSynapse(bossID int, channelID int) (bool, error) {
+	_, ok := s.synapse(bossID, channelID)
+	return ok, nil
+}
+
+func (s *memoryStructuralStore) IsNeuron(bossID int, channelID int) (bool, error) {
+	_, ok := s.neuron(bossID, channelID)
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	return true, nil
+}
+
+func (s *memoryStructuralStore) GetBBox(bossID int, channelID int) (BBox, error) {
+	vs, ok := s.voxelSet(bossID, channelID)
+	if !ok {
+		return BBox{}, sql.ErrNoRows
+	}
+	return vs.BBox, nil
+}
+
+func (s *memoryStructuralStore) GetKeypoint(bossID int, channelID int) (Vector3, error) {
+	vs, ok := s.voxelSet(bossID, channelID)
+	if !ok {
+		return Vector3{}, sql.ErrNoRows
+	}
+	return vs.Keypoint, nil
+}
+
+func (s *memoryStructuralStore) EachVoxelSet(channelID int, fn func(bossID int, bbox BBox, keypoint Vector3) error) error {
+	for _, vs := range s.VoxelSets {
+		if vs.Channel != channelID {
+			continue
+		}
+
+		if err := fn(vs.BossID, vs.BBox, vs.Keypoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStructuralStore) GetKeypointsInRegion(channelID int, region BBox) (IdsInRegionRes, error) {
+	var res IdsInRegionRes
+
+	for _, vs := range s.VoxelSets {
+		if vs.Channel == channelID && vs.Keypoint.Inside(region) {
+			res.Ids = append(res.Ids, strconv.Itoa(vs.BossID))
+		}
+	}
+
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetSynapseParents(synapseID int, channelID int) (int, int, error) {
+	syn, ok := s.synapse(synapseID, channelID)
+	if !ok {
+		return 0, 0, sql.ErrNoRows
+	}
+
+	pre, _ := s.neuronBossID(syn.Pre)
+	post, _ := s.neuronBossID(syn.Post)
+
+	return pre, post, nil
+}
+
+func (s *memoryStructuralStore) IsSynapseBatch(bossIDs []int, channelID int) (map[int]bool, error) {
+	res := make(map[int]bool)
+	for _, id := range bossIDs {
+		if _, ok := s.synapse(id, channelID); ok {
+			res[id] = true
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryStructuralStore) IsNeuronBatch(bossIDs []int, channelID int) (map[int]bool, error) {
+	res := make(map[int]bool)
+	for _, id := range bossIDs {
+		if _, ok := s.neuron(id, channelID); ok {
+			res[id] = true
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetBBoxBatch(bossIDs []int, channelID int) (map[int]BBox, error) {
+	res := make(map[int]BBox)
+	for _, id := range bossIDs {
+		if vs, ok := s.voxelSet(id, channelID); ok {
+			res[id] = vs.BBox
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetKeypointBatch(bossIDs []int, channelID int) (map[int]Vector3, error) {
+	res := make(map[int]Vector3)
+	for _, id := range bossIDs {
+		if vs, ok := s.voxelSet(id, channelID); ok {
+			res[id] = vs.Keypoint
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetSynapseParentsBatch(synapseIDs []int, channelID int) (map[int][2]int, error) {
+	res := make(map[int][2]int)
+	for _, id := range synapseIDs {
+		if pre, post, err := s.GetSynapseParents(id, channelID); err == nil {
+			res[id] = [2]int{pre, post}
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetNeuronID(bossID int, channelID int) (int, error) {
+	n, ok := s.neuron(bossID, channelID)
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return n.ID, nil
+}
+
+func (s *memoryStructuralStore) GetNeighbors(neuronID int, pre bool, functionalOnly bool) ([]int, error) {
+	neighbors := make([]int, 0)
+
+	for _, syn := range s.Synapses {
+		from, to := syn.Pre, syn.Post
+		if pre {
+			from, to = to, from
+		}
+
+		if from != neuronID {
+			continue
+		}
+
+		n, ok := s.neuronByID(to)
+		if !ok || (functionalOnly && n.EmID == nil) {
+			continue
+		}
+
+		if vs, ok := s.voxelSetByID(n.VoxelSet); ok {
+			neighbors = append(neighbors, vs.BossID)
+		}
+	}
+
+	return neighbors, nil
+}
+
+func (s *memoryStructuralStore) GetSynapticEdges(neuronIDs []int, pre bool, functionalOnly bool) ([]synapticEdge, error) {
+	wanted := make(map[int]bool)
+	for _, id := range neuronIDs {
+		wanted[id] = true
+	}
+
+	counts := make(map[[2]int]int)
+	sizes := make(map[[2]int]int)
+	order := make([][2]int, 0)
+
+	for _, syn := range s.Synapses {
+		from, partner := syn.Pre, syn.Post
+		if pre {
+			from, partner = partner, from
+		}
+
+		if !wanted[from] {
+			continue
+		}
+
+		n, ok := s.neuronByID(partner)
+		if !ok || (functionalOnly && n.EmID == nil) {
+			continue
+		}
+
+		key := [2]int{syn.Pre, syn.Post}
+		if counts[key] == 0 {
+			order = append(order, key)
+		}
+		counts[key]++
+
+		if vs, ok := s.voxelSetByID(syn.VoxelSet); ok {
+			sizes[key] += vs.Size
+		}
+	}
+
+	res := make([]synapticEdge, 0, len(order))
+
+	for _, key := range order {
+		preBossID, _ := s.neuronBossID(key[0])
+		postBossID, _ := s.neuronBossID(key[1])
+
+		res = append(res, synapticEdge{
+			Pre:        key[0],
+			Post:       key[1],
+			PreBossID:  preBossID,
+			PostBossID: postBossID,
+			Synapses:   counts[key],
+			Size:       sizes[key]})
+	}
+
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetSynapsesBetween(neuronIDs []int) ([]synapseLink, error) {
+	wanted := make(map[int]bool)
+	for _, id := range neuronIDs {
+		wanted[id] = true
+	}
+
+	res := make([]synapseLink, 0)
+
+	for _, syn := range s.Synapses {
+		if !wanted[syn.Pre] || !wanted[syn.Post] {
+			continue
+		}
+
+		if vs, ok := s.voxelSetByID(syn.VoxelSet); ok {
+			res = append(res, synapseLink{BossID: vs.BossID, Pre: syn.Pre, Post: syn.Post})
+		}
+	}
+
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetInducedSynapses(bossIDs []int, channelID int) ([]inducedSynapse, error) {
+	wanted := make(map[int]bool)
+	for _, id := range bossIDs {
+		wanted[id] = true
+	}
+
+	res := make([]inducedSynapse, 0)
+
+	for _, syn := range s.Synapses {
+		pre, ok1 := s.neuronByID(syn.Pre)
+		post, ok2 := s.neuronByID(syn.Post)
+		vs, ok3 := s.voxelSetByID(syn.VoxelSet)
+
+		if !ok1 || !ok2 || !ok3 {
+			continue
+		}
+
+		preVset, ok1 := s.voxelSetByID(pre.VoxelSet)
+		postVset, ok2 := s.voxelSetByID(post.VoxelSet)
+
+		if !ok1 || !ok2 || preVset.Channel != channelID || postVset.Channel != channelID {
+			continue
+		}
+
+		if wanted[preVset.BossID] && wanted[postVset.BossID] {
+			res = append(res, inducedSynapse{Synapse: vs.BossID, Pre: preVset.BossID, Post: postVset.BossID})
+		}
+	}
+
+	return res, nil
+}
+
+func (s *memoryStructuralStore) EachNeuron(channelID int, fn func(graphNode) error) error {
+	for _, n := range s.Neurons {
+		vs, ok := s.voxelSetByID(n.VoxelSet)
+		if !ok || vs.Channel != channelID {
+			continue
+		}
+
+		size := vs.Size
+		err := fn(graphNode{BossID: vs.BossID, EmID: n.EmID, Size: &size, Keypoint: vs.Keypoint, BBox: vs.BBox})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStructuralStore) EachCoregisteredNeuron(channelID int, fn func(graphNode) error) error {
+	return s.EachNeuron(channelID, func(node graphNode) error {
+		if node.EmID == nil {
+			return nil
+		}
+		return fn(node)
+	})
+}
+
+func (s *memoryStructuralStore) EachSynapticEdge(channelID int, fn func(synapticEdge) error) error {
+	neuronIDs := make([]int, 0)
+
+	for _, n := range s.Neurons {
+		if vs, ok := s.voxelSetByID(n.VoxelSet); ok && vs.Channel == channelID {
+			neuronIDs = append(neuronIDs, n.ID)
+		}
+	}
+
+	edges, err := s.GetSynapticEdges(neuronIDs, false, false)
+	if err != nil {
+		return err
+	}
+
+	inChannel := make(map[int]bool)
+	for _, id := range neuronIDs {
+		inChannel[id] = true
+	}
+
+	for _, edge := range edges {
+		if !inChannel[edge.Post] {
+			continue
+		}
+
+		if err := fn(edge); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStructuralStore) EachCoregisteredEdge(channelID int, fn func(synapticEdge) error) error {
+	coregistered := make(map[int]bool)
+	for _, n := range s.Neurons {
+		coregistered[n.ID] = n.EmID != nil
+	}
+
+	return s.EachSynapticEdge(channelID, func(edge synapticEdge) error {
+		if !coregistered[edge.Pre] || !coregistered[edge.Post] {
+			return nil
+		}
+		return fn(edge)
+	})
+}
+
+func (s *memoryStructuralStore) GetNeuronSynapses(neuronID int) ([]neuronSynapse, error) {
+	res := make([]neuronSynapse, 0)
+
+	for _, syn := range s.Synapses {
+		if syn.Pre != neuronID && syn.Post != neuronID {
+			continue
+		}
+
+		vs, ok := s.voxelSetByID(syn.VoxelSet)
+		if !ok {
+			continue
+		}
+
+		res = append(res, neuronSynapse{
+			BossID:  vs.BossID,
+			Pre:     syn.Pre,
+			BBox:    vs.BBox,
+			Channel: s.channelName(vs.Channel)})
+	}
+
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetFunctionalID(bossID int, channelID int) (int, error) {
+	n, ok := s.neuron(bossID, channelID)
+	if !ok || n.EmID == nil {
+		return 0, sql.ErrNoRows
+	}
+	return *n.EmID, nil
+}
+
+func (s *memoryStructuralStore) GetFunctionalIDBatch(bossIDs []int, channelID int) (map[int]int, error) {
+	res := make(map[int]int)
+	for _, id := range bossIDs {
+		if emID, err := s.GetFunctionalID(id, channelID); err == nil {
+			res[id] = emID
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryStructuralStore) GetCoregisteredNeurons(emID int) ([]coregisteredNeuron, error) {
+	res := make([]coregisteredNeuron, 0)
+
+	for _, n := range s.Neurons {
+		vs, ok := s.voxelSetByID(n.VoxelSet)
+		if !ok || n.EmID == nil || *n.EmID != emID {
+			continue
+		}
+
+		res = append(res, coregisteredNeuron{
+			Channel:  s.channelName(vs.Channel),
+			BossID:   vs.BossID,
+			EmID:     emID,
+			Keypoint: vs.Keypoint,
+			BBox:     vs.BBox})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Channel != res[j].Channel {
+			return res[i].Channel < res[j].Channel
+		}
+		return res[i].BossID < res[j].BossID
+	})
+
+	return res, nil
+}
+
+// memoryScan holds the per scan rows of the functional database
+type memoryScan struct {
+	Metadata           ScanMetadataRes `json:"metadata"`
+	PupilR             []byte          `json:"pupil_r"`
+	PupilX             []byte          `json:"pupil_x"`
+	PupilY             []byte          `json:"pupil_y"`
+	Stimulus           []byte          `json:"movie"`
+	StimulusConditions []byte          `json:"conditions"`
+	Treadmill          []byte          `json:"treadmill_speed"`
+	ZOffsets           map[int]int     `json:"z_offsets"` // keyed by slice
+}
+
+// memoryCell holds the per cell rows (trace, __spike and mask) of the functional database
+type memoryCell struct {
+	Scan  int    `json:"scan_idx"`
+	Slice int    `json:"slice"`
+	EmID  int    `json:"em_id"`
+	Trace []byte `json:"trace"`
+	Spike []byte `json:"rate"`
+	Mask  []byte `json:"mask_pixels"`
+}
+
+// memoryFunctionalStore is a FunctionalStore held entirely in memory, used to run the server without MySQL
+type memoryFunctionalStore struct {
+	Scans map[int]memoryScan `json:"scans"`
+	Cells []memoryCell       `json:"cells"`
+}
+
+func (s *memoryFunctionalStore) scan(scanID int) (memoryScan, error) {
+	scan, ok := s.Scans[scanID]
+	if !ok {
+		return scan, sql.ErrNoRows
+	}
+	return scan, nil
+}
+
+func (s *memoryFunctionalStore) cell(scanID int, slice int, cellID int) (memoryCell, error) {
+	for _, c := range s.Cells {
+		if c.Scan == scanID && c.Slice == slice && c.EmID == cellID {
+			return c, nil
+		}
+	}
+	return memoryCell{}, sql.ErrNoRows
+}
+
+func (s *memoryFunctionalStore) GetScans() ([]int, error) {
+	res := make([]int, 0, len(s.Scans))
+
+	for scanID := range s.Scans {
+		res = append(res, scanID)
+	}
+	sort.Ints(res)
+
+	return res, nil
+}
+
+func (s *memoryFunctionalStore) GetScanMetadata(scanID int) (ScanMetadataRes, error) {
+	scan, err := s.scan(scanID)
+	return scan.Metadata, err
+}
+
+func (s *memoryFunctionalStore) GetSliceOffset(scanID int, slice int) (int, error) {
+	scan, err := s.scan(scanID)
+	if err != nil {
+		return 0, err
+	}
+
+	zOffset, ok := scan.ZOffsets[slice]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return zOffset, nil
+}
+
+func (s *memoryFunctionalStore) GetPupilR(scanID int) ([]byte, error) {
+	scan, err := s.scan(scanID)
+	return scan.PupilR, err
+}
+
+func (s *memoryFunctionalStore) GetPupilX(scanID int) ([]byte, error) {
+	scan, err := s.scan(scanID)
+	return scan.PupilX, err
+}
+
+func (s *memoryFunctionalStore) GetPupilY(scanID int) ([]byte, error) {
+	scan, err := s.scan(scanID)
+	return scan.PupilY, err
+}
+
+func (s *memoryFunctionalStore) GetStimulus(scanID int) ([]byte, error) {
+	scan, err := s.scan(scanID)
+	return scan.Stimulus, err
+}
+
+func (s *memoryFunctionalStore) GetStimulusConditions(scanID int) ([]byte, error) {
+	scan, err := s.scan(scanID)
+	return scan.StimulusConditions, err
+}
+
+func (s *memoryFunctionalStore) GetTreadmill(scanID int) ([]byte, error) {
+	scan, err := s.scan(scanID)
+	return scan.Treadmill, err
+}
+
+func (s *memoryFunctionalStore) GetTrace(scanID int, slice int, cellID int) ([]byte, error) {
+	c, err := s.cell(scanID, slice, cellID)
+	return c.Trace, err
+}
+
+func (s *memoryFunctionalStore) GetSpike(scanID int, slice int, cellID int) ([]byte, error) {
+	c, err := s.cell(scanID, slice, cellID)
+	return c.Spike, err
+}
+
+func (s *memoryFunctionalStore) GetMask(scanID int, slice int, cellID int) ([]byte, error) {
+	c, err := s.cell(scanID, slice, cellID)
+	return c.Mask, err
+}
+
+func (s *memoryFunctionalStore) HasMask(scanID int, slice int, cellID int) (bool, error) {
+	_, err := s.cell(scanID, slice, cellID)
+	return err == nil, err
+}
+
+func (s *memoryFunctionalStore) GetSlicesForCell(cellID int) (map[string][]int, error) {
+	res := make(map[string][]int)
+
+	for _, c := range s.Cells {
+		if c.EmID == cellID {
+			res[strconv.Itoa(c.Scan)] = append(res[strconv.Itoa(c.Scan)], c.Slice)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *memoryFunctionalStore) GetSlicesForCells(cellIDs []int) (map[int]map[string][]int, error) {
+	wanted := make(map[int]bool)
+	for _, id := range cellIDs {
+		wanted[id] = true
+	}
+
+	res := make(map[int]map[string][]int)
+	for _, c := range s.Cells {
+		if !wanted[c.EmID] {
+			continue
+		}
+
+		if res[c.EmID] == nil {
+			res[c.EmID] = make(map[string][]int)
+		}
+		res[c.EmID][strconv.Itoa(c.Scan)] = append(res[c.EmID][strconv.Itoa(c.Scan)], c.Slice)
+	}
+
+	return res, nil
+}
+
+func (s *memoryFunctionalStore) GetScansForCells(cellIDs []int) ([]int, error) {
+	wanted := make(map[int]bool)
+	for _, id := range cellIDs {
+		wanted[id] = true
+	}
+
+	scans := make(map[int]bool)
+	for _, c := range s.Cells {
+		if wanted[c.EmID] {
+			scans[c.Scan] = true
+		}
+	}
+
+	res := make([]int, 0, len(scans))
+	for scanIdx := range scans {
+		res = append(res, scanIdx)
+	}
+	sort.Ints(res)
+
+	return res, nil
+}
+
+func (s *memoryFunctionalStore) getCellBatch(scanID int, slice int, cellIDs []int, column func(memoryCell) []byte) ([]cellData, error) {
+	wanted := make(map[int]bool)
+	for _, id := range cellIDs {
+		wanted[id] = true
+	}
+
+	res := make([]cellData, 0)
+
+	for _, c := range s.Cells {
+		if c.Scan == scanID && (slice == allSlices || c.Slice == slice) && wanted[c.EmID] {
+			res = append(res, cellData{Slice: c.Slice, EmID: c.EmID, Data: column(c)})
+		}
+	}
+
+	return res, nil
+}
+
+func (s *memoryFunctionalStore) GetTraceBatch(scanID int, slice int, cellIDs []int) ([]cellData, error) {
+	return s.getCellBatch(scanID, slice, cellIDs, func(c memoryCell) []byte { return c.Trace })
+}
+
+func (s *memoryFunctionalStore) GetSpikeBatch(scanID int, slice int, cellIDs []int) ([]cellData, error) {
+	return s.getCellBatch(scanID, slice, cellIDs, func(c memoryCell) []byte { return c.Spike })
+}
+
+func (s *memoryFunctionalStore) GetSliceMasks(scanID int, slice int) ([]cellData, error) {
+	res := make([]cellData, 0)
+
+	for _, c := range s.Cells {
+		if c.Scan == scanID && (slice == allSlices || c.Slice == slice) {
+			res = append(res, cellData{Slice: c.Slice, EmID: c.EmID, Data: c.Mask})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].EmID != res[j].EmID {
+			return res[i].EmID < res[j].EmID
+		}
+		return res[i].Slice < res[j].Slice
+	})
+
+	return res, nil
+}
+
+func (s *memoryFunctionalStore) GetSliceCells(scanID int, slice int) ([]int, error) {
+	res := make([]int, 0)
+
+	for _, c := range s.Cells {
+		if c.Scan == scanID && (slice == allSlices || c.Slice == slice) {
+			res = append(res, c.EmID)
+		}
+	}
+
+	res = dedupeIds(res)
+	sort.Ints(res)
+
+	return res, nil
+}
+
+// memoryFixtures is the json layout of a fixtures file for the in memory stores
+type memoryFixtures struct {
+	Structural memoryStructuralStore `json:"structural"`
+	Functional memoryFunctionalStore `json:"functional"`
+}
+
+func loadMemoryStores(path string) (*memoryStructuralStore, *memoryFunctionalStore, error) {
+	res := memoryFixtures{}
+
+	file, openError := os.Open(path)
+	if openError != nil {
+		return nil, nil, openError
+	}
+	defer file.Close()
+
+	decodeError := json.NewDecoder(file).Decode(&res)
+
+	return &res.Structural, &res.Functional, decodeError
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ndarray is a numeric array with its data in row major (C) order
+type ndarray struct {
+	Shape []int
+	Data  []float64
+}
+
+func newNdarray(shape ...int) ndarray {
+	n := 1
+	for _, dim := range shape {
+		n *= dim
+	}
+	return ndarray{Shape: shape, Data: make([]float64, n)}
+}
+
+// npy encodes the array as a version 1.0 .npy file of little endian float64
+func (a ndarray) npy() []byte {
+	var buf bytes.Buffer
+	buf.Write(npyHeader("<f8", false, a.Shape))
+	binary.Write(&buf, binary.LittleEndian, a.Data)
+
+	return buf.Bytes()
+}
+
+// npyHeader is everything in a version 1.0 .npy file before the data
+func npyHeader(descr string, fortranOrder bool, shape []int) []byte {
+	dims := make([]string, len(shape))
+	for i, dim := range shape {
+		dims[i] = fmt.Sprint(dim)
+	}
+
+	shapeString := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		shapeString += ","
+	}
+
+	order := "False"
+	if fortranOrder {
+		order = "True"
+	}
+
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': %s, 'shape': (%s), }", descr, order, shapeString)
+
+	// magic, version and header length take 10 bytes, the header is padded so data starts on a 64 byte boundary
+	padding := 64 - (10+len(header)+1)%64
+	header += strings.Repeat(" ", padding%64) + "\n"
+
+	var buf bytes.Buffer
+	buf.WriteString("\x93NUMPY")
+	buf.Write([]byte{1, 0})
+	binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+
+	return buf.Bytes()
+}
+
+// writeNpz writes arrays as an uncompressed .npz, numpy.load gives them back keyed by name
+func writeNpz(w io.Writer, names []string, arrays []ndarray) error {
+	z := zip.NewWriter(w)
+
+	for i, name := range names {
+		f, err := z.CreateHeader(&zip.FileHeader{Name: name + ".npy", Method: zip.Store})
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(arrays[i].npy()); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+// matlab class id of double, as used by the datajoint mYm blob format
+const mxDoubleClass = 6
+
+// rowMajorOffsets gives, for each element in fortran order, where it sits in row major order
+func rowMajorOffsets(shape []int, n int) []int {
+	res := make([]int, n)
+
+	index := make([]int, len(shape))
+	for i := range res {
+		offset := 0
+		for d := range shape {
+			offset = offset*shape[d] + index[d]
+		}
+		res[i] = offset
+
+		for d := range index {
+			index[d]++
+			if index[d] < shape[d] {
+				break
+			}
+			index[d] = 0
+		}
+	}
+
+	return res
+}
+
+// columnMajor reorders row major data into fortran order, as matlab and datajoint store it
+func (a ndarray) columnMajor() []float64 {
+	res := make([]float64, len(a.Data))
+
+	for i, offset := range rowMajorOffsets(a.Shape, len(a.Data)) {
+		res[i] = a.Data[offset]
+	}
+
+	return res
+}
+
+// fromColumnMajor builds an array from data stored in fortran order
+func fromColumnMajor(shape []int, data []float64) ndarray {
+	res := ndarray{Shape: shape, Data: make([]float64, len(data))}
+
+	for i, offset := range rowMajorOffsets(shape, len(data)) {
+		res.Data[offset] = data[i]
+	}
+
+	return res
+}
+
+// djBlob encodes the array as an uncompressed datajoint mYm blob of doubles, readable by datajoint.blob.unpack
+func (a ndarray) djBlob() []byte {
+	return append([]byte("mYm\x00"), a.djArray()...)
+}
+
+// djArray is the array part of a blob, which is also how struct fields are stored
+func (a ndarray) djArray() []byte {
+	shape := a.Shape
+	// matlab arrays are at least 2d
+	for len(shape) < 2 {
+		shape = append([]int{1}, shape...)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("A")
+	binary.Write(&buf, binary.LittleEndian, uint64(len(shape)))
+	for _, dim := range shape {
+		binary.Write(&buf, binary.LittleEndian, uint64(dim))
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(mxDoubleClass))
+	binary.Write(&buf, binary.LittleEndian, uint32(0)) // not complex
+	binary.Write(&buf, binary.LittleEndian, ndarray{Shape: shape, Data: a.Data}.columnMajor())
+
+	return buf.Bytes()
+}
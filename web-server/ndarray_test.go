@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestNpyHeader(t *testing.T) {
+	for _, c := range []struct {
+		shape []int
+		want  string
+	}{
+		{[]int{3}, "(3,)"},
+		{[]int{2, 5}, "(2, 5)"},
+		{[]int{}, "()"},
+	} {
+		header := npyHeader("<f8", false, c.shape)
+
+		if !bytes.HasPrefix(header, []byte("\x93NUMPY\x01\x00")) {
+			t.Errorf("%v: bad magic %q", c.shape, header[:8])
+		}
+
+		if len(header)%64 != 0 || header[len(header)-1] != '\n' {
+			t.Errorf("%v: header is %d bytes, want a multiple of 64 ending in a newline", c.shape, len(header))
+		}
+
+		if int(binary.LittleEndian.Uint16(header[8:10])) != len(header)-10 {
+			t.Errorf("%v: header length field doesn't match", c.shape)
+		}
+
+		if !bytes.Contains(header, []byte("'shape': "+c.want)) {
+			t.Errorf("%v: %q has no shape %s", c.shape, header, c.want)
+		}
+	}
+
+	if !bytes.Contains(npyHeader("|u1", true, []int{1}), []byte("'descr': '|u1', 'fortran_order': True")) {
+		t.Error("descr and fortran order missing")
+	}
+}
+
+func TestNpy(t *testing.T) {
+	a := ndarray{Shape: []int{2, 2}, Data: []float64{1, 2, 3, math.Inf(1)}}
+	npy := a.npy()
+	header := npyHeader("<f8", false, a.Shape)
+
+	data := make([]float64, 4)
+	binary.Read(bytes.NewReader(npy[len(header):]), binary.LittleEndian, data)
+
+	for i := range data {
+		if data[i] != a.Data[i] {
+			t.Fatalf("data %v, want %v", data, a.Data)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// maxNearest caps k for /nearest/
+const maxNearest = 1000
+
+var errBadK = errors.New("k should be an integer from 1 to 1000")
+var errBadRadius = errors.New("radius should be a non negative number of nm")
+
+// neighbor is a voxel set near the query point, keypoint in the requested units
+type neighbor struct {
+	ID         int        `json:"id"`
+	Keypoint   [3]float64 `json:"keypoint"`
+	Distance   float64    `json:"distance"`    // in voxels at the requested resolution
+	DistanceNm float64    `json:"distance_nm"` // what neighbors are ordered by
+}
+
+// NearestRes boop
+type NearestRes struct {
+	Neighbors []neighbor `json:"neighbors"`
+}
+
+// parseNearest reads ?k= (default 10) and ?radius= in nm (default unbounded)
+func parseNearest(r *http.Request) (int, float64, error) {
+	k, radius := 10, math.Inf(1)
+
+	queryValues := r.URL.Query()
+
+	if s := queryValues.Get("k"); s != "" {
+		var err error
+		k, err = strconv.Atoi(s)
+
+		if err != nil || k < 1 || k > maxNearest {
+			return 0, 0, errBadK
+		}
+	}
+
+	if s := queryValues.Get("radius"); s != "" {
+		var err error
+		radius, err = strconv.ParseFloat(s, 64)
+
+		if err != nil || radius < 0 || math.IsNaN(radius) {
+			return 0, 0, errBadRadius
+		}
+	}
+
+	return k, radius, nil
+}
+
+// axisGap is how far v is outside [min, max]
+func axisGap(v, min, max int) float64 {
+	if v < min {
+		return float64(min - v)
+	} else if v > max {
+		return float64(v - max)
+	}
+	return 0
+}
+
+// getNearest is the k voxel sets of the channel with keypoints closest to p, p at full resolution
+func getNearest(channelID int, p Vector3, coords coordinates, k int, radius float64) (NearestRes, error) {
+	res := NearestRes{Neighbors: make([]neighbor, 0)}
+
+	index, err := getSpatialIndex(channelID)
+	if err != nil {
+		return res, err
+	}
+
+	size := coords.calibration.VoxelSize
+	scale := coords.calibration.scale(coords.resolution)
+
+	index.keypoints.nearest(k, radius, func(b BBox) float64 {
+		dx := axisGap(p.X, b.MIN.X, b.MAX.X) * size.X
+		dy := axisGap(p.Y, b.MIN.Y, b.MAX.Y) * size.Y
+		dz := axisGap(p.Z, b.MIN.Z, b.MAX.Z) * size.Z
+		return math.Sqrt(dx*dx + dy*dy + dz*dz)
+	}, func(leaf *rtreeNode, d float64) {
+		keypoint := leaf.BBox.MIN
+
+		dx := float64(keypoint.X-p.X) / float64(scale.X)
+		dy := float64(keypoint.Y-p.Y) / float64(scale.Y)
+		dz := float64(keypoint.Z-p.Z) / float64(scale.Z)
+
+		res.Neighbors = append(res.Neighbors, neighbor{
+			ID:         leaf.ID,
+			Keypoint:   coords.point(keypoint),
+			Distance:   math.Sqrt(dx*dx + dy*dy + dz*dz),
+			DistanceNm: d})
+	})
+
+	return res, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNearestHandler(t *testing.T) {
+	useTestStores(t)
+
+	// keypoints of channel 1 are at i*10, i*10, i for boss id 100+i, voxels are 4x4x40 nm
+	for _, c := range []struct {
+		url       string
+		ids       []int
+		keypoint  [3]float64 // of the nearest
+		distance  float64    // of the nearest, in voxels at the resolution
+		nearestNm float64
+	}{
+		{"/nearest/c/e/seg/0/32/32/3/?k=3", []int{103, 104, 102}, [3]float64{30, 30, 3}, math.Sqrt(8), math.Sqrt(128)},
+		// 102 is 78.8 nm away
+		{"/nearest/c/e/seg/0/32/32/3/?radius=70", []int{103, 104}, [3]float64{30, 30, 3}, math.Sqrt(8), math.Sqrt(128)},
+		{"/nearest/c/e/seg/0/32/32/3/?radius=0", []int{}, [3]float64{}, 0, 0},
+		{"/nearest/c/e/seg/0/0/0/0/", []int{101, 102, 103, 104, 105}, [3]float64{10, 10, 1}, math.Sqrt(201), 40 * math.Sqrt(3)},
+		// mip 1 halves x and y
+		{"/nearest/c/e/seg/1/16/16/3/?k=1", []int{103}, [3]float64{15, 15, 3}, math.Sqrt(2), math.Sqrt(128)},
+		{"/nearest/c/e/seg/0/128/128/120/?units=nm&k=1", []int{103}, [3]float64{120, 120, 120}, math.Sqrt(8), math.Sqrt(128)},
+	} {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, httptest.NewRequest("GET", c.url, nil))
+
+		if w.Code != 200 {
+			t.Errorf("%s: status %d", c.url, w.Code)
+			continue
+		}
+
+		var res NearestRes
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %v", c.url, err)
+		}
+
+		ids := make([]int, 0)
+		for i, n := range res.Neighbors {
+			ids = append(ids, n.ID)
+			if i > 0 && n.DistanceNm < res.Neighbors[i-1].DistanceNm {
+				t.Errorf("%s: %d is nearer than %d before it", c.url, n.ID, res.Neighbors[i-1].ID)
+			}
+		}
+
+		if !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("%s: ids %v, want %v", c.url, ids, c.ids)
+		}
+
+		if len(res.Neighbors) == 0 {
+			continue
+		}
+
+		nearest := res.Neighbors[0]
+		if nearest.Keypoint != c.keypoint || math.Abs(nearest.Distance-c.distance) > 1e-9 || math.Abs(nearest.DistanceNm-c.nearestNm) > 1e-9 {
+			t.Errorf("%s: nearest %+v, want keypoint %v at %v voxels and %v nm", c.url, nearest, c.keypoint, c.distance, c.nearestNm)
+		}
+	}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/nearest/c/e/seg/0/0/0/0/?k=0", status: 400},
+		{url: "/nearest/c/e/seg/0/0/0/0/?k=1001", status: 400},
+		{url: "/nearest/c/e/seg/0/0/0/0/?radius=-1", status: 400},
+		{url: "/nearest/c/e/seg/0/0/0/0/?units=mm", status: 400},
+		{url: "/nearest/c/e/seg/0/0.5/0/0/", status: 400},
+		{url: "/nearest/c/e/seg/x/0/0/0/", status: 400},
+		{url: "/nearest/c/e/none/0/0/0/0/", status: 404},
+	})
+}
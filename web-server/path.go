@@ -0,0 +1,179 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+)
+
+// stop searching for a path after expanding this many neurons
+const maxPathExpanded = 5000
+
+// neurons expanded per round of edge queries
+const pathBatchSize = 500
+
+var errNoPath = errors.New("no synaptic path between neurons")
+var errPathSearchLimit = fmt.Errorf("gave up looking for a path after expanding %d neurons", maxPathExpanded)
+var errBadWeight = errors.New("weight should be hops, synapses or size")
+
+type pathHop struct {
+	Pre      int   `json:"pre"`
+	Post     int   `json:"post"`
+	Synapses []int `json:"synapses"`
+}
+
+// PathRes boop
+type PathRes struct {
+	Hops []pathHop `json:"hops"`
+	// Length is the summed edge weight along the path
+	Length float64 `json:"length"`
+}
+
+// stronger connections are shorter, so synapse and size weights are inverted
+func edgeWeight(edge synapticEdge, weight string) float64 {
+	switch weight {
+	case "synapses":
+		return 1 / float64(Max2(edge.Synapses, 1))
+	case "size":
+		return 1 / float64(Max2(edge.Size, 1))
+	}
+	return 1
+}
+
+type pathQueueItem struct {
+	neuronID int
+	distance float64
+}
+
+type pathQueue []pathQueueItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathQueueItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// getShortestPath searches the synapse graph between two neuron.ids, closest neurons first
+// when undirected, synapses can be followed from post to pre as well
+// the closest pathBatchSize neurons are expanded together with one edge query each way, so a neuron can be
+// expanded again when a later batch finds it a shorter route, and the search ends once nothing queued beats the target
+func getShortestPath(from int, to int, directed bool, weight string) (PathRes, error) {
+	res := PathRes{Hops: make([]pathHop, 0)}
+
+	if weight != "hops" && weight != "synapses" && weight != "size" {
+		return res, errBadWeight
+	}
+
+	distances := map[int]float64{from: 0}
+	previous := make(map[int]synapticEdge)
+	expanded := 0
+
+	queue := &pathQueue{{from, 0}}
+
+	relax := func(edge synapticEdge, current int, next int) {
+		distance := distances[current] + edgeWeight(edge, weight)
+
+		if known, ok := distances[next]; !ok || distance < known {
+			distances[next] = distance
+			previous[next] = edge
+			heap.Push(queue, pathQueueItem{next, distance})
+		}
+	}
+
+	for queue.Len() > 0 {
+		batch := make([]int, 0, pathBatchSize)
+
+		for queue.Len() > 0 && len(batch) < pathBatchSize {
+			item := heap.Pop(queue).(pathQueueItem)
+
+			if item.distance > distances[item.neuronID] {
+				continue // a shorter route was found since this was queued
+			}
+
+			if best, ok := distances[to]; ok && item.distance >= best {
+				*queue = (*queue)[:0] // everything left is at least as far
+				break
+			}
+
+			if item.neuronID != to {
+				batch = append(batch, item.neuronID)
+			}
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		expanded += len(batch)
+
+		if expanded > maxPathExpanded {
+			return res, errPathSearchLimit
+		}
+
+		outputs, err := structuralStore.GetSynapticEdges(batch, false, false)
+		if err != nil {
+			return res, err
+		}
+
+		for _, edge := range outputs {
+			relax(edge, edge.Pre, edge.Post)
+		}
+
+		if !directed {
+			inputs, err := structuralStore.GetSynapticEdges(batch, true, false)
+			if err != nil {
+				return res, err
+			}
+
+			for _, edge := range inputs {
+				relax(edge, edge.Post, edge.Pre)
+			}
+		}
+	}
+
+	if _, ok := distances[to]; !ok {
+		return res, errNoPath
+	}
+
+	// walk back from the target to recover the edges used
+	path := make([]synapticEdge, 0)
+	neurons := []int{to}
+
+	for current := to; current != from; {
+		edge := previous[current]
+		path = append([]synapticEdge{edge}, path...)
+
+		if edge.Post == current {
+			current = edge.Pre
+		} else {
+			current = edge.Post
+		}
+		neurons = append(neurons, current)
+	}
+
+	links, err := structuralStore.GetSynapsesBetween(neurons)
+	if err != nil {
+		return res, err
+	}
+
+	for _, edge := range path {
+		hop := pathHop{Pre: edge.PreBossID, Post: edge.PostBossID, Synapses: make([]int, 0)}
+
+		for _, link := range links {
+			if link.Pre == edge.Pre && link.Post == edge.Post {
+				hop.Synapses = append(hop.Synapses, link.BossID)
+			}
+		}
+
+		res.Hops = append(res.Hops, hop)
+	}
+
+	res.Length = distances[to]
+
+	return res, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+// useGraph replaces the neurons of c/e/seg with 1 to n, boss ids 101 onwards, joined by a synapse per edge
+// synapses get boss ids from 1001 on and are in no channel
+func useGraph(t *testing.T, n int, edges [][2]int) *memoryStructuralStore {
+	structural, _ := useTestStores(t)
+	structural.VoxelSets, structural.Neurons, structural.Synapses = nil, nil, nil
+
+	for i := 1; i <= n; i++ {
+		structural.VoxelSets = append(structural.VoxelSets, memoryVoxelSet{ID: i, BossID: 100 + i, Size: 1, Channel: 1})
+		structural.Neurons = append(structural.Neurons, memoryNeuron{ID: i, VoxelSet: i})
+	}
+
+	for j, edge := range edges {
+		id := 1000 + j + 1
+		structural.VoxelSets = append(structural.VoxelSets, memoryVoxelSet{ID: id, BossID: id, Size: 1})
+		structural.Synapses = append(structural.Synapses, memorySynapse{ID: id, VoxelSet: id, Pre: edge[0], Post: edge[1]})
+	}
+
+	return structural
+}
+
+func TestShortestPathWeights(t *testing.T) {
+	// 1 reaches 4 through 2 in two hops of one synapse, or through 3 and 5 in three hops of two synapses
+	useGraph(t, 5, [][2]int{{1, 2}, {2, 4}, {1, 3}, {1, 3}, {3, 5}, {3, 5}, {5, 4}, {5, 4}})
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/path/c/e/seg/101/104/", status: 200,
+			want: `{"hops":[{"pre":101,"post":102,"synapses":[1001]},{"pre":102,"post":104,"synapses":[1002]}],"length":2}`},
+		{url: "/path/c/e/seg/101/104/?weight=synapses", status: 200,
+			want: `{"hops":[{"pre":101,"post":103,"synapses":[1003,1004]},{"pre":103,"post":105,"synapses":[1005,1006]},{"pre":105,"post":104,"synapses":[1007,1008]}],"length":1.5}`},
+		// size sums the synapse sizes of an edge, all 1 here
+		{url: "/path/c/e/seg/101/104/?weight=size", status: 200,
+			want: `{"hops":[{"pre":101,"post":103,"synapses":[1003,1004]},{"pre":103,"post":105,"synapses":[1005,1006]},{"pre":105,"post":104,"synapses":[1007,1008]}],"length":1.5}`},
+		{url: "/path/c/e/seg/101/101/", status: 200, want: `{"hops":[],"length":0}`},
+
+		// against the synapses only when undirected
+		{url: "/path/c/e/seg/104/101/", status: 404},
+		{url: "/path/c/e/seg/104/101/?directed=false", status: 200,
+			want: `{"hops":[{"pre":102,"post":104,"synapses":[1002]},{"pre":101,"post":102,"synapses":[1001]}],"length":2}`},
+
+		{url: "/path/c/e/seg/101/999/", status: 404},
+		{url: "/path/c/e/seg/x/104/", status: 400},
+	})
+}
+
+func TestShortestPathBatches(t *testing.T) {
+	// 1 fans out to more neurons than one batch expands, and only the last of them leads on to the target
+	leaves := pathBatchSize + 100
+	target := leaves + 2
+	edges := make([][2]int, 0)
+
+	for i := 2; i < leaves+2; i++ {
+		edges = append(edges, [2]int{1, i})
+	}
+	edges = append(edges, [2]int{leaves + 1, target})
+
+	useGraph(t, target, edges)
+
+	res, err := getShortestPath(1, target, true, "hops")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Hops) != 2 || res.Hops[0].Post != 100+leaves+1 || res.Hops[1].Post != 100+target || res.Length != 2 {
+		t.Errorf("path %+v", res)
+	}
+}
+
+func TestShortestPathLimit(t *testing.T) {
+	// 1 reaches more neurons than the search may expand, none of them the target
+	edges := make([][2]int, 0)
+	for i := 3; i <= maxPathExpanded+3; i++ {
+		edges = append(edges, [2]int{1, i})
+	}
+
+	useGraph(t, maxPathExpanded+3, edges)
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/path/c/e/seg/101/102/", status: 422},
+	})
+}
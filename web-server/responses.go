@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// the conditions blob has no fixed layout in the database, these are the two read here:
+// a numeric array with one condition per imaging frame, NaN where nothing is shown, each run of one value a trial
+// or a struct array with one element per trial and fields onset (0 based frame), and condition or direction
+// tuning also needs the angle of each trial, which only the direction or orientation field of a struct array gives
+var errUnsupportedConditions = errors.New("stimulus conditions are neither per frame values nor trials with onset and condition fields, or give no direction to tune to")
+var errAmbiguousTrials = errors.New("per frame stimulus conditions show trials back to back with runs that aren't a whole number of the shortest trial, so repeated trials can't be told apart")
+
+// maxResponseSeconds bounds the window cut out around each onset, so a huge pre or post can't allocate without limit
+const maxResponseSeconds = 60
+
+var errBadResponseWindow = fmt.Errorf("pre and post should be non negative seconds adding up to at most %d", maxResponseSeconds)
+var errBadSignal = errors.New("signal should be trace or spike")
+
+// stimulusTrial is one presentation, Stop is the frame after it, or -1 when only onsets are known
+type stimulusTrial struct {
+	Condition float64
+	Onset     int
+	Stop      int
+	// degrees, nil when the conditions don't say
+	Direction *float64
+}
+
+// parseConditions reads the trials out of a conditions blob, in onset order
+func parseConditions(blob []byte) ([]stimulusTrial, error) {
+	value, err := decodeBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []stimulusTrial
+
+	switch v := value.(type) {
+	case blobArray:
+		res, err = frameConditionTrials(v.ndarray().Data)
+	case blobStruct:
+		res, err = structConditionTrials(v)
+	default:
+		err = errUnsupportedConditions
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Onset < res[j].Onset })
+
+	return res, err
+}
+
+// frameConditionTrials splits per frame conditions into runs at blank frames and changes of condition
+// a trial of the same condition shown right after another looks like one longer run, so when any two runs touch
+// every run is taken to be a whole number of trials as long as the shortest, and anything else is refused
+// trials of one condition with no blank between them and nothing else shown back to back still come out as one
+func frameConditionTrials(frames []float64) ([]stimulusTrial, error) {
+	runs := make([]stimulusTrial, 0)
+	backToBack := false
+
+	for i, condition := range frames {
+		if math.IsNaN(condition) {
+			continue
+		}
+
+		if i > 0 && frames[i-1] == condition {
+			runs[len(runs)-1].Stop = i + 1
+			continue
+		}
+
+		if len(runs) > 0 && runs[len(runs)-1].Stop == i {
+			backToBack = true
+		}
+		runs = append(runs, stimulusTrial{Condition: condition, Onset: i, Stop: i + 1})
+	}
+
+	if !backToBack {
+		return runs, nil
+	}
+
+	length := shortestTrial(runs)
+	res := make([]stimulusTrial, 0, len(runs))
+
+	for _, run := range runs {
+		if (run.Stop-run.Onset)%length != 0 {
+			return nil, errAmbiguousTrials
+		}
+
+		for onset := run.Onset; onset < run.Stop; onset += length {
+			res = append(res, stimulusTrial{Condition: run.Condition, Onset: onset, Stop: onset + length})
+		}
+	}
+
+	return res, nil
+}
+
+// scalarField is the number in a struct field, if it holds one
+func scalarField(s blobStruct, element int, names ...string) (float64, bool) {
+	for _, name := range names {
+		for j, field := range s.Fields {
+			if field != name {
+				continue
+			}
+
+			array, ok := s.Values[element][j].(blobArray)
+			if !ok || array.len() != 1 {
+				return 0, false
+			}
+			return array.at(0), true
+		}
+	}
+	return 0, false
+}
+
+func structConditionTrials(s blobStruct) ([]stimulusTrial, error) {
+	res := make([]stimulusTrial, 0, len(s.Values))
+
+	for i := range s.Values {
+		onset, ok1 := scalarField(s, i, "onset")
+		condition, ok2 := scalarField(s, i, "condition", "direction")
+
+		if !ok1 || !ok2 {
+			return nil, errUnsupportedConditions
+		}
+
+		trial := stimulusTrial{Condition: condition, Onset: int(onset), Stop: -1}
+		if direction, ok := scalarField(s, i, "direction", "orientation"); ok {
+			trial.Direction = &direction
+		}
+		if stop, ok := scalarField(s, i, "offset", "stop"); ok {
+			trial.Stop = int(stop)
+		}
+
+		res = append(res, trial)
+	}
+
+	return res, nil
+}
+
+type conditionResponses struct {
+	Condition jsonFloat `json:"condition"`
+	Onsets    []int     `json:"onsets"`
+	// Responses[trial][frame], frame 0 being PreFrames before the onset
+	Responses [][]jsonFloat `json:"responses"`
+}
+
+// ResponsesRes boop
+type ResponsesRes struct {
+	Fps        float64              `json:"fps"`
+	PreFrames  int                  `json:"pre_frames"`
+	PostFrames int                  `json:"post_frames"`
+	Conditions []conditionResponses `json:"conditions"`
+}
+
+// alignResponses cuts pre frames before to post frames after each onset out of the signal, NaN past either end
+func alignResponses(signal []float64, trials []stimulusTrial, pre int, post int) []conditionResponses {
+	res := make([]conditionResponses, 0)
+	index := make(map[float64]int)
+
+	for _, trial := range trials {
+		i, ok := index[trial.Condition]
+		if !ok {
+			i = len(res)
+			index[trial.Condition] = i
+			res = append(res, conditionResponses{Condition: jsonFloat(trial.Condition), Onsets: []int{}, Responses: [][]jsonFloat{}})
+		}
+
+		row := make([]jsonFloat, pre+post)
+		for j := range row {
+			frame := trial.Onset - pre + j
+			if frame >= 0 && frame < len(signal) {
+				row[j] = jsonFloat(signal[frame])
+			} else {
+				row[j] = jsonFloat(math.NaN())
+			}
+		}
+
+		res[i].Onsets = append(res[i].Onsets, trial.Onset)
+		res[i].Responses = append(res[i].Responses, row)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Condition < res[j].Condition })
+
+	return res
+}
+
+// cellSignal picks the trace or spike getter from ?signal=, trace by default
+func cellSignal(signal string) (cellDataGetter, error) {
+	switch signal {
+	case "", "trace":
+		return functionalStore.GetTrace, nil
+	case "spike":
+		return functionalStore.GetSpike, nil
+	}
+	return nil, errBadSignal
+}
+
+// parseSeconds reads a non negative number of seconds, or nil when it's left out
+func parseSeconds(r *http.Request, name string) (*float64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	res, err := strconv.ParseFloat(value, 64)
+	if err != nil || res < 0 || math.IsInf(res, 0) {
+		return nil, errBadResponseWindow
+	}
+	return &res, nil
+}
+
+// parseResponseWindow reads ?pre= and ?post=, nil for either that's left out
+func parseResponseWindow(r *http.Request) (*float64, *float64, error) {
+	pre, err := parseSeconds(r, "pre")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	post, err := parseSeconds(r, "post")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var total float64
+	for _, seconds := range []*float64{pre, post} {
+		if seconds != nil {
+			total += *seconds
+		}
+	}
+
+	if total > maxResponseSeconds {
+		return nil, nil, errBadResponseWindow
+	}
+
+	return pre, post, nil
+}
+
+// getResponses aligns a cell's signal to every stimulus trial of its scan
+// pre defaults to nothing and post to the shortest trial, so every row covers a whole presentation
+func getResponses(scanID int, slice int, emID int, getter cellDataGetter, pre *float64, post *float64) (ResponsesRes, error) {
+	res := ResponsesRes{Conditions: make([]conditionResponses, 0)}
+
+	metadata, err := functionalStore.GetScanMetadata(scanID)
+	if err != nil {
+		return res, err
+	}
+
+	if metadata.Fps <= 0 {
+		return res, errNoFps
+	}
+	res.Fps = metadata.Fps
+
+	conditions, err := functionalStore.GetStimulusConditions(scanID)
+	if err != nil {
+		return res, err
+	}
+
+	trials, err := parseConditions(conditions)
+	if err != nil {
+		return res, err
+	}
+
+	blob, err := getter(scanID, slice, emID)
+	if err != nil {
+		return res, err
+	}
+
+	signal, err := decodeArray(blob)
+	if err != nil {
+		return res, err
+	}
+
+	if pre != nil {
+		res.PreFrames = int(math.Round(*pre * res.Fps))
+	}
+
+	if post != nil {
+		res.PostFrames = int(math.Round(*post * res.Fps))
+	} else {
+		res.PostFrames = shortestTrial(trials)
+	}
+
+	res.Conditions = alignResponses(signal.ndarray().Data, trials, res.PreFrames, res.PostFrames)
+
+	return res, nil
+}
+
+// shortestTrial is the frames in the shortest trial, 0 if no trial has a known end
+func shortestTrial(trials []stimulusTrial) int {
+	res := 0
+
+	for _, trial := range trials {
+		if trial.Stop != -1 && (res == 0 || trial.Stop-trial.Onset < res) {
+			res = trial.Stop - trial.Onset
+		}
+	}
+
+	return res
+}
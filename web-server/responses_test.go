@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResponsesHandler(t *testing.T) {
+	_, functional := useTestStores(t)
+
+	// one trial of condition 0 over the first two of four frames
+	scan := functional.Scans[1]
+	scan.StimulusConditions = ndarray{Shape: []int{1, 4}, Data: []float64{0, 0, math.NaN(), math.NaN()}}.djBlob()
+	functional.Scans[1] = scan
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/responses_functional/1/1/1000/", status: 200,
+			want: `{"fps":2,"pre_frames":0,"post_frames":2,"conditions":[{"condition":0,"onsets":[0],"responses":[[1,2]]}]}`},
+		// frames before the scan starts are null
+		{url: "/responses_functional/1/1/1000/?pre=0.5&post=1.5", status: 200,
+			want: `{"fps":2,"pre_frames":1,"post_frames":3,"conditions":[{"condition":0,"onsets":[0],"responses":[[null,1,2,3]]}]}`},
+		{url: "/responses/c/e/seg/1/1/101/?post=0.5", status: 200,
+			want: `{"fps":2,"pre_frames":0,"post_frames":1,"conditions":[{"condition":0,"onsets":[0],"responses":[[1]]}]}`},
+
+		{url: "/responses_functional/1/1/1000/?pre=30&post=30", status: 200},
+		{url: "/responses_functional/1/1/1000/?pre=30&post=30.5", status: 400},
+		{url: "/responses_functional/1/1/1000/?post=1e300", status: 400},
+		{url: "/responses_functional/1/1/1000/?pre=-1", status: 400},
+		{url: "/responses_functional/1/1/1000/?post=x", status: 400},
+		{url: "/responses_functional/1/1/1000/?signal=x", status: 400},
+		{url: "/responses_functional/1/1/9999/", status: 404},
+	})
+}
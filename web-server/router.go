@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image/png"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -128,8 +132,9 @@ type boolRes struct {
 	Result bool `json:"result"`
 }
 
+// KeypointRes whole numbers encode the same as ints, so voxel keypoints look as they always have
 type KeypointRes struct {
-	Keypoint [3]int `json:"keypoint"`
+	Keypoint [3]float64 `json:"keypoint"`
 }
 
 type parentRes struct {
@@ -162,18 +167,40 @@ func pupilHandler(pg pupilGetter) httprouter.Handle {
 			return
 		}
 
+		format, formatErr := responseFormat(r)
+
+		if formatErr != nil {
+			httpError(w, http.StatusBadRequest, formatErr)
+			return
+		}
+
 		pupilData, err2 := pg(scanID)
 
 		if err2 != nil {
 			internalError(w, err2)
+			return
+		}
+
+		window, windowErr := parseStreamWindow(r, scanID, pupilData)
+
+		if windowErr == errBadWindow {
+			httpError(w, http.StatusBadRequest, windowErr)
+		} else if windowErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, windowErr)
+		} else if windowErr == errUnsupportedBlob {
+			httpError(w, http.StatusNotAcceptable, windowErr)
+		} else if windowErr == errNoFps {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+		} else if windowErr != nil {
+			internalError(w, windowErr)
 		} else {
-			w.Write(pupilData)
+			writeBlobWindow(w, pupilData, format, window)
 		}
 	}
 }
 
-// gets functional cell data given a boss id and channel
-func functionalCellHandler(cdg cellDataGetter) httprouter.Handle {
+// gets functional cell data given a boss id and channel, timeSeries data (traces and spikes, not masks) can be cut to a frame window
+func functionalCellHandler(cdg cellDataGetter, timeSeries bool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Content-Type", "application/octet-stream")
 
@@ -193,6 +220,13 @@ func functionalCellHandler(cdg cellDataGetter) httprouter.Handle {
 			return
 		}
 
+		format, formatErr := responseFormat(r)
+
+		if formatErr != nil {
+			httpError(w, http.StatusBadRequest, formatErr)
+			return
+		}
+
 		channelID, chanErr := getChannel(ps)
 
 		if chanErr == sql.ErrNoRows {
@@ -203,7 +237,7 @@ func functionalCellHandler(cdg cellDataGetter) httprouter.Handle {
 			return
 		}
 
-		funcID, lookupErr := getFunctionalID(cellID, channelID)
+		funcID, lookupErr := structuralStore.GetFunctionalID(cellID, channelID)
 
 		if lookupErr == sql.ErrNoRows {
 			httpError(w, http.StatusNotFound, lookupErr)
@@ -213,18 +247,39 @@ func functionalCellHandler(cdg cellDataGetter) httprouter.Handle {
 			return
 		}
 
+		var window *frameWindow
+
+		if timeSeries {
+			var windowErr error
+			window, windowErr = parseFrameWindow(r, scanID)
+
+			if windowErr == errBadWindow {
+				httpError(w, http.StatusBadRequest, windowErr)
+				return
+			} else if windowErr == sql.ErrNoRows {
+				httpError(w, http.StatusNotFound, windowErr)
+				return
+			} else if windowErr == errNoFps {
+				httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+				return
+			} else if windowErr != nil {
+				internalError(w, windowErr)
+				return
+			}
+		}
+
 		cellData, err4 := cdg(scanID, sliceID, funcID)
 
 		if err4 != nil {
 			internalError(w, err4)
 		} else {
-			w.Write(cellData)
+			writeBlobWindow(w, cellData, format, window)
 		}
 	}
 }
 
 // used to get functional data if you already have the "functional id" (no channel or boss id needed)
-func trulyFunctionalCellHandler(cdg cellDataGetter) httprouter.Handle {
+func trulyFunctionalCellHandler(cdg cellDataGetter, timeSeries bool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Content-Type", "application/octet-stream")
 
@@ -244,426 +299,1691 @@ func trulyFunctionalCellHandler(cdg cellDataGetter) httprouter.Handle {
 			return
 		}
 
+		format, formatErr := responseFormat(r)
+
+		if formatErr != nil {
+			httpError(w, http.StatusBadRequest, formatErr)
+			return
+		}
+
+		var window *frameWindow
+
+		if timeSeries {
+			var windowErr error
+			window, windowErr = parseFrameWindow(r, scanID)
+
+			if windowErr == errBadWindow {
+				httpError(w, http.StatusBadRequest, windowErr)
+				return
+			} else if windowErr == sql.ErrNoRows {
+				httpError(w, http.StatusNotFound, windowErr)
+				return
+			} else if windowErr == errNoFps {
+				httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+				return
+			} else if windowErr != nil {
+				internalError(w, windowErr)
+				return
+			}
+		}
+
 		cellData, err4 := cdg(scanID, sliceID, cellID)
 
 		if err4 != nil {
 			internalError(w, err4)
 		} else {
-			w.Write(cellData)
+			writeBlobWindow(w, cellData, format, window)
 		}
 	}
 }
 
-func idsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	w.Header().Set("Content-Type", "application/json")
+// functionalIDParam maps a boss id to its functional id in the channel named by ps, answering with an error if it can't
+func functionalIDParam(w http.ResponseWriter, ps httprouter.Params, bossID int) (int, bool) {
+	channelID, chanErr := getChannel(ps)
 
-	resolution, parseError := strconv.ParseUint(ps.ByName("resolution"), 10, 0)
+	if chanErr == sql.ErrNoRows {
+		httpError(w, http.StatusNotFound, chanErr)
+		return 0, false
+	} else if chanErr != nil {
+		internalError(w, chanErr)
+		return 0, false
+	}
 
-	if parseError != nil {
-		httpError(w, http.StatusBadRequest, parseError)
-		return
+	funcID, lookupErr := structuralStore.GetFunctionalID(bossID, channelID)
+
+	if lookupErr == sql.ErrNoRows {
+		httpError(w, http.StatusNotFound, lookupErr)
+		return 0, false
+	} else if lookupErr != nil {
+		internalError(w, lookupErr)
+		return 0, false
 	}
 
-	bbox, parseError := parseBBox(ps)
+	return funcID, true
+}
 
-	if parseError != nil {
-		httpError(w, http.StatusBadRequest, parseError)
-	} else {
-		queryValues := r.URL.Query()
-		filterQV := queryValues.Get("filter")
+// gives a cell's mask centroid, area and position in microns, by boss id and channel when byBossID
+func maskGeometryHandler(byBossID bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		sliceID, err2 := strconv.Atoi(ps.ByName("sliceID"))
+		cellID, err3 := strconv.Atoi(ps.ByName("cellID"))
+
+		if err1 != nil || err2 != nil || err3 != nil {
+			firstError := err3
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
 
-		if filterQV == "keypoint" {
-			channelID, err := getChannel(ps)
-			if err == sql.ErrNoRows {
-				httpError(w, http.StatusNotFound, err)
-			} else if err != nil {
-				internalError(w, err)
+		if byBossID {
+			funcID, ok := functionalIDParam(w, ps, cellID)
+			if !ok {
+				return
 			}
 
-			upsampledBbox := BBox{
-				MIN: bbox.MIN.UpsampleAniso(resolution),
-				MAX: bbox.MAX.UpsampleAniso(resolution)}
+			cellID = funcID
+		}
 
-			ids, err := getKeypointsInRegion(channelID, upsampledBbox)
+		geometry, err4 := getMaskGeometry(scanID, sliceID, cellID)
 
-			json.NewEncoder(w).Encode(ids)
+		if err4 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err4)
+		} else if err4 != nil {
+			internalError(w, err4)
 		} else {
-			// passing channel string for boss query
-			ids, err := getUniqueIdsInRegion(channelString(ps), bbox, resolution)
-			if err != nil {
-				internalError(w, err)
-			} else {
-				json.NewEncoder(w).Encode(ids)
-			}
+			json.NewEncoder(w).Encode(geometry)
 		}
 	}
 }
 
-func main() {
-	loadBossInfo("boss.json")
+// gives a cell's responses to every stimulus trial grouped by condition, by boss id and channel when byBossID
+func responsesHandler(byBossID bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
 
-	structuralDb = connectToDb("structural-db-config.json")
-	defer structuralDb.Close()
-	functionalDb = connectToDb("functional-db-config.json")
-	defer functionalDb.Close()
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		sliceID, err2 := strconv.Atoi(ps.ByName("sliceID"))
+		cellID, err3 := strconv.Atoi(ps.ByName("cellID"))
 
-	client = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "", // no password set
-		DB:       0,  // use default DB
-	})
+		if err1 != nil || err2 != nil || err3 != nil {
+			firstError := err3
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			}
 
-	_, err := client.Ping().Result()
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
 
-	if err != nil {
-		fmt.Println("redis open error:", err)
-		os.Exit(1)
-	}
+		getter, err4 := cellSignal(r.URL.Query().Get("signal"))
+		pre, post, err5 := parseResponseWindow(r)
 
-	router := httprouter.New()
+		if err4 != nil || err5 != nil {
+			firstError := err5
+			if err4 != nil {
+				firstError = err4
+			}
 
-	// add services
-	router.GET("/testtoken", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		json.NewEncoder(w).Encode("success!")
-	})
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
 
-	// s1 is_synapse
-	router.GET("/is_synapse/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/json")
+		if byBossID {
+			funcID, ok := functionalIDParam(w, ps, cellID)
+			if !ok {
+				return
+			}
 
-		channelID, err := getChannel(ps)
+			cellID = funcID
+		}
 
-		bossID, _ := strconv.Atoi(ps.ByName("id"))
-		answer, err := isSynapse(bossID, channelID)
+		responses, err6 := getResponses(scanID, sliceID, cellID, getter, pre, post)
 
-		if err != nil {
-			internalError(w, err)
+		if err6 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err6)
+		} else if err6 == errNoFps || err6 == errUnsupportedConditions || err6 == errAmbiguousTrials {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, err6)
+		} else if err6 != nil {
+			internalError(w, err6)
 		} else {
-			res := boolRes{Result: answer}
-			json.NewEncoder(w).Encode(res)
+			json.NewEncoder(w).Encode(responses)
 		}
-	})
+	}
+}
 
-	// s5 is_neuron
-	router.GET("/is_neuron/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// gives a cell's direction tuning from its spike rate, by boss id and channel when byBossID
+func tuningHandler(byBossID bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Content-Type", "application/json")
 
-		channelID, err := getChannel(ps)
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		sliceID, err2 := strconv.Atoi(ps.ByName("sliceID"))
+		cellID, err3 := strconv.Atoi(ps.ByName("cellID"))
 
-		bossID, _ := strconv.Atoi(ps.ByName("id"))
-		answer, err := isNeuron(bossID, channelID)
+		if err1 != nil || err2 != nil || err3 != nil {
+			firstError := err3
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			}
 
-		if err == sql.ErrNoRows {
-			httpError(w, http.StatusNotFound, err)
-		} else if err != nil {
-			internalError(w, err)
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
+
+		if byBossID {
+			funcID, ok := functionalIDParam(w, ps, cellID)
+			if !ok {
+				return
+			}
+
+			cellID = funcID
+		}
+
+		tuning, err4 := getTuning(scanID, sliceID, cellID)
+
+		if err4 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err4)
+		} else if err4 == errUnsupportedConditions || err4 == errAmbiguousTrials {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, err4)
+		} else if err4 != nil {
+			internalError(w, err4)
 		} else {
-			res := boolRes{Result: answer}
-			json.NewEncoder(w).Encode(res)
+			json.NewEncoder(w).Encode(tuning)
 		}
-	})
+	}
+}
 
-	// s2 synapse_ids
-	router.GET("/synapse_ids/:collection/:experiment/:layer/:resolution/:xrange/:yrange/:zrange/", idsHandler)
+// correlates cells in a slice, POSTed {"ids": [...]} of boss ids or every connected functional neuron without a body
+func correlationHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
 
-	// s6 neuron_ids
-	router.GET("/neuron_ids/:collection/:experiment/:layer/:resolution/:xrange/:yrange/:zrange/", idsHandler)
+	var req idsReq
 
-	// s3 synapse_keypoint
-	router.GET("/synapse_keypoint/:collection/:experiment/:layer/:resolution/:id/", keypointHandler)
+	if r.Body != nil {
+		decodeError := json.NewDecoder(r.Body).Decode(&req)
 
-	// s7 neuron_keypoint
-	router.GET("/neuron_keypoint/:collection/:experiment/:layer/:resolution/:id/", keypointHandler)
+		if decodeError != nil && decodeError != io.EOF {
+			httpError(w, http.StatusBadRequest, decodeError)
+			return
+		}
+	}
 
-	// s4 synapse_parent
-	router.GET("/synapse_parent/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/json")
+	scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+	sliceID, err2 := strconv.Atoi(ps.ByName("sliceID"))
+	batch, err3 := cellBatchSignal(r.URL.Query().Get("signal"))
 
-		channelID, err := getChannel(ps)
+	if err1 != nil || err2 != nil || err3 != nil {
+		firstError := err3
+		if err1 != nil {
+			firstError = err1
+		} else if err2 != nil {
+			firstError = err2
+		}
 
-		synapseID, _ := strconv.Atoi(ps.ByName("id"))
+		httpError(w, http.StatusBadRequest, firstError)
+		return
+	}
 
-		pre, post, err := getSynapseParents(synapseID, channelID)
+	window, windowErr := parseFrameWindow(r, scanID)
 
-		if err == sql.ErrNoRows {
-			httpError(w, http.StatusNotFound, err)
-		} else if err != nil {
-			internalError(w, err)
-		} else {
-			w.Header().Set("Content-Type", "application/json")
+	if windowErr == errBadWindow {
+		httpError(w, http.StatusBadRequest, windowErr)
+		return
+	} else if windowErr == sql.ErrNoRows {
+		httpError(w, http.StatusNotFound, windowErr)
+		return
+	} else if windowErr == errNoFps {
+		httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+		return
+	} else if windowErr != nil {
+		internalError(w, windowErr)
+		return
+	}
 
-			res := parentRes{}
-			res.ParentNeurons = map[string]int{
-				strconv.Itoa(pre):  1,
-				strconv.Itoa(post): 2,
+	channelID, chanErr := getChannel(ps)
+
+	if chanErr == sql.ErrNoRows {
+		httpError(w, http.StatusNotFound, chanErr)
+		return
+	} else if chanErr != nil {
+		internalError(w, chanErr)
+		return
+	}
+
+	connected := r.URL.Query().Get("connected") == "true"
+
+	correlations, err4 := getCorrelations(batch, scanID, sliceID, req.Ids, channelID, window, connected)
+
+	if err4 == errTooManyIds {
+		httpError(w, http.StatusBadRequest, err4)
+	} else if err4 == errTooManyConnected {
+		httpErrorMessage(w, http.StatusUnprocessableEntity, err4)
+	} else if err4 != nil {
+		internalError(w, err4)
+	} else {
+		json.NewEncoder(w).Encode(correlations)
+	}
+}
+
+// renders a cell's mask as a png, by boss id and channel when byBossID and by functional id otherwise
+func maskImageHandler(byBossID bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "image/png")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		sliceID, err2 := strconv.Atoi(ps.ByName("sliceID"))
+		cellID, err3 := strconv.Atoi(ps.ByName("cellID"))
+		mode, err4 := maskImageMode(r.URL.Query().Get("mode"))
+
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			firstError := err4
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			} else if err3 != nil {
+				firstError = err3
 			}
 
-			jsonEncodeError := json.NewEncoder(w).Encode(res)
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
 
-			if jsonEncodeError != nil {
-				internalError(w, jsonEncodeError)
+		if byBossID {
+			funcID, ok := functionalIDParam(w, ps, cellID)
+			if !ok {
+				return
 			}
+
+			cellID = funcID
 		}
-	})
 
-	// s8 neuron_children
+		img, err5 := getMaskImage(scanID, sliceID, cellID, mode)
 
-	// test /neuron_children/team2_waypoint/pinky10/segmentation/15736:35973/19104:35456/4003:4258/15144/
-	router.GET("/neuron_children/:collection/:experiment/:layer/:resolution/:xrange/:yrange/:zrange/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if err5 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err5)
+		} else if err5 != nil {
+			internalError(w, err5)
+		} else {
+			png.Encode(w, img)
+		}
+	}
+}
+
+// answers a POST of {"ids": [...]} with a map from each id to what the single id endpoint gives for it
+func batchHandler(lookup batchLookup) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Content-Type", "application/json")
 
-		id, parseError := strconv.Atoi(ps.ByName("id"))
+		var req idsReq
 
-		if parseError != nil {
-			httpError(w, http.StatusBadRequest, parseError)
+		decodeError := json.NewDecoder(r.Body).Decode(&req)
+
+		if decodeError != nil {
+			httpError(w, http.StatusBadRequest, decodeError)
 			return
 		}
 
-		resolution, parseError := strconv.ParseUint(ps.ByName("resolution"), 10, 0)
-
-		if parseError != nil {
-			httpError(w, http.StatusBadRequest, parseError)
+		if len(req.Ids) > maxBatchIds {
+			httpError(w, http.StatusBadRequest, errTooManyBatchIds)
 			return
 		}
 
-		bbox, parseError := parseBBox(ps)
+		var resolution uint64
+
+		if ps.ByName("resolution") != "" {
+			var parseError error
+			resolution, parseError = strconv.ParseUint(ps.ByName("resolution"), 10, 0)
+
+			if parseError != nil {
+				httpError(w, http.StatusBadRequest, parseError)
+				return
+			}
+		}
+
+		nm, parseError := parseUnits(r)
 
 		if parseError != nil {
 			httpError(w, http.StatusBadRequest, parseError)
 			return
 		}
 
-		queryValues := r.URL.Query()
-		filterQV := queryValues.Get("filter")
-
-		children, err := getNeuronChildren(id, channelString(ps), bbox, resolution, filterQV == "keypoint")
+		channelID, err := getChannel(ps)
 
 		if err == sql.ErrNoRows {
 			httpError(w, http.StatusNotFound, err)
 			return
+		} else if err != nil {
+			internalError(w, err)
+			return
 		}
 
+		coords, err := getCoordinates(channelID, resolution, nm)
+
 		if err != nil {
 			internalError(w, err)
 			return
 		}
 
-		res := childrenRes{}
-		res.ChildrenSynapses = make(map[string]int)
+		found, err := lookup(req.Ids, channelID, coords)
 
-		for _, child := range children {
-			res.ChildrenSynapses[strconv.Itoa(child.Synapse)] = child.Polarity
+		if err != nil {
+			internalError(w, err)
+		} else {
+			json.NewEncoder(w).Encode(batchRes(req.Ids, found))
 		}
+	}
+}
 
-		json.NewEncoder(w).Encode(res)
-	})
+// stacks traces or spikes of many cells, POST {"ids": [...]} with boss ids when the route has a channel, em ids otherwise
+// ?slice=N keeps one slice, the result is a blob struct of data and index, or with ?format=npz an .npz of the same
+func cellBatchHandler(batch cellBatchGetter) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var req idsReq
 
-	router.GET("/neighbors/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/json")
+		decodeError := json.NewDecoder(r.Body).Decode(&req)
 
-		id, parseError := strconv.Atoi(ps.ByName("id"))
+		if decodeError != nil {
+			httpError(w, http.StatusBadRequest, decodeError)
+			return
+		}
 
-		if parseError != nil {
-			httpError(w, http.StatusBadRequest, parseError)
+		if len(req.Ids) > maxBatchIds {
+			httpError(w, http.StatusBadRequest, errTooManyBatchIds)
+			return
+		}
+
+		format, formatErr := batchFormat(r)
+
+		if formatErr != nil {
+			httpError(w, http.StatusBadRequest, formatErr)
+			return
+		}
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
 			return
 		}
 
 		queryValues := r.URL.Query()
 
-		functionalQV := queryValues.Get("functional")
+		slice := allSlices
+		if sliceQV := queryValues.Get("slice"); sliceQV != "" {
+			var err2 error
+			slice, err2 = strconv.Atoi(sliceQV)
+
+			if err2 != nil {
+				httpError(w, http.StatusBadRequest, err2)
+				return
+			}
+		}
+
+		emIDs := make(map[int]int)
+
+		if ps.ByName("collection") != "" {
+			channelID, chanErr := getChannel(ps)
+
+			if chanErr == sql.ErrNoRows {
+				httpError(w, http.StatusNotFound, chanErr)
+				return
+			} else if chanErr != nil {
+				internalError(w, chanErr)
+				return
+			}
+
+			var lookupErr error
+			emIDs, lookupErr = structuralStore.GetFunctionalIDBatch(req.Ids, channelID)
+
+			if lookupErr != nil {
+				internalError(w, lookupErr)
+				return
+			}
+		} else {
+			for _, id := range req.Ids {
+				emIDs[id] = id
+			}
+		}
+
+		stacked, err3 := getStackedCells(batch, scanID, slice, req.Ids, emIDs)
+
+		if err3 != nil {
+			internalError(w, err3)
+			return
+		}
+
+		w.Header().Set("Content-Type", batchContentTypes[format])
+
+		if format == "npz" {
+			err4 := writeNpz(w, []string{"data", "index"}, []ndarray{stacked.Data, stacked.Index})
+
+			if err4 != nil {
+				fmt.Println("npz write error:", err4)
+			}
+		} else {
+			w.Write(encodeStructBlob([]string{"data", "index"}, []ndarray{stacked.Data, stacked.Index}))
+		}
+	}
+}
+
+func idsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	resolution, parseError := strconv.ParseUint(ps.ByName("resolution"), 10, 0)
+
+	if parseError != nil {
+		httpError(w, http.StatusBadRequest, parseError)
+		return
+	}
+
+	nm, parseError := parseUnits(r)
+
+	if parseError != nil {
+		httpError(w, http.StatusBadRequest, parseError)
+		return
+	}
+
+	queryValues := r.URL.Query()
+	filterQV := queryValues.Get("filter")
+
+	// boss only needs the channel in the database to convert from nm
+	coords := coordinates{calibration: defaultCalibration, resolution: resolution}
+	var channelID int
+
+	if nm || filterQV == "keypoint" || filterQV == "bbox" {
+		var err error
+		channelID, err = getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		coords, err = getCoordinates(channelID, resolution, nm)
+
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	bbox, parseError := coords.parseRegion(ps)
+
+	if parseError != nil {
+		httpError(w, http.StatusBadRequest, parseError)
+		return
+	}
+
+	upsampledBbox := BBox{
+		MIN: coords.full(bbox.MIN),
+		MAX: coords.full(bbox.MAX)}
+
+	var ids IdsInRegionRes
+	var err error
+
+	if filterQV == "keypoint" {
+		ids, err = structuralStore.GetKeypointsInRegion(channelID, upsampledBbox)
+	} else if filterQV == "bbox" {
+		// answered from the local spatial index, BOSS isn't needed
+		ids, err = getIdsOverlapping(channelID, upsampledBbox)
+	} else {
+		// passing channel string for boss query
+		ids, err = bossClient.GetUniqueIdsInRegion(r.Context(), channelString(ps), bbox, resolution)
+	}
+
+	if err != nil {
+		internalError(w, err)
+	} else {
+		json.NewEncoder(w).Encode(ids)
+	}
+}
+
+func newRouter() *httprouter.Router {
+	router := httprouter.New()
+
+	// add services
+	router.GET("/testtoken", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		json.NewEncoder(w).Encode("success!")
+	})
+
+	// every collection/experiment/layer the other endpoints take
+	router.GET("/channels/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		channels, err := getChannels()
+
+		if err != nil {
+			internalError(w, err)
+		} else {
+			json.NewEncoder(w).Encode(channels)
+		}
+	})
+
+	// s1 is_synapse
+	router.GET("/is_synapse/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		channelID, err := getChannel(ps)
+
+		bossID, _ := strconv.Atoi(ps.ByName("id"))
+		answer, err := structuralStore.IsSynapse(bossID, channelID)
+
+		if err != nil {
+			internalError(w, err)
+		} else {
+			res := boolRes{Result: answer}
+			json.NewEncoder(w).Encode(res)
+		}
+	})
+
+	// s5 is_neuron
+	router.GET("/is_neuron/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		channelID, err := getChannel(ps)
+
+		bossID, _ := strconv.Atoi(ps.ByName("id"))
+		answer, err := structuralStore.IsNeuron(bossID, channelID)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+		} else if err != nil {
+			internalError(w, err)
+		} else {
+			res := boolRes{Result: answer}
+			json.NewEncoder(w).Encode(res)
+		}
+	})
+
+	// s2 synapse_ids
+	router.GET("/synapse_ids/:collection/:experiment/:layer/:resolution/:xrange/:yrange/:zrange/", idsHandler)
+
+	// s6 neuron_ids
+	router.GET("/neuron_ids/:collection/:experiment/:layer/:resolution/:xrange/:yrange/:zrange/", idsHandler)
+
+	// s3 synapse_keypoint
+	router.GET("/synapse_keypoint/:collection/:experiment/:layer/:resolution/:id/", keypointHandler)
+
+	// s7 neuron_keypoint
+	router.GET("/neuron_keypoint/:collection/:experiment/:layer/:resolution/:id/", keypointHandler)
+
+	// voxel sets with keypoints closest to a point, like "what synapses are near this click"
+	router.GET("/nearest/:collection/:experiment/:layer/:resolution/:x/:y/:z/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resolution, parseError := strconv.ParseUint(ps.ByName("resolution"), 10, 0)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		nm, parseError := parseUnits(r)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		k, radius, parseError := parseNearest(r)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		channelID, err := getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		coords, err := getCoordinates(channelID, resolution, nm)
+
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		point, parseError := coords.parsePoint(ps)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		res, err := getNearest(channelID, point, coords, k, radius)
+
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// s4 synapse_parent
+	router.GET("/synapse_parent/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
 
 		channelID, err := getChannel(ps)
 
-		if err == sql.ErrNoRows {
-			httpError(w, http.StatusNotFound, err)
+		synapseID, _ := strconv.Atoi(ps.ByName("id"))
+
+		pre, post, err := structuralStore.GetSynapseParents(synapseID, channelID)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+		} else if err != nil {
+			internalError(w, err)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+
+			res := parentRes{}
+			res.ParentNeurons = map[string]int{
+				strconv.Itoa(pre):  1,
+				strconv.Itoa(post): 2,
+			}
+
+			jsonEncodeError := json.NewEncoder(w).Encode(res)
+
+			if jsonEncodeError != nil {
+				internalError(w, jsonEncodeError)
+			}
+		}
+	})
+
+	// s8 neuron_children
+
+	// test /neuron_children/team2_waypoint/pinky10/segmentation/15736:35973/19104:35456/4003:4258/15144/
+	router.GET("/neuron_children/:collection/:experiment/:layer/:resolution/:xrange/:yrange/:zrange/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id, parseError := strconv.Atoi(ps.ByName("id"))
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		resolution, parseError := strconv.ParseUint(ps.ByName("resolution"), 10, 0)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		nm, parseError := parseUnits(r)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		channelID, err := getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		coords, err := getCoordinates(channelID, resolution, nm)
+
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		bbox, parseError := coords.parseRegion(ps)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		queryValues := r.URL.Query()
+		filterQV := queryValues.Get("filter")
+
+		children, err := getNeuronChildren(r.Context(), id, channelString(ps), bbox, resolution, filterQV)
+
+		if err == context.Canceled {
+			return // client went away, nobody to answer
+		}
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		}
+
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		res := childrenRes{}
+		res.ChildrenSynapses = make(map[string]int)
+
+		for _, child := range children {
+			res.ChildrenSynapses[strconv.Itoa(child.Synapse)] = child.Polarity
+		}
+
+		json.NewEncoder(w).Encode(res)
+	})
+
+	router.GET("/neighbors/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id, parseError := strconv.Atoi(ps.ByName("id"))
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		queryValues := r.URL.Query()
+
+		functionalQV := queryValues.Get("functional")
+
+		channelID, err := getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		}
+
+		neuronID, neuronErr := structuralStore.GetNeuronID(id, channelID)
+
+		if neuronErr != nil {
+			httpError(w, http.StatusNotFound, err)
+			return
+		}
+
+		presynaptic, err1 := structuralStore.GetNeighbors(neuronID, true, functionalQV == "true")
+		postsynaptic, err2 := structuralStore.GetNeighbors(neuronID, false, functionalQV == "true")
+
+		if err1 != nil {
+			internalError(w, err1)
+			return
+		}
+
+		if err2 != nil {
+			internalError(w, err2)
+			return
+		}
+
+		res := neighborsRes{}
+		res.Presynaptic = presynaptic
+		res.Postsynaptic = postsynaptic
+
+		json.NewEncoder(w).Encode(res)
+	})
+
+	router.GET("/connectivity/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id, parseError := strconv.Atoi(ps.ByName("id"))
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		queryValues := r.URL.Query()
+
+		depth := 1
+		if depthQV := queryValues.Get("depth"); depthQV != "" {
+			depth, parseError = strconv.Atoi(depthQV)
+
+			if parseError != nil {
+				httpError(w, http.StatusBadRequest, parseError)
+				return
+			}
+		}
+
+		if depth < 1 || depth > maxConnectivityDepth {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("depth should be between 1 and %d", maxConnectivityDepth))
+			return
+		}
+
+		direction := queryValues.Get("direction")
+		if direction == "" {
+			direction = "both"
+		}
+
+		channelID, err := getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		neuronID, err := structuralStore.GetNeuronID(id, channelID)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		res, err := getConnectivity(neuronID, id, depth, direction, queryValues.Get("functional") == "true")
+
+		if err == errBadDirection {
+			httpError(w, http.StatusBadRequest, err)
+		} else if err != nil {
+			internalError(w, err)
+		} else {
+			json.NewEncoder(w).Encode(res)
+		}
+	})
+
+	router.GET("/path/:collection/:experiment/:layer/:from/:to/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		fromID, err1 := strconv.Atoi(ps.ByName("from"))
+		toID, err2 := strconv.Atoi(ps.ByName("to"))
+
+		if err1 != nil || err2 != nil {
+			firstError := err2
+			if err1 != nil {
+				firstError = err1
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
+
+		queryValues := r.URL.Query()
+
+		weight := queryValues.Get("weight")
+		if weight == "" {
+			weight = "hops"
+		}
+
+		channelID, err := getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		fromNeuron, err1 := structuralStore.GetNeuronID(fromID, channelID)
+		toNeuron, err2 := structuralStore.GetNeuronID(toID, channelID)
+
+		if err1 == sql.ErrNoRows || err2 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, sql.ErrNoRows)
+			return
+		} else if err1 != nil || err2 != nil {
+			firstError := err2
+			if err1 != nil {
+				firstError = err1
+			}
+
+			internalError(w, firstError)
+			return
+		}
+
+		res, err := getShortestPath(fromNeuron, toNeuron, queryValues.Get("directed") != "false", weight)
+
+		if err == errBadWeight {
+			httpError(w, http.StatusBadRequest, err)
+		} else if err == errNoPath {
+			httpError(w, http.StatusNotFound, err)
+		} else if err == errPathSearchLimit {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, err)
+		} else if err != nil {
+			internalError(w, err)
+		} else {
+			json.NewEncoder(w).Encode(res)
+		}
+	})
+
+	// body is {"ids": [...]}, ?format=npy or ?format=blob sends only the matrix
+	router.POST("/induced_subgraph/:collection/:experiment/:layer/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var req idsReq
+
+		decodeError := json.NewDecoder(r.Body).Decode(&req)
+
+		if decodeError != nil {
+			httpError(w, http.StatusBadRequest, decodeError)
+			return
+		}
+
+		channelID, err := getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		res, err := getInducedSubgraph(req.Ids, channelID)
+
+		if err == errTooManyIds {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		switch r.URL.Query().Get("format") {
+		case "npy":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(res.ndarray().npy())
+		case "blob":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(res.ndarray().djBlob())
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(res)
+		}
+	})
+
+	// streams the whole neuron graph of a channel, ?format=graphml (default), gexf, csv or networkx
+	router.GET("/export/:collection/:experiment/:layer/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "graphml"
+		}
+
+		contentType, ok := exportContentTypes[format]
+
+		if !ok {
+			httpError(w, http.StatusBadRequest, errBadExportFormat)
+			return
+		}
+
+		channelID, err := getChannel(ps)
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+
+		// headers are already sent, so a failure part way can only be logged
+		if err := exportGraph(w, format, channelString(ps), channelID); err != nil {
+			fmt.Println("export error:", err)
+		}
+	})
+
+	// se1
+	router.GET("/bbox/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		nm, parseError := parseUnits(r)
+
+		if parseError != nil {
+			httpError(w, http.StatusBadRequest, parseError)
+			return
+		}
+
+		channelID, err := getChannel(ps)
+
+		id, _ := strconv.Atoi(ps.ByName("id"))
+		res, err := structuralStore.GetBBox(id, channelID)
+
+		var coords coordinates
+		if err == nil {
+			coords, err = getCoordinates(channelID, 0, nm)
+		}
+
+		if err == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err)
+		} else if err != nil {
+			internalError(w, err)
+		} else {
+			json.NewEncoder(w).Encode(coords.bbox(res))
+		}
+	})
+
+	// batch variants of s1, s5, se1, s3, s7 and s4, ids not found map to null
+	router.POST("/is_synapse/:collection/:experiment/:layer/", batchHandler(isSynapseBatch))
+	router.POST("/is_neuron/:collection/:experiment/:layer/", batchHandler(isNeuronBatch))
+	router.POST("/bbox/:collection/:experiment/:layer/", batchHandler(bboxBatch))
+	router.POST("/synapse_keypoint/:collection/:experiment/:layer/:resolution/", batchHandler(keypointBatch))
+	router.POST("/neuron_keypoint/:collection/:experiment/:layer/:resolution/", batchHandler(keypointBatch))
+	router.POST("/synapse_parent/:collection/:experiment/:layer/", batchHandler(synapseParentBatch))
+
+	// functional
+	router.GET("/scans/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		scans, err2 := functionalStore.GetScans()
+
+		if err2 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err2)
+		} else if err2 != nil {
+			internalError(w, err2)
+		} else {
+			json.NewEncoder(w).Encode(scans)
+		}
+	})
+
+	router.GET("/scans/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
+			return
+		}
+
+		scanMetadata, err2 := functionalStore.GetScanMetadata(scanID)
+
+		if err2 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err2)
+		} else if err2 != nil {
+			internalError(w, err2)
+		} else {
+			json.NewEncoder(w).Encode(scanMetadata)
+		}
+	})
+
+	router.GET("/stimulus/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
+			return
+		}
+
+		format, formatErr := responseFormat(r)
+
+		if formatErr != nil {
+			httpError(w, http.StatusBadRequest, formatErr)
+			return
+		}
+
+		cachedFilename := fmt.Sprintf("./cache/stimulus/%d", scanID)
+
+		if _, err2 := os.Stat(cachedFilename); err2 == nil && format == "blob" {
+			http.ServeFile(w, r, cachedFilename)
+		} else if err2 == nil {
+			stimulus, err3 := ioutil.ReadFile(cachedFilename)
+
+			if err3 != nil {
+				internalError(w, err3)
+			} else {
+				writeBlob(w, stimulus, format)
+			}
+		} else {
+			stimulus, err3 := functionalStore.GetStimulus(scanID)
+
+			if err3 != nil {
+				internalError(w, err3)
+			} else {
+				writeBlob(w, stimulus, format)
+			}
+		}
+	})
+
+	router.GET("/stimulus/:scanID/frame/:frame", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "image/png")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		frame, err2 := strconv.Atoi(strings.TrimSuffix(ps.ByName("frame"), ".png"))
+		factor, err3 := parseDownsample(r)
+
+		if err1 != nil || err2 != nil || err3 != nil {
+			firstError := err3
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
+
+		movie, err4 := getMovie(scanID)
+
+		if err4 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err4)
+			return
+		} else if err4 != nil {
+			internalError(w, err4)
+			return
+		}
+
+		if frame < 0 || frame >= movie.Shape[2] {
+			httpError(w, http.StatusNotFound, errNoFrame)
+			return
+		}
+
+		// only the one frame gets downsampled
+		img, err5 := movie.framesAlong(2, frameWindow{Start: frame, Stop: frame + 1}).downsample(factor).frameImage(0)
+
+		if err5 != nil {
+			internalError(w, err5)
+		} else {
+			png.Encode(w, img)
+		}
+	})
+
+	router.GET("/stimulus/:scanID/clip/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		factor, err2 := parseDownsample(r)
+		format, err3 := responseFormat(r)
+
+		if err1 != nil || err2 != nil || err3 != nil {
+			firstError := err3
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
+
+		window, windowErr := parseFrameWindow(r, scanID)
+
+		if windowErr == errBadWindow {
+			httpError(w, http.StatusBadRequest, windowErr)
+			return
+		} else if windowErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, windowErr)
+			return
+		} else if windowErr == errNoFps {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+			return
+		} else if windowErr != nil {
+			internalError(w, windowErr)
+			return
+		}
+
+		movie, err4 := getMovie(scanID)
+
+		if err4 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err4)
+			return
+		} else if err4 != nil {
+			internalError(w, err4)
+			return
+		}
+
+		if window != nil {
+			movie = movie.framesAlong(2, *window)
+		}
+
+		writeArray(w, movie.downsample(factor), format)
+	})
+
+	router.GET("/stimulus/:scanID/gif/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		factor, err2 := parseDownsample(r)
+
+		if err1 != nil || err2 != nil {
+			firstError := err1
+			if err1 == nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
+
+		window, windowErr := parseFrameWindow(r, scanID)
+
+		if windowErr == errBadWindow {
+			httpError(w, http.StatusBadRequest, windowErr)
+			return
+		} else if windowErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, windowErr)
+			return
+		} else if windowErr == errNoFps {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+			return
+		} else if windowErr != nil {
+			internalError(w, windowErr)
+			return
+		}
+
+		filename, err3 := cachedGIF(scanID, window, factor)
+
+		if err3 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err3)
+		} else if err3 == errTooManyFrames || err3 == errEmptyAnimation {
+			httpError(w, http.StatusBadRequest, err3)
+		} else if err3 != nil {
+			internalError(w, err3)
+		} else {
+			w.Header().Set("Content-Type", "image/gif")
+			http.ServeFile(w, r, filename)
+		}
+	})
+
+	router.GET("/stimulus/:scanID/mjpeg/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		factor, err2 := parseDownsample(r)
+
+		if err1 != nil || err2 != nil {
+			firstError := err1
+			if err1 == nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
+			return
+		}
+
+		window, windowErr := parseFrameWindow(r, scanID)
+
+		if windowErr == errBadWindow {
+			httpError(w, http.StatusBadRequest, windowErr)
+			return
+		} else if windowErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, windowErr)
+			return
+		} else if windowErr == errNoFps {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+			return
+		} else if windowErr != nil {
+			internalError(w, windowErr)
+			return
+		}
+
+		scanMetadata, err3 := functionalStore.GetScanMetadata(scanID)
+		movie, err4 := getMovie(scanID)
+
+		if err3 == nil {
+			err3 = err4
+		}
+
+		if err3 == nil {
+			movie, err3 = animationFrames(movie, window, factor)
+		}
+
+		if err3 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err3)
+		} else if err3 == errTooManyFrames || err3 == errEmptyAnimation {
+			httpError(w, http.StatusBadRequest, err3)
+		} else if err3 != nil {
+			internalError(w, err3)
+		} else if scanMetadata.Fps <= 0 {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, errNoFps)
+		} else if err5 := streamMJPEG(w, r, movie, scanMetadata.Fps); err5 != nil && err5 != r.Context().Err() {
+			log.Println(err5)
+		}
+	})
+
+	router.GET("/stimulus_conditions/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
+			return
+		}
+
+		cachedFilename := fmt.Sprintf("./cache/stimulus/%d", scanID)
+
+		if _, err2 := os.Stat(cachedFilename); err2 == nil {
+			http.ServeFile(w, r, cachedFilename)
+		} else {
+			stimulusConditions, err3 := functionalStore.GetStimulusConditions(scanID)
+
+			if err3 != nil {
+				internalError(w, err3)
+			} else {
+				w.Write(stimulusConditions)
+			}
+		}
+	})
+
+	router.GET("/treadmill/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
+			return
+		}
+
+		format, formatErr := responseFormat(r)
+
+		if formatErr != nil {
+			httpError(w, http.StatusBadRequest, formatErr)
+			return
+		}
+
+		treadmill, err2 := functionalStore.GetTreadmill(scanID)
+
+		if err2 != nil {
+			internalError(w, err2)
+			return
+		}
+
+		window, windowErr := parseStreamWindow(r, scanID, treadmill)
+
+		if windowErr == errBadWindow {
+			httpError(w, http.StatusBadRequest, windowErr)
+		} else if windowErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, windowErr)
+		} else if windowErr == errUnsupportedBlob {
+			httpError(w, http.StatusNotAcceptable, windowErr)
+		} else if windowErr == errNoFps {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+		} else if windowErr != nil {
+			internalError(w, windowErr)
+		} else {
+			writeBlobWindow(w, treadmill, format, window)
+		}
+	})
+
+	router.GET("/behavior/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
 			return
 		}
 
-		neuronID, neuronErr := getNeuronID(id, channelID)
+		format, formatErr := responseFormat(r)
 
-		if neuronErr != nil {
-			httpError(w, http.StatusNotFound, err)
+		if formatErr != nil {
+			httpError(w, http.StatusBadRequest, formatErr)
 			return
 		}
 
-		presynaptic, err1 := getNeighbors(neuronID, true, functionalQV == "true")
-		postsynaptic, err2 := getNeighbors(neuronID, false, functionalQV == "true")
+		window, windowErr := parseFrameWindow(r, scanID)
 
-		if err1 != nil {
-			internalError(w, err1)
+		if windowErr == errBadWindow {
+			httpError(w, http.StatusBadRequest, windowErr)
+			return
+		} else if windowErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, windowErr)
+			return
+		} else if windowErr == errNoFps {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, windowErr)
+			return
+		} else if windowErr != nil {
+			internalError(w, windowErr)
 			return
 		}
 
-		if err2 != nil {
+		behavior, err2 := getBehavior(scanID)
+
+		if err2 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err2)
+			return
+		} else if err2 != nil {
 			internalError(w, err2)
 			return
 		}
 
-		res := neighborsRes{}
-		res.Presynaptic = presynaptic
-		res.Postsynaptic = postsynaptic
+		if window != nil {
+			behavior = behavior.framesAlong(1, *window)
+		}
 
-		json.NewEncoder(w).Encode(res)
+		w.Header().Set("X-Columns", strings.Join(behaviorColumns, ","))
+		writeArray(w, behavior, format)
 	})
 
-	// se1
-	router.GET("/bbox/:collection/:experiment/:layer/:id/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/json")
+	router.GET("/pupil_r/:scanID/", pupilHandler(functionalStore.GetPupilR))
+	router.GET("/pupil_x/:scanID/", pupilHandler(functionalStore.GetPupilX))
+	router.GET("/pupil_y/:scanID/", pupilHandler(functionalStore.GetPupilY))
 
-		channelID, err := getChannel(ps)
+	router.GET("/slices_for_cell_functional/:cellID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
 
-		id, _ := strconv.Atoi(ps.ByName("id"))
-		res, err := getBBox(id, channelID)
+		cellID, err1 := strconv.Atoi(ps.ByName("cellID"))
 
-		if err == sql.ErrNoRows {
-			httpError(w, http.StatusNotFound, err)
-		} else if err != nil {
-			internalError(w, err)
-		} else {
-			json.NewEncoder(w).Encode(res)
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
+			return
 		}
-	})
-
-	// functional
-	router.GET("/scans/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/json")
 
-		scans, err2 := getScans()
+		slicesPerScan, err2 := functionalStore.GetSlicesForCell(cellID)
 
 		if err2 == sql.ErrNoRows {
 			httpError(w, http.StatusNotFound, err2)
 		} else if err2 != nil {
 			internalError(w, err2)
 		} else {
-			json.NewEncoder(w).Encode(scans)
+			json.NewEncoder(w).Encode(slicesPerScan)
 		}
 	})
 
-	router.GET("/scans/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	router.GET("/slices_for_cell/:collection/:experiment/:layer/:cellID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Content-Type", "application/json")
 
-		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		cellID, err1 := strconv.Atoi(ps.ByName("cellID"))
 
 		if err1 != nil {
 			httpError(w, http.StatusBadRequest, err1)
 			return
 		}
 
-		scanMetadata, err2 := getScanMetadata(scanID)
+		channelID, chanErr := getChannel(ps)
+
+		if chanErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, chanErr)
+			return
+		} else if chanErr != nil {
+			internalError(w, chanErr)
+			return
+		}
+
+		funcID, lookupErr := structuralStore.GetFunctionalID(cellID, channelID)
+
+		if lookupErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, lookupErr)
+			return
+		} else if lookupErr == errNoCellFunctionalId {
+			httpError(w, http.StatusNotFound, lookupErr)
+			return
+		} else if lookupErr != nil {
+			internalError(w, lookupErr)
+			return
+		}
+
+		slicesPerScan, err2 := functionalStore.GetSlicesForCell(funcID)
 
 		if err2 == sql.ErrNoRows {
 			httpError(w, http.StatusNotFound, err2)
 		} else if err2 != nil {
 			internalError(w, err2)
 		} else {
-			json.NewEncoder(w).Encode(scanMetadata)
+			json.NewEncoder(w).Encode(slicesPerScan)
 		}
 	})
 
-	router.GET("/stimulus/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/octet-stream")
+	router.GET("/mask/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", functionalCellHandler(functionalStore.GetMask, false))
+	router.GET("/mask/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/image.png", maskImageHandler(true))
+	router.GET("/trace/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", functionalCellHandler(functionalStore.GetTrace, true))
+	router.GET("/spike/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", functionalCellHandler(functionalStore.GetSpike, true))
+
+	router.GET("/mask_functional/:scanID/:sliceID/:cellID/", trulyFunctionalCellHandler(functionalStore.GetMask, false))
+	router.GET("/mask_functional/:scanID/:sliceID/:cellID/image.png", maskImageHandler(false))
+	router.GET("/trace_functional/:scanID/:sliceID/:cellID/", trulyFunctionalCellHandler(functionalStore.GetTrace, true))
+	router.GET("/spike_functional/:scanID/:sliceID/:cellID/", trulyFunctionalCellHandler(functionalStore.GetSpike, true))
+
+	router.GET("/slice_masks/:scanID/:slice", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "image/png")
 
 		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		sliceID, err2 := strconv.Atoi(strings.TrimSuffix(ps.ByName("slice"), ".png"))
+		mode, err3 := maskImageMode(r.URL.Query().Get("mode"))
 
-		if err1 != nil {
-			httpError(w, http.StatusBadRequest, err1)
+		if err1 != nil || err2 != nil || err3 != nil {
+			firstError := err3
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
 			return
 		}
 
-		cachedFilename := fmt.Sprintf("./cache/stimulus/%d", scanID)
+		img, err4 := getSliceMasksImage(scanID, sliceID, mode)
 
-		if _, err2 := os.Stat(cachedFilename); err2 == nil {
-			http.ServeFile(w, r, cachedFilename)
+		if err4 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err4)
+		} else if err4 != nil {
+			internalError(w, err4)
 		} else {
-			stimulus, err3 := getStimulus(scanID)
-
-			if err3 != nil {
-				internalError(w, err3)
-			} else {
-				w.Write(stimulus)
-			}
+			png.Encode(w, img)
 		}
 	})
 
-	router.GET("/stimulus_conditions/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/octet-stream")
+	router.GET("/mask_geometry/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", maskGeometryHandler(true))
+	router.GET("/mask_geometry_functional/:scanID/:sliceID/:cellID/", maskGeometryHandler(false))
+
+	router.GET("/slice_mask_geometry/:scanID/:sliceID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
 
 		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		sliceID, err2 := strconv.Atoi(ps.ByName("sliceID"))
 
-		if err1 != nil {
-			httpError(w, http.StatusBadRequest, err1)
+		if err1 != nil || err2 != nil {
+			firstError := err1
+			if err1 == nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
 			return
 		}
 
-		cachedFilename := fmt.Sprintf("./cache/stimulus/%d", scanID)
+		geometries, err3 := getSliceMaskGeometry(scanID, sliceID)
 
-		if _, err2 := os.Stat(cachedFilename); err2 == nil {
-			http.ServeFile(w, r, cachedFilename)
+		if err3 == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, err3)
+		} else if err3 != nil {
+			internalError(w, err3)
 		} else {
-			stimulusConditions, err3 := getStimulusConditions(scanID)
-
-			if err3 != nil {
-				internalError(w, err3)
-			} else {
-				w.Write(stimulusConditions)
-			}
+			json.NewEncoder(w).Encode(geometries)
 		}
 	})
 
-	router.GET("/treadmill/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		w.Header().Set("Content-Type", "application/octet-stream")
+	router.GET("/coregistered_neurons/:cellID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
 
-		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		cellID, err1 := strconv.Atoi(ps.ByName("cellID"))
 
 		if err1 != nil {
 			httpError(w, http.StatusBadRequest, err1)
 			return
 		}
 
-		treadmill, err2 := getTreadmill(scanID)
+		neurons, err2 := getCoregisteredNeurons(cellID)
 
-		if err2 != nil {
+		if err2 == errNotCoregistered {
+			httpError(w, http.StatusNotFound, err2)
+		} else if err2 != nil {
 			internalError(w, err2)
 		} else {
-			w.Write(treadmill)
+			json.NewEncoder(w).Encode(neurons)
 		}
 	})
 
-	router.GET("/pupil_r/:scanID/", pupilHandler(getPupilR))
-	router.GET("/pupil_x/:scanID/", pupilHandler(getPupilX))
-	router.GET("/pupil_y/:scanID/", pupilHandler(getPupilY))
-
-	router.GET("/slices_for_cell_functional/:cellID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	router.GET("/coregistered_neurons_for_mask/:scanID/:sliceID/:cellID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Content-Type", "application/json")
 
-		cellID, err1 := strconv.Atoi(ps.ByName("cellID"))
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+		sliceID, err2 := strconv.Atoi(ps.ByName("sliceID"))
+		cellID, err3 := strconv.Atoi(ps.ByName("cellID"))
 
-		if err1 != nil {
-			httpError(w, http.StatusBadRequest, err1)
+		if err1 != nil || err2 != nil || err3 != nil {
+			firstError := err3
+			if err1 != nil {
+				firstError = err1
+			} else if err2 != nil {
+				firstError = err2
+			}
+
+			httpError(w, http.StatusBadRequest, firstError)
 			return
 		}
 
-		slicesPerScan, err2 := getSlicesForCell(cellID)
+		neurons, err4 := getMaskNeurons(scanID, sliceID, cellID)
 
-		if err2 == sql.ErrNoRows {
-			httpError(w, http.StatusNotFound, err2)
-		} else if err2 != nil {
-			internalError(w, err2)
+		if err4 == sql.ErrNoRows || err4 == errNotCoregistered {
+			httpError(w, http.StatusNotFound, err4)
+		} else if err4 != nil {
+			internalError(w, err4)
 		} else {
-			json.NewEncoder(w).Encode(slicesPerScan)
+			json.NewEncoder(w).Encode(neurons)
 		}
 	})
 
-	router.GET("/slices_for_cell/:collection/:experiment/:layer/:cellID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	router.GET("/coregistered/:collection/:experiment/:layer/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Content-Type", "application/json")
 
-		cellID, err1 := strconv.Atoi(ps.ByName("cellID"))
+		channelID, chanErr := getChannel(ps)
 
-		if err1 != nil {
-			httpError(w, http.StatusBadRequest, err1)
+		if chanErr == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, chanErr)
+			return
+		} else if chanErr != nil {
+			internalError(w, chanErr)
 			return
 		}
 
+		neurons, err := getCoregisteredChannel(channelString(ps), channelID)
+
+		if err != nil {
+			internalError(w, err)
+		} else {
+			json.NewEncoder(w).Encode(neurons)
+		}
+	})
+
+	router.GET("/functional_connectome/:collection/:experiment/:layer/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
 		channelID, chanErr := getChannel(ps)
 
 		if chanErr == sql.ErrNoRows {
@@ -674,37 +1994,115 @@ func main() {
 			return
 		}
 
-		funcID, lookupErr := getFunctionalID(cellID, channelID)
+		connectome, err := getFunctionalConnectome(channelID)
 
-		if lookupErr == sql.ErrNoRows {
-			httpError(w, http.StatusNotFound, lookupErr)
-			return
-		} else if lookupErr == errNoCellFunctionalId {
-			httpError(w, http.StatusNotFound, lookupErr)
-			return
-		} else if lookupErr != nil {
-			internalError(w, lookupErr)
+		if err != nil {
+			internalError(w, err)
+		} else {
+			json.NewEncoder(w).Encode(connectome)
+		}
+	})
+
+	router.GET("/responses/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", responsesHandler(true))
+	router.GET("/responses_functional/:scanID/:sliceID/:cellID/", responsesHandler(false))
+
+	router.GET("/tuning/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", tuningHandler(true))
+	router.GET("/tuning_functional/:scanID/:sliceID/:cellID/", tuningHandler(false))
+
+	router.GET("/scan_tuning/:scanID/", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+
+		scanID, err1 := strconv.Atoi(ps.ByName("scanID"))
+
+		if err1 != nil {
+			httpError(w, http.StatusBadRequest, err1)
 			return
 		}
 
-		slicesPerScan, err2 := getSlicesForCell(funcID)
+		filename, err2 := cachedScanTuning(scanID)
 
 		if err2 == sql.ErrNoRows {
 			httpError(w, http.StatusNotFound, err2)
+		} else if err2 == errUnsupportedConditions || err2 == errAmbiguousTrials {
+			httpErrorMessage(w, http.StatusUnprocessableEntity, err2)
 		} else if err2 != nil {
 			internalError(w, err2)
 		} else {
-			json.NewEncoder(w).Encode(slicesPerScan)
+			http.ServeFile(w, r, filename)
 		}
 	})
 
-	router.GET("/mask/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", functionalCellHandler(getMask))
-	router.GET("/trace/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", functionalCellHandler(getTrace))
-	router.GET("/spike/:collection/:experiment/:layer/:scanID/:sliceID/:cellID/", functionalCellHandler(getSpike))
+	router.GET("/correlations/:collection/:experiment/:layer/:scanID/:sliceID/", correlationHandler)
+	router.POST("/correlations/:collection/:experiment/:layer/:scanID/:sliceID/", correlationHandler)
 
-	router.GET("/mask_functional/:scanID/:sliceID/:cellID/", trulyFunctionalCellHandler(getMask))
-	router.GET("/trace_functional/:scanID/:sliceID/:cellID/", trulyFunctionalCellHandler(getTrace))
-	router.GET("/spike_functional/:scanID/:sliceID/:cellID/", trulyFunctionalCellHandler(getSpike))
+	router.POST("/traces/:collection/:experiment/:layer/:scanID/", cellBatchHandler(functionalStore.GetTraceBatch))
+	router.POST("/spikes/:collection/:experiment/:layer/:scanID/", cellBatchHandler(functionalStore.GetSpikeBatch))
+
+	router.POST("/traces_functional/:scanID/", cellBatchHandler(functionalStore.GetTraceBatch))
+	router.POST("/spikes_functional/:scanID/", cellBatchHandler(functionalStore.GetSpikeBatch))
+
+	return router
+}
+
+func main() {
+	loadBossInfo("boss.json")
+
+	// BOSS_VOLUMES points at a json file of labeled volumes, served locally in place of boss
+	if volumes := os.Getenv("BOSS_VOLUMES"); volumes != "" {
+		boss, err := loadLocalBoss(volumes)
+
+		if err != nil {
+			fmt.Println("boss volumes open error:", err)
+			os.Exit(1)
+		}
+
+		url, err := startLocalBoss(boss)
+
+		if err != nil {
+			fmt.Println("local boss listen error:", err)
+			os.Exit(1)
+		}
+
+		bossInfo.URL = url
+	}
+
+	bossClient = &httpBossClient{config: bossInfo}
+
+	// FIXTURES points at a json dump of both databases, for running without MySQL
+	if fixtures := os.Getenv("FIXTURES"); fixtures != "" {
+		structural, functional, err := loadMemoryStores(fixtures)
+
+		if err != nil {
+			fmt.Println("fixtures open error:", err)
+			os.Exit(1)
+		}
+
+		structuralStore = structural
+		functionalStore = functional
+	} else {
+		structuralDb := connectToDb("structural-db-config.json")
+		defer structuralDb.Close()
+		functionalDb := connectToDb("functional-db-config.json")
+		defer functionalDb.Close()
+
+		structuralStore = &mysqlStructuralStore{db: structuralDb}
+		functionalStore = &mysqlFunctionalStore{db: functionalDb}
+	}
+
+	client = redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	_, err := client.Ping().Result()
+
+	if err != nil {
+		fmt.Println("redis open error:", err)
+		os.Exit(1)
+	}
+
+	router := newRouter()
 
 	fmt.Printf("started  on port %s\n", os.Getenv("PORT"))
 
@@ -725,13 +2123,19 @@ func internalError(w http.ResponseWriter, err error) {
 }
 
 func httpError(w http.ResponseWriter, status int, err error) {
-	fmt.Println("http error" + " " + string(status))
+	fmt.Println("http error" + " " + strconv.Itoa(status))
 	if err != nil {
 		fmt.Println(err)
 	}
 	http.Error(w, http.StatusText(status), status)
 }
 
+// httpErrorMessage is httpError with the error as the body, for errors the status alone doesn't explain
+func httpErrorMessage(w http.ResponseWriter, status int, err error) {
+	fmt.Println("http error", status, err)
+	http.Error(w, err.Error(), status)
+}
+
 func parseBBox(ps httprouter.Params) (BBox, error) {
 	res := BBox{
 		MIN: Vector3{
@@ -805,25 +2209,6 @@ func (v Vector3) Min(v2 Vector3) Vector3 {
 	}
 }
 
-// DownsampleAniso converts a vector at max resolution to downsampled coordinates
-// only downsamples x and y
-func (v Vector3) DownsampleAniso(resolution uint64) Vector3 {
-	return Vector3{
-		v.X / (1 << resolution),
-		v.Y / (1 << resolution),
-		v.Z,
-	}
-}
-
-// UpsampleAniso ...
-func (v Vector3) UpsampleAniso(resolution uint64) Vector3 {
-	return Vector3{
-		v.X * (1 << resolution),
-		v.Y * (1 << resolution),
-		v.Z,
-	}
-}
-
 // Inside is this vector inside that bbox?
 func (v Vector3) Inside(b BBox) bool {
 	return v.GreaterEq(b.MIN) && v.LesserEq(b.MAX)
@@ -876,6 +2261,23 @@ func (b BBox) Inside(other BBox) bool {
 	return b.MIN.GreaterEq(other.MIN) && b.MAX.LesserEq(other.MAX)
 }
 
+// Overlaps do the bboxes share any voxel?
+func (b BBox) Overlaps(other BBox) bool {
+	_, err := b.Intersection(other)
+	return err == nil
+}
+
+// Union returns the smallest bbox containing both bboxes
+func (b BBox) Union(other BBox) BBox {
+	return BBox{b.MIN.Min(other.MIN), b.MAX.Max(other.MAX)}
+}
+
+// Volume is the number of voxels in the bbox, bounds are inclusive
+func (b BBox) Volume() int {
+	size := addVectors(subVectors(b.MAX, b.MIN), Vector3{1, 1, 1})
+	return size.X * size.Y * size.Z
+}
+
 func keypointHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -898,16 +2300,26 @@ func keypointHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 		return
 	}
 
-	keypoint, err := getKeypoint(bossID, channelID)
+	nm, parseError := parseUnits(r)
+	if parseError != nil {
+		httpError(w, http.StatusBadRequest, parseError)
+		return
+	}
+
+	coords, err := getCoordinates(channelID, resolution, nm)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
 
-	keypoint = keypoint.DownsampleAniso(resolution)
+	keypoint, err := structuralStore.GetKeypoint(bossID, channelID)
 
 	if err == sql.ErrNoRows {
 		httpError(w, http.StatusNotFound, err)
 	} else if err != nil {
 		internalError(w, err)
 	} else {
-		res := KeypointRes{Keypoint: [...]int{keypoint.X, keypoint.Y, keypoint.Z}}
+		res := KeypointRes{Keypoint: coords.point(keypoint)}
 		json.NewEncoder(w).Encode(res)
 	}
 }
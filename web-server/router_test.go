@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func intPointer(i int) *int { return &i }
+
+// useTestStores swaps in small memory stores for the test, and empties the per process caches built from them
+// channel 1 "c/e/seg" has neurons 1 to 5 with boss ids 101 to 105, the odd ones imaged as em_id 1000, 3000 and 5000
+// channel 2 "c/e/syn" has synapses 201 to 206 for the edges 1->2 twice, 2->3, 3->4, 5->1 and 4->2
+// scan 1 has 4 frames at 2 fps and the trace of em_id 1000 in slice 1
+func useTestStores(t *testing.T) (*memoryStructuralStore, *memoryFunctionalStore) {
+	structural := &memoryStructuralStore{Channels: map[string]int{"c/e/seg": 1, "c/e/syn": 2}}
+
+	for i := 1; i <= 5; i++ {
+		structural.VoxelSets = append(structural.VoxelSets, memoryVoxelSet{ID: i, BossID: 100 + i, Size: 10 * i, Channel: 1,
+			Keypoint: Vector3{i * 10, i * 10, i},
+			BBox:     BBox{Vector3{i*10 - 5, i*10 - 5, i - 1}, Vector3{i*10 + 5, i*10 + 5, i + 1}}})
+
+		neuron := memoryNeuron{ID: i, VoxelSet: i}
+		if i%2 == 1 {
+			neuron.EmID = intPointer(i * 1000)
+		}
+		structural.Neurons = append(structural.Neurons, neuron)
+	}
+
+	for j, edge := range [][2]int{{1, 2}, {1, 2}, {2, 3}, {3, 4}, {5, 1}, {4, 2}} {
+		structural.VoxelSets = append(structural.VoxelSets, memoryVoxelSet{ID: 50 + j, BossID: 201 + j, Size: j + 1, Channel: 2,
+			Keypoint: Vector3{j * 7, j * 7, j},
+			BBox:     BBox{Vector3{j * 7, j * 7, j}, Vector3{j*7 + 2, j*7 + 2, j}}})
+		structural.Synapses = append(structural.Synapses, memorySynapse{ID: j + 1, VoxelSet: 50 + j, Pre: edge[0], Post: edge[1]})
+	}
+
+	functional := &memoryFunctionalStore{
+		Scans: map[int]memoryScan{1: {Metadata: ScanMetadataRes{Fps: 2, NFrames: 4}}},
+		Cells: []memoryCell{{Scan: 1, Slice: 1, EmID: 1000, Trace: ndarray{Shape: []int{1, 4}, Data: []float64{1, 2, 3, 4}}.djBlob()}}}
+
+	previousStructural, previousFunctional := structuralStore, functionalStore
+	structuralStore, functionalStore = structural, functional
+
+	resetCaches := func() {
+		spatialIndexes.byChannel = make(map[int]*spatialIndex)
+		calibrations.byChannel = make(map[int]channelCalibration)
+		channelList.channels = nil
+		functionalGraphs.byChannel = make(map[int]FunctionalConnectomeRes)
+		movies.byScan, movies.bytes = make(map[int]*cachedMovie), 0
+	}
+	resetCaches()
+
+	t.Cleanup(func() {
+		structuralStore, functionalStore = previousStructural, previousFunctional
+		resetCaches()
+	})
+
+	return structural, functional
+}
+
+type handlerCase struct {
+	method string
+	url    string
+	body   string
+	status int
+	// the response body without its trailing newline, not checked when empty
+	want string
+}
+
+func runHandlerCases(t *testing.T, cases []handlerCase) {
+	router := newRouter()
+
+	for _, c := range cases {
+		method := c.method
+		if method == "" {
+			method = "GET"
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, c.url, strings.NewReader(c.body)))
+
+		if w.Code != c.status {
+			t.Errorf("%s %s: status %d, want %d (%s)", method, c.url, w.Code, c.status, strings.TrimSpace(w.Body.String()))
+			continue
+		}
+
+		if got := strings.TrimRight(w.Body.String(), "\n"); c.want != "" && got != c.want {
+			t.Errorf("%s %s:\n got %s\nwant %s", method, c.url, got, c.want)
+		}
+	}
+}
+
+func TestStructuralHandlers(t *testing.T) {
+	useTestStores(t)
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/is_neuron/c/e/seg/101/", status: 200, want: `{"result":true}`},
+		{url: "/is_synapse/c/e/syn/201/", status: 200, want: `{"result":true}`},
+		{url: "/is_neuron/c/e/seg/201/", status: 404},
+		{url: "/is_neuron/c/e/nope/101/", status: 404},
+
+		{url: "/neuron_keypoint/c/e/seg/0/102/", status: 200, want: `{"keypoint":[20,20,2]}`},
+		{url: "/neuron_keypoint/c/e/seg/1/102/", status: 200, want: `{"keypoint":[10,10,2]}`},
+		{url: "/neuron_keypoint/c/e/seg/0/999/", status: 404},
+
+		{url: "/neuron_ids/c/e/seg/0/0,20/0,20/0,10/?filter=bbox", status: 200, want: `{"ids":["101","102"]}`},
+		{url: "/neuron_ids/c/e/seg/0/0,20/0,20/0,10/?filter=keypoint", status: 200, want: `{"ids":["101"]}`},
+		{url: "/neuron_ids/c/e/seg/0/0/0,20/0,10/?filter=bbox", status: 400},
+
+		{url: "/synapse_parent/c/e/syn/201/", status: 200, want: `{"parent_neurons":{"101":1,"102":2}}`},
+		{url: "/neighbors/c/e/seg/102/", status: 200, want: `{"presynaptic":[101,101,104],"postsynaptic":[103]}`},
+
+		{url: "/path/c/e/seg/101/104/", status: 200,
+			want: `{"hops":[{"pre":101,"post":102,"synapses":[201,202]},{"pre":102,"post":103,"synapses":[203]},{"pre":103,"post":104,"synapses":[204]}],"length":3}`},
+		{url: "/path/c/e/seg/101/104/?weight=x", status: 400},
+
+		{method: "POST", url: "/is_neuron/c/e/seg/", body: `{"ids": [101, 201]}`, status: 200},
+		{method: "POST", url: "/is_neuron/c/e/seg/", body: `not json`, status: 400},
+	})
+}
+
+func TestChannelsHandler(t *testing.T) {
+	structural, _ := useTestStores(t)
+	structural.Calibrations = map[int]channelCalibration{2: {VoxelSize: Vector3f{8, 8, 40}, Mips: []Vector3{{1, 1, 1}, {2, 2, 1}}}}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/channels/", status: 200, want: `[` +
+			`{"id":1,"name":"c/e/seg","type":"segmentation","voxel_sets":5,"neurons":5,"synapses":0,` +
+			`"extent":{"min":{"x":5,"y":5,"z":0},"max":{"x":55,"y":55,"z":6}},` +
+			`"calibration":{"voxel_size":{"x":4,"y":4,"z":40},"origin":{"x":0,"y":0,"z":0},` +
+			// the default scheme is listed down to the mip where the extent fits in one voxel
+			`"mips":[{"x":1,"y":1,"z":1},{"x":2,"y":2,"z":1},{"x":4,"y":4,"z":1},{"x":8,"y":8,"z":1},{"x":16,"y":16,"z":1},{"x":32,"y":32,"z":1},{"x":64,"y":64,"z":1}]},"scans":[1]},` +
+			`{"id":2,"name":"c/e/syn","type":"synapse","voxel_sets":6,"neurons":0,"synapses":6,` +
+			`"extent":{"min":{"x":0,"y":0,"z":0},"max":{"x":37,"y":37,"z":5}},` +
+			`"calibration":{"voxel_size":{"x":8,"y":8,"z":40},"origin":{"x":0,"y":0,"z":0},"mips":[{"x":1,"y":1,"z":1},{"x":2,"y":2,"z":1}]},"scans":[]}]`},
+	})
+}
+
+func TestFunctionalHandlers(t *testing.T) {
+	useTestStores(t)
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/scans/", status: 200, want: `[1]`},
+		{url: "/trace_functional/1/1/1000/?format=json", status: 200, want: `[[1,2,3,4]]`},
+		{url: "/trace_functional/1/1/1000/?format=json&frames=1:3", status: 200, want: `[[2,3]]`},
+		{url: "/trace_functional/1/1/1000/?format=json&t=0.5:1", status: 200, want: `[[2]]`},
+		{url: "/trace_functional/1/1/1000/?frames=3:1", status: 400},
+		{url: "/trace_functional/1/1/1000/?format=xml", status: 400},
+		{url: "/trace/c/e/seg/1/1/101/?format=json", status: 200, want: `[[1,2,3,4]]`},
+		{url: "/trace/c/e/seg/1/1/102/", status: 404},
+		{url: "/slices_for_cell_functional/1000/", status: 200, want: `{"1":[1]}`},
+
+		{url: "/coregistered/c/e/seg/", status: 200},
+		{url: "/coregistered_neurons/3000/", status: 200},
+		{url: "/coregistered_neurons/2000/", status: 404},
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// children per r-tree node
+const rtreeCapacity = 16
+
+// rtreeNode is a node of a static r-tree, leaves are the indexed boxes themselves and have no children
+type rtreeNode struct {
+	BBox     BBox
+	ID       int
+	children []*rtreeNode
+}
+
+// newRTree bulk loads boxes with sort tile recursive packing, giving nil for no boxes
+func newRTree(leaves []*rtreeNode) *rtreeNode {
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	level := leaves
+	for len(level) > 1 || level[0].children == nil {
+		level = packRTreeLevel(level)
+	}
+
+	return level[0]
+}
+
+func center(b BBox, axis int) int {
+	switch axis {
+	case 0:
+		return b.MIN.X + b.MAX.X
+	case 1:
+		return b.MIN.Y + b.MAX.Y
+	}
+	return b.MIN.Z + b.MAX.Z
+}
+
+// packRTreeLevel groups nodes into parents of up to rtreeCapacity, tiling x, then y, then z so parents are compact
+func packRTreeLevel(nodes []*rtreeNode) []*rtreeNode {
+	parents := (len(nodes) + rtreeCapacity - 1) / rtreeCapacity
+	slabs := int(math.Ceil(math.Cbrt(float64(parents))))
+
+	res := make([]*rtreeNode, 0, parents)
+
+	var tile func(nodes []*rtreeNode, axis int)
+	tile = func(nodes []*rtreeNode, axis int) {
+		sort.Slice(nodes, func(i, j int) bool { return center(nodes[i].BBox, axis) < center(nodes[j].BBox, axis) })
+
+		if axis == 2 {
+			for start := 0; start < len(nodes); start += rtreeCapacity {
+				end := Min2(start+rtreeCapacity, len(nodes))
+				res = append(res, newRTreeParent(nodes[start:end]))
+			}
+			return
+		}
+
+		// each slab holds whole parents' worth of nodes
+		perSlab := (len(nodes) + slabs - 1) / slabs
+		perSlab = (perSlab + rtreeCapacity - 1) / rtreeCapacity * rtreeCapacity
+
+		for start := 0; start < len(nodes); start += perSlab {
+			tile(nodes[start:Min2(start+perSlab, len(nodes))], axis+1)
+		}
+	}
+
+	tile(append([]*rtreeNode{}, nodes...), 0)
+
+	return res
+}
+
+func newRTreeParent(children []*rtreeNode) *rtreeNode {
+	res := &rtreeNode{BBox: children[0].BBox, children: children}
+
+	for _, child := range children[1:] {
+		res.BBox.MIN = res.BBox.MIN.Min(child.BBox.MIN)
+		res.BBox.MAX = res.BBox.MAX.Max(child.BBox.MAX)
+	}
+
+	return res
+}
+
+// search calls fn with the id of every box overlapping region
+func (n *rtreeNode) search(region BBox, fn func(id int)) {
+	if n == nil || !n.BBox.Overlaps(region) {
+		return
+	}
+
+	if n.children == nil {
+		fn(n.ID)
+		return
+	}
+
+	for _, child := range n.children {
+		child.search(region, fn)
+	}
+}
+
+type rtreeEntry struct {
+	node *rtreeNode
+	dist float64
+}
+
+// rtreeQueue is a min heap of nodes by distance
+type rtreeQueue []rtreeEntry
+
+func (q rtreeQueue) Len() int            { return len(q) }
+func (q rtreeQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q rtreeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *rtreeQueue) Push(x interface{}) { *q = append(*q, x.(rtreeEntry)) }
+func (q *rtreeQueue) Pop() interface{} {
+	old := *q
+	res := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return res
+}
+
+// nearest calls fn with up to k leaves and their distances, closest first, none farther than maxDist
+// dist is the distance to the closest point of a box, so a parent is never farther than its children
+func (n *rtreeNode) nearest(k int, maxDist float64, dist func(BBox) float64, fn func(leaf *rtreeNode, d float64)) {
+	if n == nil {
+		return
+	}
+
+	queue := &rtreeQueue{{n, dist(n.BBox)}}
+
+	for queue.Len() > 0 && k > 0 {
+		entry := heap.Pop(queue).(rtreeEntry)
+
+		if entry.dist > maxDist {
+			return
+		}
+
+		if entry.node.children == nil {
+			fn(entry.node, entry.dist)
+			k--
+			continue
+		}
+
+		for _, child := range entry.node.children {
+			heap.Push(queue, rtreeEntry{child, dist(child.BBox)})
+		}
+	}
+}
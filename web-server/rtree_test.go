@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randomBoxes(rng *rand.Rand, n int) []BBox {
+	res := make([]BBox, n)
+	for i := range res {
+		x, y, z := rng.Intn(1000), rng.Intn(1000), rng.Intn(100)
+		res[i] = BBox{Vector3{x, y, z}, Vector3{x + rng.Intn(50), y + rng.Intn(50), z + rng.Intn(5)}}
+	}
+	return res
+}
+
+func treeOf(boxes []BBox) *rtreeNode {
+	leaves := make([]*rtreeNode, len(boxes))
+	for i, b := range boxes {
+		leaves[i] = &rtreeNode{BBox: b, ID: i}
+	}
+	return newRTree(leaves)
+}
+
+func TestRTreeSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, n := range []int{0, 1, 5, 16, 17, 300, 3000} {
+		boxes := randomBoxes(rng, n)
+		tree := treeOf(boxes)
+
+		for q := 0; q < 100; q++ {
+			x, y, z := rng.Intn(1000), rng.Intn(1000), rng.Intn(100)
+			region := BBox{Vector3{x, y, z}, Vector3{x + rng.Intn(200), y + rng.Intn(200), z + rng.Intn(20)}}
+
+			got := make([]int, 0)
+			tree.search(region, func(id int) { got = append(got, id) })
+			sort.Ints(got)
+
+			want := make([]int, 0)
+			for i, b := range boxes {
+				if b.Overlaps(region) {
+					want = append(want, i)
+				}
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("%d boxes, %v: found %d, want %d", n, region, len(got), len(want))
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("%d boxes, %v: found %v, want %v", n, region, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRTreeNearest(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	boxes := randomBoxes(rng, 500)
+	tree := treeOf(boxes)
+
+	distTo := func(p Vector3) func(BBox) float64 {
+		return func(b BBox) float64 {
+			dx, dy, dz := axisGap(p.X, b.MIN.X, b.MAX.X), axisGap(p.Y, b.MIN.Y, b.MAX.Y), axisGap(p.Z, b.MIN.Z, b.MAX.Z)
+			return math.Sqrt(dx*dx + dy*dy + dz*dz)
+		}
+	}
+
+	for q := 0; q < 50; q++ {
+		dist := distTo(Vector3{rng.Intn(1000), rng.Intn(1000), rng.Intn(100)})
+		k, maxDist := 1+rng.Intn(10), 50+rng.Float64()*200
+
+		got := make([]float64, 0)
+		tree.nearest(k, maxDist, dist, func(leaf *rtreeNode, d float64) {
+			if d != dist(boxes[leaf.ID]) {
+				t.Fatalf("leaf %d given distance %v, want %v", leaf.ID, d, dist(boxes[leaf.ID]))
+			}
+			got = append(got, d)
+		})
+
+		want := make([]float64, 0)
+		for _, b := range boxes {
+			if d := dist(b); d <= maxDist {
+				want = append(want, d)
+			}
+		}
+		sort.Float64s(want)
+		if len(want) > k {
+			want = want[:k]
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("k %d within %v: found %d, want %d", k, maxDist, len(got), len(want))
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("k %d within %v: distances %v, want %v", k, maxDist, got, want)
+			}
+		}
+	}
+
+	var empty *rtreeNode
+	empty.nearest(1, math.Inf(1), func(BBox) float64 { return 0 }, func(*rtreeNode, float64) {
+		t.Error("empty tree found a leaf")
+	})
+}
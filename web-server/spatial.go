@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// spatialIndex is a pair of r-trees over the voxel sets of one channel, at full resolution
+type spatialIndex struct {
+	once      sync.Once
+	err       error
+	bboxes    *rtreeNode
+	keypoints *rtreeNode // leaves are single voxel boxes
+}
+
+// spatial indexes of each channel, built the first time a channel is queried
+// they're never refreshed, a restart picks up voxel sets imported since
+var spatialIndexes = struct {
+	sync.Mutex
+	byChannel map[int]*spatialIndex
+}{byChannel: make(map[int]*spatialIndex)}
+
+// getSpatialIndex builds each channel's index once however many requests ask for it at the same time
+// the lock is only held to find the entry, so a build never holds up queries of other channels
+func getSpatialIndex(channelID int) (*spatialIndex, error) {
+	spatialIndexes.Lock()
+	index, ok := spatialIndexes.byChannel[channelID]
+	if !ok {
+		index = &spatialIndex{}
+		spatialIndexes.byChannel[channelID] = index
+	}
+	spatialIndexes.Unlock()
+
+	index.once.Do(func() {
+		index.bboxes, index.keypoints, index.err = buildSpatialIndex(channelID)
+
+		// failures aren't kept so the next request tries again
+		if index.err != nil {
+			spatialIndexes.Lock()
+			if spatialIndexes.byChannel[channelID] == index {
+				delete(spatialIndexes.byChannel, channelID)
+			}
+			spatialIndexes.Unlock()
+		}
+	})
+
+	if index.err != nil {
+		return nil, index.err
+	}
+
+	return index, nil
+}
+
+// buildSpatialIndex reads every voxel set of the channel into the bbox and keypoint trees
+func buildSpatialIndex(channelID int) (*rtreeNode, *rtreeNode, error) {
+	bboxes := make([]*rtreeNode, 0)
+	keypoints := make([]*rtreeNode, 0)
+
+	err := structuralStore.EachVoxelSet(channelID, func(bossID int, bbox BBox, keypoint Vector3) error {
+		bboxes = append(bboxes, &rtreeNode{BBox: bbox, ID: bossID})
+		keypoints = append(keypoints, &rtreeNode{BBox: BBox{keypoint, keypoint}, ID: bossID})
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newRTree(bboxes), newRTree(keypoints), nil
+}
+
+// getIdsOverlapping is every voxel set in the channel whose bbox overlaps region, at full resolution
+func getIdsOverlapping(channelID int, region BBox) (IdsInRegionRes, error) {
+	res := IdsInRegionRes{Ids: make([]string, 0)}
+
+	index, err := getSpatialIndex(channelID)
+	if err != nil {
+		return res, err
+	}
+
+	ids := make([]int, 0)
+	index.bboxes.search(region, func(id int) { ids = append(ids, id) })
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		res.Ids = append(res.Ids, strconv.Itoa(id))
+	}
+
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingStructuralStore holds up reading one channel's voxel sets until release is closed
+type blockingStructuralStore struct {
+	*memoryStructuralStore
+	channel int
+	started chan bool
+	release chan bool
+	err     error
+}
+
+func (s *blockingStructuralStore) EachVoxelSet(channelID int, fn func(bossID int, bbox BBox, keypoint Vector3) error) error {
+	if channelID == s.channel {
+		s.started <- true
+		<-s.release
+	}
+
+	if s.err != nil {
+		return s.err
+	}
+
+	return s.memoryStructuralStore.EachVoxelSet(channelID, fn)
+}
+
+func TestSpatialIndexPerChannel(t *testing.T) {
+	structural, _ := useTestStores(t)
+	blocking := &blockingStructuralStore{memoryStructuralStore: structural, channel: 1,
+		started: make(chan bool), release: make(chan bool)}
+	structuralStore = blocking
+
+	done := make(chan error)
+	go func() {
+		_, err := getIdsOverlapping(1, BBox{Vector3{0, 0, 0}, Vector3{100, 100, 100}})
+		done <- err
+	}()
+	<-blocking.started
+
+	// channel 2 is built while channel 1 is still being read
+	res, err := getIdsOverlapping(2, BBox{Vector3{0, 0, 0}, Vector3{2, 2, 0}})
+	if err != nil || len(res.Ids) != 1 || res.Ids[0] != "201" {
+		t.Errorf("channel 2 gave %v %v while channel 1 was building", res.Ids, err)
+	}
+
+	// a second request for channel 1 waits on the first build instead of starting another
+	second := make(chan error)
+	go func() {
+		_, err := getSpatialIndex(1)
+		second <- err
+	}()
+
+	select {
+	case <-blocking.started:
+		t.Fatal("channel 1 was read twice")
+	case <-second:
+		t.Fatal("channel 1 answered before it was built")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(blocking.release)
+
+	for _, c := range []chan error{done, second} {
+		if err := <-c; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSpatialIndexRetry(t *testing.T) {
+	structural, _ := useTestStores(t)
+	release := make(chan bool)
+	close(release)
+
+	blocking := &blockingStructuralStore{memoryStructuralStore: structural, channel: -1, release: release,
+		err: errors.New("lost connection")}
+	structuralStore = blocking
+
+	if _, err := getSpatialIndex(1); err != blocking.err {
+		t.Fatalf("got %v, want the store's error", err)
+	}
+
+	// the failure isn't kept
+	blocking.err = nil
+
+	index, err := getSpatialIndex(1)
+	if err != nil || index.bboxes == nil {
+		t.Errorf("got %v %v after the store recovered", index, err)
+	}
+}
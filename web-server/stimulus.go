@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var errBadDownsample = errors.New("downsample should be a positive integer")
+var errNoFrame = errors.New("no such stimulus frame")
+var errNotMovie = errors.New("stimulus isn't a height x width x frames movie")
+
+// maxMovieCacheBytes bounds the decoded movies kept between requests, the least recently used go first
+const maxMovieCacheBytes = 1 << 30
+
+type cachedMovie struct {
+	once  sync.Once
+	movie blobArray
+	err   error
+	bytes int // counted against maxMovieCacheBytes once decoded, 0 until then
+	used  int // movies.clock when last asked for
+}
+
+// decoded stimulus movies, so every frame of a scan doesn't decode the whole movie again
+// callers share the cached array and mustn't modify it
+var movies = struct {
+	sync.Mutex
+	byScan map[int]*cachedMovie
+	bytes  int
+	clock  int
+}{byScan: make(map[int]*cachedMovie)}
+
+// getMovie is decodeMovie kept in memory, each scan is decoded once however many requests ask for it at the same time
+func getMovie(scanID int) (blobArray, error) {
+	movies.Lock()
+	entry, ok := movies.byScan[scanID]
+	if !ok {
+		entry = &cachedMovie{}
+		movies.byScan[scanID] = entry
+	}
+	movies.clock++
+	entry.used = movies.clock
+	movies.Unlock()
+
+	entry.once.Do(func() {
+		entry.movie, entry.err = decodeMovie(scanID)
+
+		movies.Lock()
+		defer movies.Unlock()
+
+		// failures aren't kept so the next request tries again, and a movie too big to keep is decoded each time
+		if entry.err != nil || len(entry.movie.Data) > maxMovieCacheBytes {
+			if movies.byScan[scanID] == entry {
+				delete(movies.byScan, scanID)
+			}
+			return
+		}
+
+		entry.bytes = len(entry.movie.Data)
+		movies.bytes += entry.bytes
+
+		for movies.bytes > maxMovieCacheBytes {
+			var oldestScan int
+			var oldest *cachedMovie
+
+			for scan, other := range movies.byScan {
+				if other != entry && other.bytes > 0 && (oldest == nil || other.used < oldest.used) {
+					oldestScan, oldest = scan, other
+				}
+			}
+
+			delete(movies.byScan, oldestScan)
+			movies.bytes -= oldest.bytes
+		}
+	})
+
+	return entry.movie, entry.err
+}
+
+// decodeMovie decodes the stimulus movie of a scan, from ./cache/stimulus if it's there
+func decodeMovie(scanID int) (blobArray, error) {
+	blob, err := ioutil.ReadFile(fmt.Sprintf("./cache/stimulus/%d", scanID))
+
+	if os.IsNotExist(err) {
+		blob, err = functionalStore.GetStimulus(scanID)
+	}
+
+	if err != nil {
+		return blobArray{}, err
+	}
+
+	movie, err := decodeArray(blob)
+	if err != nil {
+		return movie, err
+	}
+
+	// matlab drops trailing singleton dimensions, so a one frame movie is 2d
+	if len(movie.Shape) == 2 {
+		movie.Shape = append(movie.Shape, 1)
+	}
+
+	if len(movie.Shape) != 3 {
+		return movie, errNotMovie
+	}
+
+	return movie, nil
+}
+
+// parseDownsample reads ?downsample=, the factor both image dimensions are shrunk by
+func parseDownsample(r *http.Request) (int, error) {
+	factor := r.URL.Query().Get("downsample")
+	if factor == "" {
+		return 1, nil
+	}
+
+	res, err := strconv.Atoi(factor)
+	if err != nil || res < 1 {
+		return 0, errBadDownsample
+	}
+
+	return res, nil
+}
+
+// downsample averages factor x factor blocks of the first two dimensions, partial blocks at the edges included
+// uint8 movies stay uint8, anything else comes out as double
+func (a blobArray) downsample(factor int) blobArray {
+	if factor == 1 {
+		return a
+	}
+
+	height, width := a.Shape[0], a.Shape[1]
+	frames := a.len() / (height * width)
+
+	outHeight := (height + factor - 1) / factor
+	outWidth := (width + factor - 1) / factor
+
+	sums := make([]float64, outHeight*outWidth*frames)
+	counts := make([]float64, outHeight*outWidth)
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			counts[y/factor+x/factor*outHeight]++
+		}
+	}
+
+	for f := 0; f < frames; f++ {
+		for x := 0; x < width; x++ {
+			for y := 0; y < height; y++ {
+				sums[y/factor+x/factor*outHeight+f*outHeight*outWidth] += a.at(y + x*height + f*height*width)
+			}
+		}
+	}
+
+	shape := append([]int{outHeight, outWidth}, a.Shape[2:]...)
+
+	if a.Class == 9 {
+		data := make([]byte, len(sums))
+		for i, sum := range sums {
+			data[i] = byte(math.Round(sum / counts[i%len(counts)]))
+		}
+		return blobArray{Shape: shape, Class: a.Class, Data: data}
+	}
+
+	data := make([]byte, 8*len(sums))
+	for i, sum := range sums {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(sum/counts[i%len(counts)]))
+	}
+
+	return blobArray{Shape: shape, Class: mxDoubleClass, Data: data}
+}
+
+// frameImage is frame n of a movie as a grayscale image, values are clamped to 0-255
+func (a blobArray) frameImage(n int) (*image.Gray, error) {
+	if n < 0 || n >= a.Shape[2] {
+		return nil, errNoFrame
+	}
+
+	height, width := a.Shape[0], a.Shape[1]
+	frame := a.framesAlong(2, frameWindow{Start: n, Stop: n + 1})
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Pix[y*img.Stride+x] = uint8(math.Max(0, math.Min(255, math.Round(frame.at(y+x*height)))))
+		}
+	}
+
+	return img, nil
+}
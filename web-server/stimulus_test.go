@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+)
+
+// testMovie is height x width x frames with pixel x, y of frame f at 100 f + 10 y + x
+func testMovie(height int, width int, frames int) []byte {
+	movie := newNdarray(height, width, frames)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for f := 0; f < frames; f++ {
+				movie.Data[(y*width+x)*frames+f] = float64(100*f + 10*y + x)
+			}
+		}
+	}
+
+	return movie.djBlob()
+}
+
+// useMovie gives scan 1 of useTestStores a 2 x 3 movie of 3 frames
+func useMovie(t *testing.T) *memoryFunctionalStore {
+	_, functional := useTestStores(t)
+
+	scan := functional.Scans[1]
+	scan.Stimulus = testMovie(2, 3, 3)
+	functional.Scans[1] = scan
+
+	return functional
+}
+
+func servePNG(t *testing.T, url string) [][]uint8 {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+
+	if w.Code != 200 {
+		t.Fatalf("%s: status %d", url, w.Code)
+	}
+
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("%s: %v", url, err)
+	}
+
+	// rows of gray values, top to bottom
+	bounds := img.Bounds()
+	res := make([][]uint8, bounds.Dy())
+
+	for y := range res {
+		for x := 0; x < bounds.Dx(); x++ {
+			r, _, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			res[y] = append(res[y], uint8(r>>8))
+		}
+	}
+
+	return res
+}
+
+func TestStimulusFrame(t *testing.T) {
+	useMovie(t)
+
+	// rows are the movie's first dimension and columns its second
+	if got := servePNG(t, "/stimulus/1/frame/1"); len(got) != 2 || string(got[0]) != string([]uint8{100, 101, 102}) || string(got[1]) != string([]uint8{110, 111, 112}) {
+		t.Errorf("frame 1 is %v", got)
+	}
+
+	// 2 x 2 blocks are averaged, the partial block at the edge included
+	if got := servePNG(t, "/stimulus/1/frame/1?downsample=2"); len(got) != 1 || string(got[0]) != string([]uint8{106, 107}) {
+		t.Errorf("downsampled frame 1 is %v", got)
+	}
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/stimulus/1/frame/3", status: 404},
+		{url: "/stimulus/1/frame/-1", status: 404},
+		{url: "/stimulus/1/frame/x", status: 400},
+		{url: "/stimulus/1/frame/0?downsample=0", status: 400},
+		{url: "/stimulus/2/frame/0", status: 404},
+
+		{url: "/stimulus/1/clip/?frames=1:3&format=json", status: 200, want: `[[[100,200],[101,201],[102,202]],[[110,210],[111,211],[112,212]]]`},
+		// double movies stay double when downsampled
+		{url: "/stimulus/1/clip/?frames=2:3&downsample=2&format=json", status: 200, want: `[[[205.5],[207]]]`},
+	})
+}
+
+func TestStimulusMovieCache(t *testing.T) {
+	functional := useMovie(t)
+	servePNG(t, "/stimulus/1/frame/0")
+
+	// later frames come from the decoded movie, not the store
+	scan := functional.Scans[1]
+	scan.Stimulus = testMovie(2, 3, 1)
+	functional.Scans[1] = scan
+
+	if got := servePNG(t, "/stimulus/1/frame/2"); got[0][0] != 200 {
+		t.Errorf("frame 2 is %v, want the cached movie", got)
+	}
+
+	// a movie that fails to decode isn't kept
+	functional.Scans[2] = memoryScan{Stimulus: []byte("mYm\x00A")}
+	runHandlerCases(t, []handlerCase{{url: "/stimulus/2/frame/0", status: 500}})
+
+	functional.Scans[2] = memoryScan{Stimulus: testMovie(1, 1, 1)}
+	runHandlerCases(t, []handlerCase{{url: "/stimulus/2/frame/0", status: 200}})
+}
@@ -0,0 +1,167 @@
+package main
+
+import "strings"
+
+// StructuralStore is every query the handlers make against the structural (EM) database
+type StructuralStore interface {
+	GetChannelFromString(name string) (int, error)
+	// defaultCalibration for channels without a channel_calibration row
+	GetChannelCalibration(channelID int) (channelCalibration, error)
+	// every channel with its counts and extent filled in, by id
+	GetChannels() ([]ChannelRes, error)
+	// the distinct em_ids of the channel's neurons
+	GetChannelEmIDs(channelID int) ([]int, error)
+	IsSynapse(bossID int, channelID int) (bool, error)
+	IsNeuron(bossID int, channelID int) (bool, error)
+	GetBBox(bossID int, channelID int) (BBox, error)
+	GetKeypoint(bossID int, channelID int) (Vector3, error)
+	GetKeypointsInRegion(channelID int, region BBox) (IdsInRegionRes, error)
+	// every voxel set of a channel with its bbox and keypoint, for building the spatial indexes
+	EachVoxelSet(channelID int, fn func(bossID int, bbox BBox, keypoint Vector3) error) error
+	GetSynapseParents(synapseID int, channelID int) (int, int, error)
+	// the batch variants leave ids that aren't found out of the result
+	IsSynapseBatch(bossIDs []int, channelID int) (map[int]bool, error)
+	IsNeuronBatch(bossIDs []int, channelID int) (map[int]bool, error)
+	GetBBoxBatch(bossIDs []int, channelID int) (map[int]BBox, error)
+	GetKeypointBatch(bossIDs []int, channelID int) (map[int]Vector3, error)
+	GetSynapseParentsBatch(synapseIDs []int, channelID int) (map[int][2]int, error)
+	GetNeuronID(bossID int, channelID int) (int, error)
+	GetNeighbors(neuronID int, pre bool, functionalOnly bool) ([]int, error)
+	GetSynapticEdges(neuronIDs []int, pre bool, functionalOnly bool) ([]synapticEdge, error)
+	GetSynapsesBetween(neuronIDs []int) ([]synapseLink, error)
+	GetInducedSynapses(bossIDs []int, channelID int) ([]inducedSynapse, error)
+	EachNeuron(channelID int, fn func(graphNode) error) error
+	// EachNeuron for only the neurons with an em_id
+	EachCoregisteredNeuron(channelID int, fn func(graphNode) error) error
+	EachSynapticEdge(channelID int, fn func(synapticEdge) error) error
+	// EachSynapticEdge for only the edges between neurons with an em_id
+	EachCoregisteredEdge(channelID int, fn func(synapticEdge) error) error
+	GetNeuronSynapses(neuronID int) ([]neuronSynapse, error)
+	GetFunctionalID(bossID int, channelID int) (int, error)
+	GetFunctionalIDBatch(bossIDs []int, channelID int) (map[int]int, error)
+	// every neuron with the em_id, in any channel
+	GetCoregisteredNeurons(emID int) ([]coregisteredNeuron, error)
+}
+
+// FunctionalStore is every query the handlers make against the functional (calcium imaging) database
+type FunctionalStore interface {
+	GetScans() ([]int, error)
+	GetScanMetadata(scanID int) (ScanMetadataRes, error)
+	GetPupilR(scanID int) ([]byte, error)
+	GetPupilX(scanID int) ([]byte, error)
+	GetPupilY(scanID int) ([]byte, error)
+	GetStimulus(scanID int) ([]byte, error)
+	GetStimulusConditions(scanID int) ([]byte, error)
+	GetTreadmill(scanID int) ([]byte, error)
+	GetSliceOffset(scanID int, slice int) (int, error)
+	GetTrace(scanID int, slice int, cellID int) ([]byte, error)
+	GetSpike(scanID int, slice int, cellID int) ([]byte, error)
+	GetMask(scanID int, slice int, cellID int) ([]byte, error)
+	// GetMask without fetching mask_pixels, sql.ErrNoRows if the cell has no mask in the slice
+	HasMask(scanID int, slice int, cellID int) (bool, error)
+	GetSlicesForCell(cellID int) (map[string][]int, error)
+	// GetSlicesForCell for many cells, cells without a mask are left out
+	GetSlicesForCells(cellIDs []int) (map[int]map[string][]int, error)
+	// the scans with a mask for any of the cells, ascending
+	GetScansForCells(cellIDs []int) ([]int, error)
+	// slice can be allSlices
+	GetTraceBatch(scanID int, slice int, cellIDs []int) ([]cellData, error)
+	GetSpikeBatch(scanID int, slice int, cellIDs []int) ([]cellData, error)
+	// every cell's mask, ordered by em_id, slice can be allSlices
+	GetSliceMasks(scanID int, slice int) ([]cellData, error)
+	// GetSliceMasks without the pixels, just the distinct em_ids ascending
+	GetSliceCells(scanID int, slice int) ([]int, error)
+}
+
+var structuralStore StructuralStore
+var functionalStore FunctionalStore
+
+const allSlices = -1
+
+// coregisteredNeuron is the structural side of a functional cell, keypoint and bbox at full resolution
+type coregisteredNeuron struct {
+	Channel  string  `json:"channel"`
+	BossID   int     `json:"boss_id"`
+	EmID     int     `json:"em_id"`
+	Keypoint Vector3 `json:"keypoint"`
+	BBox     BBox    `json:"bbox"`
+}
+
+// cellData is one cell's blob from a per cell table (trace, __spike or mask)
+type cellData struct {
+	Slice int
+	EmID  int
+	Data  []byte
+}
+
+// neuronSynapse is a synapse touching a neuron, as needed to decide if it lies in a region
+type neuronSynapse struct {
+	BossID  int
+	Pre     int
+	BBox    BBox
+	Channel string
+}
+
+// synapticEdge is every synapse from one neuron onto another, ids are neuron.id with the boss ids alongside
+type synapticEdge struct {
+	Pre        int
+	Post       int
+	PreBossID  int
+	PostBossID int
+	Synapses   int
+	// Size is the summed voxel_set size of the synapses
+	Size int
+}
+
+// synapseLink is one synapse by boss id with its pre and post neuron.id
+type synapseLink struct {
+	BossID int
+	Pre    int
+	Post   int
+}
+
+// graphNode is a neuron with the voxel_set columns exported alongside it, nil pointers are null columns
+type graphNode struct {
+	BossID   int
+	EmID     *int
+	Size     *int
+	Keypoint Vector3
+	BBox     BBox
+}
+
+// inducedSynapse is one synapse between two neurons of a set, all ids are boss ids
+type inducedSynapse struct {
+	Synapse int
+	Pre     int
+	Post    int
+}
+
+// keep IN (...) lists to a size mysql handles comfortably
+const maxIdsPerQuery = 1000
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func chunkIds(ids []int) [][]int {
+	chunks := make([][]int, 0, len(ids)/maxIdsPerQuery+1)
+
+	for len(ids) > maxIdsPerQuery {
+		chunks = append(chunks, ids[:maxIdsPerQuery])
+		ids = ids[maxIdsPerQuery:]
+	}
+
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+
+	return chunks
+}
+
+func intArgs(ids []int) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
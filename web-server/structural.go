@@ -1,21 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/parnurzeal/gorequest"
 )
 
-var structuralDb *sql.DB
+// mysqlStructuralStore is the StructuralStore backed by the structural MySQL database
+type mysqlStructuralStore struct {
+	db *sql.DB
+}
 
-func isSynapse(bossID int, channelID int) (bool, error) {
+func (s *mysqlStructuralStore) IsSynapse(bossID int, channelID int) (bool, error) {
 	var exists bool
-	err := structuralDb.QueryRow("SELECT 1 FROM synapse, voxel_set WHERE synapse.voxel_set = voxel_set.id AND voxel_set.boss_vset_id=? and voxel_set.channel=?", bossID, channelID).Scan(&exists)
+	err := s.db.QueryRow("SELECT 1 FROM synapse, voxel_set WHERE synapse.voxel_set = voxel_set.id AND voxel_set.boss_vset_id=? and voxel_set.channel=?", bossID, channelID).Scan(&exists)
 
 	if err == sql.ErrNoRows {
 		err = nil
@@ -24,14 +26,14 @@ func isSynapse(bossID int, channelID int) (bool, error) {
 	return exists, err
 }
 
-func isNeuron(bossID int, channelID int) (bool, error) {
+func (s *mysqlStructuralStore) IsNeuron(bossID int, channelID int) (bool, error) {
 	var exists bool
-	err := structuralDb.QueryRow("SELECT 1 FROM neuron, voxel_set WHERE neuron.voxel_set = voxel_set.id AND voxel_set.boss_vset_id=? and voxel_set.channel=?", bossID, channelID).Scan(&exists)
+	err := s.db.QueryRow("SELECT 1 FROM neuron, voxel_set WHERE neuron.voxel_set = voxel_set.id AND voxel_set.boss_vset_id=? and voxel_set.channel=?", bossID, channelID).Scan(&exists)
 
 	return exists, err
 }
 
-func getBBox(bossID int, channelID int) (BBox, error) {
+func (s *mysqlStructuralStore) GetBBox(bossID int, channelID int) (BBox, error) {
 	var res = BBox{
 		MIN: Vector3{
 			X: 0,
@@ -41,7 +43,7 @@ func getBBox(bossID int, channelID int) (BBox, error) {
 			X: 0,
 			Y: 0,
 			Z: 0}}
-	err := structuralDb.QueryRow("SELECT x_min, y_min, z_min, x_max, y_max, z_max FROM voxel_set WHERE voxel_set.boss_vset_id=? and voxel_set.channel=?", bossID, channelID).Scan(
+	err := s.db.QueryRow("SELECT x_min, y_min, z_min, x_max, y_max, z_max FROM voxel_set WHERE voxel_set.boss_vset_id=? and voxel_set.channel=?", bossID, channelID).Scan(
 		&res.MIN.X, &res.MIN.Y, &res.MIN.Z,
 		&res.MAX.X, &res.MAX.Y, &res.MAX.Z)
 
@@ -55,27 +57,201 @@ func channelString(ps httprouter.Params) string {
 		ps.ByName("layer"))
 }
 
-func getChannelFromString(s string) (int, error) {
+func (s *mysqlStructuralStore) GetChannelFromString(name string) (int, error) {
 	var channelID int
-	err := structuralDb.QueryRow("SELECT id FROM channel where name=?", s).Scan(&channelID)
+	err := s.db.QueryRow("SELECT id FROM channel where name=?", name).Scan(&channelID)
 	return channelID, err
 }
 
+func (s *mysqlStructuralStore) GetChannelCalibration(channelID int) (channelCalibration, error) {
+	res := defaultCalibration
+
+	err := s.db.QueryRow(`
+	SELECT
+		voxel_size_x, voxel_size_y, voxel_size_z,
+		origin_x, origin_y, origin_z
+	FROM
+		channel_calibration
+	WHERE
+		channel = ?
+	`, channelID).Scan(
+		&res.VoxelSize.X, &res.VoxelSize.Y, &res.VoxelSize.Z,
+		&res.Origin.X, &res.Origin.Y, &res.Origin.Z)
+
+	if err != nil && err != sql.ErrNoRows {
+		return res, err
+	}
+
+	rows, err := s.db.Query(`
+	SELECT
+		mip, downsample_x, downsample_y, downsample_z
+	FROM
+		channel_mip
+	WHERE
+		channel = ?
+	ORDER BY
+		mip
+	`, channelID)
+
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mip int
+		var scale Vector3
+
+		if err := rows.Scan(&mip, &scale.X, &scale.Y, &scale.Z); err != nil {
+			return res, err
+		}
+
+		if mip != len(res.Mips) {
+			return res, fmt.Errorf("channel %d is missing mip %d", channelID, len(res.Mips))
+		}
+
+		res.Mips = append(res.Mips, scale)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *mysqlStructuralStore) GetChannels() ([]ChannelRes, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		channel.id, channel.name,
+		COUNT(DISTINCT voxel_set.id),
+		COUNT(DISTINCT neuron.id),
+		COUNT(DISTINCT synapse.id),
+		MIN(voxel_set.x_min), MIN(voxel_set.y_min), MIN(voxel_set.z_min),
+		MAX(voxel_set.x_max), MAX(voxel_set.y_max), MAX(voxel_set.z_max)
+	FROM
+		channel
+		LEFT JOIN voxel_set ON voxel_set.channel = channel.id
+		LEFT JOIN neuron ON neuron.voxel_set = voxel_set.id
+		LEFT JOIN synapse ON synapse.voxel_set = voxel_set.id
+	GROUP BY
+		channel.id, channel.name
+	ORDER BY
+		channel.id
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]ChannelRes, 0)
+
+	for rows.Next() {
+		var channel ChannelRes
+		var extent [6]sql.NullInt64
+
+		err := rows.Scan(&channel.ID, &channel.Name, &channel.VoxelSets, &channel.Neurons, &channel.Synapses,
+			&extent[0], &extent[1], &extent[2], &extent[3], &extent[4], &extent[5])
+
+		if err != nil {
+			return nil, err
+		}
+
+		// the bounds are null for a channel without voxel sets
+		if extent[0].Valid {
+			channel.Extent = &BBox{
+				MIN: Vector3{int(extent[0].Int64), int(extent[1].Int64), int(extent[2].Int64)},
+				MAX: Vector3{int(extent[3].Int64), int(extent[4].Int64), int(extent[5].Int64)}}
+		}
+
+		res = append(res, channel)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *mysqlStructuralStore) GetChannelEmIDs(channelID int) ([]int, error) {
+	rows, err := s.db.Query(`
+	SELECT DISTINCT
+		neuron.em_id
+	FROM
+		neuron,
+		voxel_set
+	WHERE
+		neuron.voxel_set = voxel_set.id
+		AND voxel_set.channel = ?
+		AND neuron.em_id IS NOT NULL
+	`, channelID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]int, 0)
+
+	for rows.Next() {
+		var emID int
+
+		if err := rows.Scan(&emID); err != nil {
+			return nil, err
+		}
+
+		res = append(res, emID)
+	}
+
+	return res, rows.Err()
+}
+
 func getChannel(ps httprouter.Params) (int, error) {
-	return getChannelFromString(channelString(ps))
+	return structuralStore.GetChannelFromString(channelString(ps))
 }
 
-func getKeypoint(bossID int, channelID int) (Vector3, error) {
+func (s *mysqlStructuralStore) GetKeypoint(bossID int, channelID int) (Vector3, error) {
 	var res = Vector3{}
-	err := structuralDb.QueryRow("SELECT key_point_x, key_point_y, key_point_z FROM voxel_set WHERE voxel_set.boss_vset_id=? and voxel_set.channel = ?", bossID, channelID).Scan(&res.X, &res.Y, &res.Z)
+	err := s.db.QueryRow("SELECT key_point_x, key_point_y, key_point_z FROM voxel_set WHERE voxel_set.boss_vset_id=? and voxel_set.channel = ?", bossID, channelID).Scan(&res.X, &res.Y, &res.Z)
 	return res, err
 }
 
-func getKeypointsInRegion(channelID int, region BBox) (IdsInRegionRes, error) {
+func (s *mysqlStructuralStore) EachVoxelSet(channelID int, fn func(bossID int, bbox BBox, keypoint Vector3) error) error {
+	rows, err := s.db.Query(`
+	SELECT
+		boss_vset_id,
+		x_min, y_min, z_min,
+		x_max, y_max, z_max,
+		key_point_x, key_point_y, key_point_z
+	FROM
+		voxel_set
+	WHERE
+		channel = ?
+	`, channelID)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bossID int
+		var bbox BBox
+		var keypoint Vector3
+
+		err := rows.Scan(&bossID, &bbox.MIN.X, &bbox.MIN.Y, &bbox.MIN.Z, &bbox.MAX.X, &bbox.MAX.Y, &bbox.MAX.Z,
+			&keypoint.X, &keypoint.Y, &keypoint.Z)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(bossID, bbox, keypoint); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *mysqlStructuralStore) GetKeypointsInRegion(channelID int, region BBox) (IdsInRegionRes, error) {
 
 	var res IdsInRegionRes
 
-	rows, err := structuralDb.Query(`
+	rows, err := s.db.Query(`
 	SELECT
 		boss_vset_id
 	FROM
@@ -93,6 +269,7 @@ func getKeypointsInRegion(channelID int, region BBox) (IdsInRegionRes, error) {
 	if err != nil {
 		return res, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var id int
@@ -108,70 +285,172 @@ func getKeypointsInRegion(channelID int, region BBox) (IdsInRegionRes, error) {
 	return res, nil
 }
 
-func getSynapseParents(synapseID int, channelID int) (int, int, error) {
-	var pre int
-	var post int
+// queryChunks runs query once per chunk of ids, the chunk fills the IN (%s) of the query after args
+func (s *mysqlStructuralStore) queryChunks(query string, args []interface{}, ids []int, scan func(*sql.Rows) error) error {
+	for _, chunk := range chunkIds(ids) {
+		rows, err := s.db.Query(fmt.Sprintf(query, placeholders(len(chunk))), append(args, intArgs(chunk)...)...)
 
-	err := structuralDb.QueryRow(`
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *mysqlStructuralStore) IsSynapseBatch(bossIDs []int, channelID int) (map[int]bool, error) {
+	res := make(map[int]bool)
+
+	err := s.queryChunks(`
 	SELECT
-		(SELECT boss_vset_id from voxel_set, neuron where neuron.id = synapse.pre AND voxel_set.id = neuron.voxel_set) as pre,
-		(SELECT boss_vset_id from voxel_set, neuron where neuron.id = synapse.post AND voxel_set.id = neuron.voxel_set) as post
+		boss_vset_id
 	FROM
 		synapse, voxel_set
 	WHERE
 		synapse.voxel_set = voxel_set.id
-		AND voxel_set.boss_vset_id=?
-		AND voxel_set.channel=?`, synapseID, channelID).Scan(&pre, &post)
+		AND voxel_set.channel = ?
+		AND voxel_set.boss_vset_id IN (%s)
+	`, []interface{}{channelID}, bossIDs, func(rows *sql.Rows) error {
+		var id int
+		err := rows.Scan(&id)
+		res[id] = true
+		return err
+	})
 
-	return pre, post, err
+	return res, err
 }
 
-func idInRegion(id int, channel string, region BBox, idBbox BBox, resolution uint64, filterByKeypoint bool) (bool, error) {
-	if filterByKeypoint {
-		channelID, err := getChannelFromString(channel)
-		if err != nil {
-			return false, err
-		}
+func (s *mysqlStructuralStore) IsNeuronBatch(bossIDs []int, channelID int) (map[int]bool, error) {
+	res := make(map[int]bool)
 
-		keypoint, err := getKeypoint(id, channelID)
+	err := s.queryChunks(`
+	SELECT
+		boss_vset_id
+	FROM
+		neuron, voxel_set
+	WHERE
+		neuron.voxel_set = voxel_set.id
+		AND voxel_set.channel = ?
+		AND voxel_set.boss_vset_id IN (%s)
+	`, []interface{}{channelID}, bossIDs, func(rows *sql.Rows) error {
+		var id int
+		err := rows.Scan(&id)
+		res[id] = true
+		return err
+	})
 
-		if err != nil {
-			return false, err
-		}
+	return res, err
+}
 
-		return keypoint.Inside(region), nil
-	}
+func (s *mysqlStructuralStore) GetBBoxBatch(bossIDs []int, channelID int) (map[int]BBox, error) {
+	res := make(map[int]BBox)
 
-	if idBbox.Inside(region) {
-		return true, nil
-	}
+	err := s.queryChunks(`
+	SELECT
+		boss_vset_id, x_min, y_min, z_min, x_max, y_max, z_max
+	FROM
+		voxel_set
+	WHERE
+		voxel_set.channel = ?
+		AND voxel_set.boss_vset_id IN (%s)
+	`, []interface{}{channelID}, bossIDs, func(rows *sql.Rows) error {
+		var id int
+		var bbox BBox
+		err := rows.Scan(&id,
+			&bbox.MIN.X, &bbox.MIN.Y, &bbox.MIN.Z,
+			&bbox.MAX.X, &bbox.MAX.Y, &bbox.MAX.Z)
+		res[id] = bbox
+		return err
+	})
 
-	// otherwise check each voxel in intersection
-	intersection, err := idBbox.Intersection(region)
+	return res, err
+}
 
-	if err != nil {
-		return false, nil // no overlap, skip
-	}
+func (s *mysqlStructuralStore) GetKeypointBatch(bossIDs []int, channelID int) (map[int]Vector3, error) {
+	res := make(map[int]Vector3)
 
-	ids, err := getUniqueIdsInRegion(channel, intersection, resolution)
+	err := s.queryChunks(`
+	SELECT
+		boss_vset_id, key_point_x, key_point_y, key_point_z
+	FROM
+		voxel_set
+	WHERE
+		voxel_set.channel = ?
+		AND voxel_set.boss_vset_id IN (%s)
+	`, []interface{}{channelID}, bossIDs, func(rows *sql.Rows) error {
+		var id int
+		var keypoint Vector3
+		err := rows.Scan(&id, &keypoint.X, &keypoint.Y, &keypoint.Z)
+		res[id] = keypoint
+		return err
+	})
 
-	if err != nil {
-		return false, err
-	}
+	return res, err
+}
 
-	for _, bossIDStr := range ids.Ids {
-		bossID, parseError := strconv.Atoi(bossIDStr)
+func (s *mysqlStructuralStore) GetSynapseParentsBatch(synapseIDs []int, channelID int) (map[int][2]int, error) {
+	res := make(map[int][2]int)
 
-		if parseError != nil {
-			return false, parseError
-		}
+	err := s.queryChunks(`
+	SELECT
+		synapse_vset.boss_vset_id,
+		pre_vset.boss_vset_id,
+		post_vset.boss_vset_id
+	FROM
+		synapse,
+		voxel_set synapse_vset,
+		neuron pre_neuron,
+		voxel_set pre_vset,
+		neuron post_neuron,
+		voxel_set post_vset
+	WHERE
+		synapse.voxel_set = synapse_vset.id
+		AND synapse.pre = pre_neuron.id
+		AND pre_neuron.voxel_set = pre_vset.id
+		AND synapse.post = post_neuron.id
+		AND post_neuron.voxel_set = post_vset.id
+		AND synapse_vset.channel = ?
+		AND synapse_vset.boss_vset_id IN (%s)
+	`, []interface{}{channelID}, synapseIDs, func(rows *sql.Rows) error {
+		var id int
+		var parents [2]int
+		err := rows.Scan(&id, &parents[0], &parents[1])
+		res[id] = parents
+		return err
+	})
 
-		if bossID == id {
-			return true, nil
-		}
-	}
+	return res, err
+}
+
+func (s *mysqlStructuralStore) GetSynapseParents(synapseID int, channelID int) (int, int, error) {
+	var pre int
+	var post int
+
+	err := s.db.QueryRow(`
+	SELECT
+		(SELECT boss_vset_id from voxel_set, neuron where neuron.id = synapse.pre AND voxel_set.id = neuron.voxel_set) as pre,
+		(SELECT boss_vset_id from voxel_set, neuron where neuron.id = synapse.post AND voxel_set.id = neuron.voxel_set) as post
+	FROM
+		synapse, voxel_set
+	WHERE
+		synapse.voxel_set = voxel_set.id
+		AND voxel_set.boss_vset_id=?
+		AND voxel_set.channel=?`, synapseID, channelID).Scan(&pre, &post)
 
-	return false, nil
+	return pre, post, err
 }
 
 // Child boop
@@ -180,10 +459,10 @@ type child struct {
 	Polarity int
 }
 
-func getNeuronID(bossID int, channelID int) (int, error) {
+func (s *mysqlStructuralStore) GetNeuronID(bossID int, channelID int) (int, error) {
 	var neuronID int
 
-	err := structuralDb.QueryRow(`
+	err := s.db.QueryRow(`
 		SELECT
 			neuron.id
 		FROM
@@ -195,7 +474,7 @@ func getNeuronID(bossID int, channelID int) (int, error) {
 	return neuronID, err
 }
 
-func getNeighbors(neuronID int, pre bool, functionalOnly bool) ([]int, error) {
+func (s *mysqlStructuralStore) GetNeighbors(neuronID int, pre bool, functionalOnly bool) ([]int, error) {
 	from := "synapse.pre"
 	to := "synapse.post"
 
@@ -203,7 +482,7 @@ func getNeighbors(neuronID int, pre bool, functionalOnly bool) ([]int, error) {
 		from, to = to, from
 	}
 
-	rows, err2 := structuralDb.Query(`
+	rows, err2 := s.db.Query(`
 	SELECT
 		boss_vset_id
 	FROM
@@ -218,13 +497,13 @@ func getNeighbors(neuronID int, pre bool, functionalOnly bool) ([]int, error) {
 		AND `+from+` = ?
 		AND (? = false OR neuron.em_id is not null)
 	`, neuronID, functionalOnly)
-	defer rows.Close()
 
 	neighbors := make([]int, 0)
 
 	if err2 != nil {
 		return neighbors, err2
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var neighborID int
@@ -240,28 +519,313 @@ func getNeighbors(neuronID int, pre bool, functionalOnly bool) ([]int, error) {
 	return neighbors, nil
 }
 
-func getNeuronChildren(bossID int, channel string, region BBox, resolution uint64, filterByKeypoint bool) ([]child, error) {
-	channelID, err := getChannelFromString(channel)
+// GetSynapticEdges gives the edges leaving (or with pre, arriving at) any of the neurons
+func (s *mysqlStructuralStore) GetSynapticEdges(neuronIDs []int, pre bool, functionalOnly bool) ([]synapticEdge, error) {
+	from := "synapse.pre"
+	partner := "post_neuron"
+
+	if pre {
+		from, partner = "synapse.post", "pre_neuron"
+	}
+
+	res := make([]synapticEdge, 0)
+
+	for _, chunk := range chunkIds(neuronIDs) {
+		args := append(intArgs(chunk), functionalOnly)
+
+		rows, err := s.db.Query(`
+		SELECT
+			synapse.pre,
+			synapse.post,
+			pre_vset.boss_vset_id,
+			post_vset.boss_vset_id,
+			count(*),
+			coalesce(sum(synapse_vset.size), 0)
+		FROM
+			synapse
+			JOIN neuron pre_neuron ON synapse.pre = pre_neuron.id
+			JOIN voxel_set pre_vset ON pre_neuron.voxel_set = pre_vset.id
+			JOIN neuron post_neuron ON synapse.post = post_neuron.id
+			JOIN voxel_set post_vset ON post_neuron.voxel_set = post_vset.id
+			LEFT JOIN voxel_set synapse_vset ON synapse.voxel_set = synapse_vset.id
+		WHERE
+			`+from+` IN (`+placeholders(len(chunk))+`)
+			AND (? = false OR `+partner+`.em_id is not null)
+		GROUP BY
+			synapse.pre, synapse.post, pre_vset.boss_vset_id, post_vset.boss_vset_id
+		`, args...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var edge synapticEdge
+			err := rows.Scan(&edge.Pre, &edge.Post, &edge.PreBossID, &edge.PostBossID, &edge.Synapses, &edge.Size)
+
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			res = append(res, edge)
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// GetSynapsesBetween gives every synapse whose pre and post neurons are both in the set
+func (s *mysqlStructuralStore) GetSynapsesBetween(neuronIDs []int) ([]synapseLink, error) {
+	res := make([]synapseLink, 0)
+
+	// both lists are chunked, so no statement binds more than 2 * maxIdsPerQuery ids
+	for _, preChunk := range chunkIds(neuronIDs) {
+		for _, postChunk := range chunkIds(neuronIDs) {
+			links, err := s.getSynapsesBetween(preChunk, postChunk)
+			if err != nil {
+				return nil, err
+			}
+
+			res = append(res, links...)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *mysqlStructuralStore) getSynapsesBetween(preIDs []int, postIDs []int) ([]synapseLink, error) {
+	res := make([]synapseLink, 0)
+
+	rows, err := s.db.Query(`
+		SELECT
+			voxel_set.boss_vset_id,
+			synapse.pre,
+			synapse.post
+		FROM
+			synapse,
+			voxel_set
+		WHERE
+			synapse.voxel_set = voxel_set.id
+			AND synapse.pre IN (`+placeholders(len(preIDs))+`)
+			AND synapse.post IN (`+placeholders(len(postIDs))+`)
+		`, append(intArgs(preIDs), intArgs(postIDs)...)...)
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var neuronID int
-	err = structuralDb.QueryRow(`
+	for rows.Next() {
+		var link synapseLink
+		err := rows.Scan(&link.BossID, &link.Pre, &link.Post)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, link)
+	}
+
+	return res, rows.Err()
+}
+
+// GetInducedSynapses gives every synapse between two neurons of the set, neurons given by boss id in a channel
+func (s *mysqlStructuralStore) GetInducedSynapses(bossIDs []int, channelID int) ([]inducedSynapse, error) {
+	res := make([]inducedSynapse, 0)
+
+	// both lists are chunked, so no statement binds more than 2 * maxIdsPerQuery ids
+	for _, preChunk := range chunkIds(bossIDs) {
+		for _, postChunk := range chunkIds(bossIDs) {
+			synapses, err := s.getInducedSynapses(preChunk, postChunk, channelID)
+			if err != nil {
+				return nil, err
+			}
+
+			res = append(res, synapses...)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *mysqlStructuralStore) getInducedSynapses(preIDs []int, postIDs []int, channelID int) ([]inducedSynapse, error) {
+	res := make([]inducedSynapse, 0)
+
+	args := []interface{}{channelID, channelID}
+	args = append(args, intArgs(preIDs)...)
+	args = append(args, intArgs(postIDs)...)
+
+	rows, err := s.db.Query(`
 		SELECT
-			neuron.id
+			synapse_vset.boss_vset_id,
+			pre_vset.boss_vset_id,
+			post_vset.boss_vset_id
 		FROM
-			neuron, voxel_set
-		WHERE 
-			neuron.voxel_set = voxel_set.id
-			AND voxel_set.boss_vset_id=? and voxel_set.channel=?`, bossID, channelID).Scan(&neuronID)
+			synapse,
+			voxel_set synapse_vset,
+			neuron pre_neuron,
+			voxel_set pre_vset,
+			neuron post_neuron,
+			voxel_set post_vset
+		WHERE
+			synapse.voxel_set = synapse_vset.id
+			AND synapse.pre = pre_neuron.id
+			AND pre_neuron.voxel_set = pre_vset.id
+			AND synapse.post = post_neuron.id
+			AND post_neuron.voxel_set = post_vset.id
+			AND pre_vset.channel = ?
+			AND post_vset.channel = ?
+			AND pre_vset.boss_vset_id IN (`+placeholders(len(preIDs))+`)
+			AND post_vset.boss_vset_id IN (`+placeholders(len(postIDs))+`)
+		`, args...)
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	rows, err := structuralDb.Query(`
+	for rows.Next() {
+		var synapse inducedSynapse
+		err := rows.Scan(&synapse.Synapse, &synapse.Pre, &synapse.Post)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, synapse)
+	}
+
+	return res, rows.Err()
+}
+
+func nullableInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+// EachNeuron calls fn for every neuron in the channel as rows arrive, so the table is never held in memory
+func (s *mysqlStructuralStore) EachNeuron(channelID int, fn func(graphNode) error) error {
+	return s.eachNeuron(channelID, "", fn)
+}
+
+func (s *mysqlStructuralStore) EachCoregisteredNeuron(channelID int, fn func(graphNode) error) error {
+	return s.eachNeuron(channelID, "AND neuron.em_id IS NOT NULL", fn)
+}
+
+// eachNeuron is EachNeuron with condition added to the where clause
+func (s *mysqlStructuralStore) eachNeuron(channelID int, condition string, fn func(graphNode) error) error {
+	rows, err := s.db.Query(`
+	SELECT
+		boss_vset_id,
+		neuron.em_id,
+		size,
+		key_point_x, key_point_y, key_point_z,
+		x_min, y_min, z_min,
+		x_max, y_max, z_max
+	FROM
+		neuron,
+		voxel_set
+	WHERE
+		neuron.voxel_set = voxel_set.id
+		AND voxel_set.channel = ?
+		`+condition, channelID)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var node graphNode
+		var emID, size sql.NullInt64
+
+		err := rows.Scan(&node.BossID, &emID, &size,
+			&node.Keypoint.X, &node.Keypoint.Y, &node.Keypoint.Z,
+			&node.BBox.MIN.X, &node.BBox.MIN.Y, &node.BBox.MIN.Z,
+			&node.BBox.MAX.X, &node.BBox.MAX.Y, &node.BBox.MAX.Z)
+
+		if err != nil {
+			return err
+		}
+
+		node.EmID = nullableInt(emID)
+		node.Size = nullableInt(size)
+
+		if err := fn(node); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// EachSynapticEdge calls fn for every neuron to neuron edge in the channel as rows arrive
+func (s *mysqlStructuralStore) EachSynapticEdge(channelID int, fn func(synapticEdge) error) error {
+	return s.eachSynapticEdge(channelID, "", fn)
+}
+
+func (s *mysqlStructuralStore) EachCoregisteredEdge(channelID int, fn func(synapticEdge) error) error {
+	return s.eachSynapticEdge(channelID, "AND pre_neuron.em_id IS NOT NULL AND post_neuron.em_id IS NOT NULL", fn)
+}
+
+// eachSynapticEdge is EachSynapticEdge with condition added to the where clause
+func (s *mysqlStructuralStore) eachSynapticEdge(channelID int, condition string, fn func(synapticEdge) error) error {
+	rows, err := s.db.Query(`
+	SELECT
+		synapse.pre,
+		synapse.post,
+		pre_vset.boss_vset_id,
+		post_vset.boss_vset_id,
+		count(*),
+		coalesce(sum(synapse_vset.size), 0)
+	FROM
+		synapse
+		JOIN neuron pre_neuron ON synapse.pre = pre_neuron.id
+		JOIN voxel_set pre_vset ON pre_neuron.voxel_set = pre_vset.id
+		JOIN neuron post_neuron ON synapse.post = post_neuron.id
+		JOIN voxel_set post_vset ON post_neuron.voxel_set = post_vset.id
+		LEFT JOIN voxel_set synapse_vset ON synapse.voxel_set = synapse_vset.id
+	WHERE
+		pre_vset.channel = ?
+		AND post_vset.channel = ?
+		`+condition+`
+	GROUP BY
+		synapse.pre, synapse.post, pre_vset.boss_vset_id, post_vset.boss_vset_id
+	`, channelID, channelID)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var edge synapticEdge
+		err := rows.Scan(&edge.Pre, &edge.Post, &edge.PreBossID, &edge.PostBossID, &edge.Synapses, &edge.Size)
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(edge); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *mysqlStructuralStore) GetNeuronSynapses(neuronID int) ([]neuronSynapse, error) {
+	rows, err := s.db.Query(`
 	SELECT
 		boss_vset_id,
 		synapse.pre,
@@ -277,92 +841,88 @@ func getNeuronChildren(bossID int, channel string, region BBox, resolution uint6
 		AND voxel_set.channel = channel.id
 		AND (synapse.pre = ? OR synapse.post = ?)
 	`, neuronID, neuronID)
+
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	res := make([]child, 0) // for output to json to be [] in the empty case, not null
+	res := make([]neuronSynapse, 0)
 
-	// for each synapse
 	for rows.Next() {
-		var synapseID int
-		var preID int
-		var xMin, yMin, zMin int
-		var xMax, yMax, zMax int
-
-		var synapseChannelString string
+		var synapse neuronSynapse
 
-		err := rows.Scan(&synapseID, &preID, &xMin, &yMin, &zMin, &xMax, &yMax, &zMax, &synapseChannelString)
+		err := rows.Scan(&synapse.BossID, &synapse.Pre,
+			&synapse.BBox.MIN.X, &synapse.BBox.MIN.Y, &synapse.BBox.MIN.Z,
+			&synapse.BBox.MAX.X, &synapse.BBox.MAX.Y, &synapse.BBox.MAX.Z,
+			&synapse.Channel)
 
 		if err != nil {
 			return nil, err
 		}
 
-		var polarity = 1
-		if neuronID != preID {
-			polarity = 2
-		}
+		res = append(res, synapse)
+	}
 
-		synapseBbox := BBox{
-			MIN: Vector3{
-				X: xMin,
-				Y: yMin,
-				Z: zMin}.DownsampleAniso(resolution),
-			MAX: Vector3{
-				X: xMax,
-				Y: yMax,
-				Z: zMax}.DownsampleAniso(resolution)}
+	return res, rows.Err()
+}
 
-		inRegion, err := idInRegion(synapseID, synapseChannelString, region, synapseBbox, resolution, filterByKeypoint)
+// getNeuronChildren is every synapse of the neuron in region, see synapsesInRegion for filter
+func getNeuronChildren(ctx context.Context, bossID int, channel string, region BBox, resolution uint64, filter string) ([]child, error) {
+	channelID, err := structuralStore.GetChannelFromString(channel)
 
-		if err != nil {
-			return res, err // network error, break
-		} else if inRegion {
-			res = append(res, child{synapseID, polarity})
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	return res, err
-}
-
-// IdsInRegionRes boop
-type IdsInRegionRes struct {
-	Ids []string `json:"ids"`
-}
+	neuronID, err := structuralStore.GetNeuronID(bossID, channelID)
 
-// we don't convert from string to number because we proxy this result for service 2 and 6 and JSON 64 bit integers need to be strings
-func getUniqueIdsInRegion(channel string, bbox BBox, resolution uint64) (IdsInRegionRes, error) {
-	// adding 1 to max because boss ranges are inclusive exclusive
-	url := fmt.Sprintf(bossInfo.URL+"ids/%s/%d/%d:%d/%d:%d/%d:%d/", channel, resolution, bbox.MIN.X, bbox.MAX.X+1, bbox.MIN.Y, bbox.MAX.Y+1, bbox.MIN.Z, bbox.MAX.Z+1)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Println("getUniqueIdsInRegion", url)
+	synapses, err := structuralStore.GetNeuronSynapses(neuronID)
 
-	request := gorequest.New()
-	resp, body, errArr := request.Get(url).
-		Set("Authorization", bossInfo.AuthToken).
-		EndBytes()
+	if err != nil {
+		return nil, err
+	}
 
-	var ids IdsInRegionRes
+	inRegion, err := synapsesInRegion(ctx, synapses, region, resolution, filter)
 
-	if resp.StatusCode >= 400 {
-		return ids, fmt.Errorf("http error, status code: %d url: %s", resp.StatusCode, url)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(errArr) > 0 {
-		return ids, errArr[0]
+	res := make([]child, 0) // for output to json to be [] in the empty case, not null
+
+	for _, synapse := range synapses {
+		if !inRegion[synapseKey{synapse.Channel, synapse.BossID}] {
+			continue
+		}
+
+		var polarity = 1
+		if neuronID != synapse.Pre {
+			polarity = 2
+		}
+
+		res = append(res, child{synapse.BossID, polarity})
 	}
 
-	err := json.Unmarshal(body, &ids)
+	return res, nil
+}
 
-	return ids, err
+// IdsInRegionRes boop
+type IdsInRegionRes struct {
+	Ids []string `json:"ids"`
 }
 
 var errNoCellFunctionalId = errors.New("no functional data for cell")
 
-func getFunctionalID(bossID int, channelID int) (int, error) {
+func (s *mysqlStructuralStore) GetFunctionalID(bossID int, channelID int) (int, error) {
 	// todo, I can either use null int or I can check for is not null and get back no rows
 	// only issue is I can't separate bad bossID vs no em_id but currently returning 404 for either
-	fmt.Println("got here!")
 	var functionalID sql.NullInt64
-	err := structuralDb.QueryRow(`SELECT neuron.em_id from neuron, voxel_set where neuron.voxel_set = voxel_set.id and neuron.em_id is not null and boss_vset_id = ? and channel = ?`, bossID, channelID).Scan(&functionalID)
+	err := s.db.QueryRow(`SELECT neuron.em_id from neuron, voxel_set where neuron.voxel_set = voxel_set.id and neuron.em_id is not null and boss_vset_id = ? and channel = ?`, bossID, channelID).Scan(&functionalID)
 
 	if err != nil {
 		return 0, err
@@ -373,3 +933,70 @@ func getFunctionalID(bossID int, channelID int) (int, error) {
 	}
 	return 0, errNoCellFunctionalId
 }
+
+func (s *mysqlStructuralStore) GetFunctionalIDBatch(bossIDs []int, channelID int) (map[int]int, error) {
+	res := make(map[int]int)
+
+	err := s.queryChunks(`
+	SELECT
+		boss_vset_id, neuron.em_id
+	FROM
+		neuron, voxel_set
+	WHERE
+		neuron.voxel_set = voxel_set.id
+		AND neuron.em_id is not null
+		AND voxel_set.channel = ?
+		AND voxel_set.boss_vset_id IN (%s)
+	`, []interface{}{channelID}, bossIDs, func(rows *sql.Rows) error {
+		var bossID, emID int
+		err := rows.Scan(&bossID, &emID)
+		res[bossID] = emID
+		return err
+	})
+
+	return res, err
+}
+
+func (s *mysqlStructuralStore) GetCoregisteredNeurons(emID int) ([]coregisteredNeuron, error) {
+	res := make([]coregisteredNeuron, 0)
+
+	rows, err := s.db.Query(`
+	SELECT
+		channel.name,
+		boss_vset_id,
+		key_point_x, key_point_y, key_point_z,
+		x_min, y_min, z_min,
+		x_max, y_max, z_max
+	FROM
+		neuron,
+		voxel_set,
+		channel
+	WHERE
+		neuron.voxel_set = voxel_set.id
+		AND voxel_set.channel = channel.id
+		AND neuron.em_id = ?
+	ORDER BY channel.name, boss_vset_id
+	`, emID)
+
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		neuron := coregisteredNeuron{EmID: emID}
+
+		err := rows.Scan(&neuron.Channel, &neuron.BossID,
+			&neuron.Keypoint.X, &neuron.Keypoint.Y, &neuron.Keypoint.Z,
+			&neuron.BBox.MIN.X, &neuron.BBox.MIN.Y, &neuron.BBox.MIN.Z,
+			&neuron.BBox.MAX.X, &neuron.BBox.MAX.Y, &neuron.BBox.MAX.Z)
+
+		if err != nil {
+			return res, err
+		}
+
+		res = append(res, neuron)
+	}
+
+	return res, rows.Err()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// a 2000 x 2000 matrix is already ~30MB of json
+const maxSubgraphIds = 2000
+
+var errTooManyIds = fmt.Errorf("at most %d ids per request", maxSubgraphIds)
+
+type idsReq struct {
+	Ids []int `json:"ids"`
+}
+
+type subgraphEdge struct {
+	Pre      int   `json:"pre"`
+	Post     int   `json:"post"`
+	Synapses []int `json:"synapses"`
+}
+
+// SubgraphRes boop
+type SubgraphRes struct {
+	Ids []int `json:"ids"`
+	// Matrix[i][j] is the number of synapses from Ids[i] onto Ids[j]
+	Matrix [][]int        `json:"matrix"`
+	Edges  []subgraphEdge `json:"edges"`
+}
+
+func dedupeIds(ids []int) []int {
+	seen := make(map[int]bool)
+	res := make([]int, 0, len(ids))
+
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+
+	return res
+}
+
+// getInducedSubgraph builds the connectivity among a set of neurons, rows and columns follow the order of bossIDs
+// ids that aren't neurons in the channel just have empty rows
+func getInducedSubgraph(bossIDs []int, channelID int) (SubgraphRes, error) {
+	bossIDs = dedupeIds(bossIDs)
+
+	res := SubgraphRes{
+		Ids:    bossIDs,
+		Matrix: make([][]int, len(bossIDs)),
+		Edges:  make([]subgraphEdge, 0)}
+
+	if len(bossIDs) > maxSubgraphIds {
+		return res, errTooManyIds
+	}
+
+	index := make(map[int]int)
+	for i, id := range bossIDs {
+		index[id] = i
+		res.Matrix[i] = make([]int, len(bossIDs))
+	}
+
+	if len(bossIDs) == 0 {
+		return res, nil
+	}
+
+	synapses, err := structuralStore.GetInducedSynapses(bossIDs, channelID)
+	if err != nil {
+		return res, err
+	}
+
+	edges := make(map[[2]int][]int)
+
+	for _, synapse := range synapses {
+		res.Matrix[index[synapse.Pre]][index[synapse.Post]]++
+
+		key := [2]int{synapse.Pre, synapse.Post}
+		edges[key] = append(edges[key], synapse.Synapse)
+	}
+
+	for key, ids := range edges {
+		sort.Ints(ids)
+		res.Edges = append(res.Edges, subgraphEdge{Pre: key[0], Post: key[1], Synapses: ids})
+	}
+
+	sort.Slice(res.Edges, func(i, j int) bool {
+		if res.Edges[i].Pre != res.Edges[j].Pre {
+			return res.Edges[i].Pre < res.Edges[j].Pre
+		}
+		return res.Edges[i].Post < res.Edges[j].Post
+	})
+
+	return res, nil
+}
+
+func (s SubgraphRes) ndarray() ndarray {
+	res := newNdarray(len(s.Ids), len(s.Ids))
+
+	for i, row := range s.Matrix {
+		for j, count := range row {
+			res.Data[i*len(s.Ids)+j] = float64(count)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInducedSubgraphHandler(t *testing.T) {
+	useTestStores(t)
+
+	tooMany := make([]int, maxSubgraphIds+1)
+	for i := range tooMany {
+		tooMany[i] = i
+	}
+	tooManyBody, _ := json.Marshal(idsReq{Ids: tooMany})
+
+	runHandlerCases(t, []handlerCase{
+		// repeats are dropped, ids that aren't neurons get empty rows, and rows follow the posted order
+		{method: "POST", url: "/induced_subgraph/c/e/seg/", body: `{"ids": [103, 101, 102, 101, 999]}`, status: 200,
+			want: `{"ids":[103,101,102,999],"matrix":[[0,0,0,0],[0,0,2,0],[1,0,0,0],[0,0,0,0]],` +
+				`"edges":[{"pre":101,"post":102,"synapses":[201,202]},{"pre":102,"post":103,"synapses":[203]}]}`},
+		{method: "POST", url: "/induced_subgraph/c/e/seg/", body: `{"ids": []}`, status: 200, want: `{"ids":[],"matrix":[],"edges":[]}`},
+		{method: "POST", url: "/induced_subgraph/c/e/seg/", body: string(tooManyBody), status: 400},
+		{method: "POST", url: "/induced_subgraph/c/e/seg/", body: `{"ids": [1,`, status: 400},
+		{method: "POST", url: "/induced_subgraph/c/e/nope/", body: `{"ids": [101]}`, status: 404},
+	})
+}
+
+func TestInducedSubgraphBlob(t *testing.T) {
+	useTestStores(t)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("POST", "/induced_subgraph/c/e/seg/?format=blob", strings.NewReader(`{"ids": [101, 102, 103]}`)))
+
+	array, err := decodeArray(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ndarray{Shape: []int{3, 3}, Data: []float64{0, 2, 0, 0, 0, 1, 0, 0, 0}}
+	if got := array.ndarray(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
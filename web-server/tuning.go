@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"sort"
+)
+
+// TuningRes is a cell's mean spike rate per stimulus direction and the selectivity it gives
+// osi and dsi are the global (vector sum) indices, 0 for untuned and 1 for responding to a single direction
+// the preferred direction is the one with the largest response, the vector sum being meaningless for bidirectional cells
+type TuningRes struct {
+	EmID               int         `json:"em_id"`
+	Slice              int         `json:"slice"`
+	Directions         []float64   `json:"directions"`
+	Responses          []jsonFloat `json:"responses"`
+	Trials             []int       `json:"trials"`
+	PreferredDirection jsonFloat   `json:"preferred_direction"`
+	OSI                jsonFloat   `json:"osi"`
+	DSI                jsonFloat   `json:"dsi"`
+}
+
+// trialStops fills in the end of trials that only have onsets, each runs to the next onset or the shortest known length
+func trialStops(trials []stimulusTrial) []stimulusTrial {
+	res := append([]stimulusTrial{}, trials...)
+	shortest := shortestTrial(trials)
+
+	for i := range res {
+		if res[i].Stop != -1 {
+			continue
+		}
+
+		if i+1 < len(res) {
+			res[i].Stop = res[i+1].Onset
+		} else if shortest > 0 {
+			res[i].Stop = res[i].Onset + shortest
+		}
+	}
+
+	return res
+}
+
+// computeTuning averages the signal over every trial of each direction, trials already having stops and directions
+func computeTuning(signal []float64, trials []stimulusTrial) TuningRes {
+	sums := make(map[float64]float64)
+	counts := make(map[float64]int)
+
+	for _, trial := range trials {
+		direction := *trial.Direction
+		total, frames := 0.0, 0
+
+		for frame := trial.Onset; frame < trial.Stop && frame < len(signal); frame++ {
+			if frame >= 0 && !math.IsNaN(signal[frame]) {
+				total += signal[frame]
+				frames++
+			}
+		}
+
+		if frames > 0 {
+			sums[direction] += total / float64(frames)
+			counts[direction]++
+		}
+	}
+
+	res := TuningRes{Directions: make([]float64, 0), Responses: make([]jsonFloat, 0), Trials: make([]int, 0)}
+
+	for direction := range counts {
+		res.Directions = append(res.Directions, direction)
+	}
+	sort.Float64s(res.Directions)
+
+	var direction, orientation complex128
+	total, largest := 0.0, math.Inf(-1)
+
+	for _, d := range res.Directions {
+		mean := sums[d] / float64(counts[d])
+		theta := d * math.Pi / 180
+
+		res.Responses = append(res.Responses, jsonFloat(mean))
+		res.Trials = append(res.Trials, counts[d])
+
+		direction += complex(mean, 0) * cmplx.Exp(complex(0, theta))
+		orientation += complex(mean, 0) * cmplx.Exp(complex(0, 2*theta))
+		total += mean
+
+		if mean > largest {
+			largest = mean
+			res.PreferredDirection = jsonFloat(d)
+		}
+	}
+
+	if total > 0 {
+		res.DSI = jsonFloat(cmplx.Abs(direction) / total)
+		res.OSI = jsonFloat(cmplx.Abs(orientation) / total)
+	} else {
+		res.DSI, res.OSI, res.PreferredDirection = jsonFloat(math.NaN()), jsonFloat(math.NaN()), jsonFloat(math.NaN())
+	}
+
+	return res
+}
+
+// scanTrials is the trials of a scan with stops, refused unless every one has a direction
+// the condition id is never taken for an angle, conditions of different contrast can share a direction
+func scanTrials(scanID int) ([]stimulusTrial, error) {
+	conditions, err := functionalStore.GetStimulusConditions(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	trials, err := parseConditions(conditions)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, trial := range trials {
+		if trial.Direction == nil {
+			return nil, errUnsupportedConditions
+		}
+	}
+
+	return trialStops(trials), nil
+}
+
+// getTuning is one cell's tuning from its spike rate
+func getTuning(scanID int, slice int, emID int) (TuningRes, error) {
+	trials, err := scanTrials(scanID)
+	if err != nil {
+		return TuningRes{}, err
+	}
+
+	blob, err := functionalStore.GetSpike(scanID, slice, emID)
+	if err != nil {
+		return TuningRes{}, err
+	}
+
+	spikes, err := decodeArray(blob)
+	if err != nil {
+		return TuningRes{}, err
+	}
+
+	res := computeTuning(spikes.ndarray().Data, trials)
+	res.EmID, res.Slice = emID, slice
+
+	return res, nil
+}
+
+// computeScanTuning is the tuning of every cell with a mask in the scan
+func computeScanTuning(scanID int) ([]TuningRes, error) {
+	res := make([]TuningRes, 0)
+
+	trials, err := scanTrials(scanID)
+	if err != nil {
+		return res, err
+	}
+
+	emIDs, err := functionalStore.GetSliceCells(scanID, allSlices)
+	if err != nil {
+		return res, err
+	}
+
+	cells, err := functionalStore.GetSpikeBatch(scanID, allSlices, emIDs)
+	if err != nil {
+		return res, err
+	}
+
+	for _, cell := range cells {
+		spikes, err := decodeArray(cell.Data)
+		if err != nil {
+			return res, err
+		}
+
+		tuning := computeTuning(spikes.ndarray().Data, trials)
+		tuning.EmID, tuning.Slice = cell.EmID, cell.Slice
+
+		res = append(res, tuning)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].EmID != res[j].EmID {
+			return res[i].EmID < res[j].EmID
+		}
+		return res[i].Slice < res[j].Slice
+	})
+
+	return res, nil
+}
+
+// cachedScanTuning computes a scan's tuning once into ./cache/tuning and gives the file name
+// the files are kept across restarts and never checked against the database, so delete ./cache/tuning after reimporting a scan
+func cachedScanTuning(scanID int) (string, error) {
+	filename := fmt.Sprintf("./cache/tuning/%d.json", scanID)
+
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+
+	tuning, err := computeScanTuning(scanID)
+	if err != nil {
+		return "", err
+	}
+
+	return filename, writeCacheFile(filename, func(f *os.File) error {
+		return json.NewEncoder(f).Encode(tuning)
+	})
+}
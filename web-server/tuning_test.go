@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestComputeTuning(t *testing.T) {
+	// two trials at each of four directions, a frame apart
+	trials := make([]stimulusTrial, 0)
+	for i, direction := range []float64{0, 90, 180, 270, 0, 90, 180, 270} {
+		direction := direction
+		trials = append(trials, stimulusTrial{Condition: float64(i % 4), Onset: 2 * i, Stop: 2*i + 2, Direction: &direction})
+	}
+
+	// responds only to 0, more on the first trial
+	signal := []float64{4, 4, 0, 0, 0, 0, 0, 0, 2, 2, 0, 0, 0, 0, 0, 0}
+	res := computeTuning(signal, trials)
+
+	if !reflect.DeepEqual(res.Directions, []float64{0, 90, 180, 270}) || !reflect.DeepEqual(res.Trials, []int{2, 2, 2, 2}) {
+		t.Fatalf("directions %v with trials %v", res.Directions, res.Trials)
+	}
+
+	if !reflect.DeepEqual(res.Responses, []jsonFloat{3, 0, 0, 0}) {
+		t.Errorf("responses %v, want the mean over trials", res.Responses)
+	}
+
+	// all of the response is in one direction
+	if res.PreferredDirection != 0 || math.Abs(float64(res.DSI)-1) > 1e-9 || math.Abs(float64(res.OSI)-1) > 1e-9 {
+		t.Errorf("preferred %v, dsi %v, osi %v", res.PreferredDirection, res.DSI, res.OSI)
+	}
+
+	// equal responses to opposite directions are orientation but not direction selective
+	signal = []float64{1, 1, 0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 1, 1, 0, 0}
+	res = computeTuning(signal, trials)
+
+	if math.Abs(float64(res.DSI)) > 1e-9 || math.Abs(float64(res.OSI)-1) > 1e-9 {
+		t.Errorf("opposite directions gave dsi %v, osi %v", res.DSI, res.OSI)
+	}
+}
+
+func TestComputeTuningMissingFrames(t *testing.T) {
+	nan := math.NaN()
+	directions := []float64{0, 90, 180}
+	trials := []stimulusTrial{
+		{Condition: 1, Onset: 0, Stop: 2, Direction: &directions[0]},
+		{Condition: 2, Onset: 2, Stop: 4, Direction: &directions[1]},
+		// runs past the end of the signal
+		{Condition: 3, Onset: 4, Stop: 8, Direction: &directions[2]},
+	}
+
+	res := computeTuning([]float64{nan, 2, nan, nan, 1}, trials)
+
+	// NaN frames are skipped, and a trial without any frames isn't counted
+	if !reflect.DeepEqual(res.Directions, []float64{0, 180}) || !reflect.DeepEqual(res.Responses, []jsonFloat{2, 1}) {
+		t.Errorf("directions %v responses %v", res.Directions, res.Responses)
+	}
+}
+
+// trialsBlob encodes an n x 1 struct array with a scalar per field, one element per row of trials
+func trialsBlob(names []string, trials [][]float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("mYm\x00S")
+
+	for _, v := range []uint64{2, uint64(len(trials)), 1} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, uint32(len(names)))
+	for _, name := range names {
+		buf.WriteString(name + "\x00")
+	}
+
+	for _, trial := range trials {
+		for _, value := range trial {
+			field := ndarray{Shape: []int{1, 1}, Data: []float64{value}}.djArray()
+			binary.Write(&buf, binary.LittleEndian, uint64(len(field)))
+			buf.Write(field)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestTuningHandlers(t *testing.T) {
+	_, functional := useTestStores(t)
+	useCacheDir(t)
+
+	// four conditions, two contrasts at each of two directions, the condition ids being no angle
+	scan := functional.Scans[1]
+	scan.StimulusConditions = trialsBlob([]string{"onset", "offset", "condition", "direction"}, [][]float64{
+		{0, 2, 1, 0}, {2, 4, 2, 0}, {4, 6, 3, 180}, {6, 8, 4, 180}})
+	functional.Scans[1] = scan
+
+	functional.Cells[0].Spike = ndarray{Shape: []int{1, 8}, Data: []float64{2, 2, 4, 4, 1, 1, 1, 1}}.djBlob()
+	functional.Cells = append(functional.Cells, memoryCell{Scan: 1, Slice: 2, EmID: 3000,
+		Spike: ndarray{Shape: []int{1, 8}, Data: []float64{0, 0, 0, 0, 2, 2, 2, 2}}.djBlob()})
+
+	// per frame conditions give no direction
+	functional.Scans[2] = memoryScan{Metadata: ScanMetadataRes{Fps: 2, NFrames: 4},
+		StimulusConditions: ndarray{Shape: []int{1, 4}, Data: []float64{0, 0, 90, 90}}.djBlob()}
+	functional.Cells = append(functional.Cells, memoryCell{Scan: 2, Slice: 1, EmID: 1000,
+		Spike: ndarray{Shape: []int{1, 4}, Data: []float64{1, 1, 0, 0}}.djBlob()})
+
+	first := `{"em_id":1000,"slice":1,"directions":[0,180],"responses":[3,1],"trials":[2,2],"preferred_direction":0,"osi":1,"dsi":0.5}`
+	second := `{"em_id":3000,"slice":2,"directions":[0,180],"responses":[0,2],"trials":[2,2],"preferred_direction":180,"osi":1,"dsi":1}`
+
+	runHandlerCases(t, []handlerCase{
+		{url: "/tuning_functional/1/1/1000/", status: 200, want: first},
+		{url: "/tuning/c/e/seg/1/1/101/", status: 200, want: first},
+		// every cell of every slice, not only the ones with masks
+		{url: "/scan_tuning/1/", status: 200, want: "[" + first + "," + second + "]"},
+
+		{url: "/tuning_functional/2/1/1000/", status: 422},
+		{url: "/scan_tuning/2/", status: 422},
+		{url: "/tuning_functional/1/1/9999/", status: 404},
+		{url: "/tuning_functional/x/1/1000/", status: 400},
+	})
+}
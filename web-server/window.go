@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errNoFps = errors.New("scan has no fps (or nframes for recordings off the frame clock) to convert between times and samples")
+var errBadWindow = errors.New("window should be ?frames=start:stop or ?t=start:stop with start <= stop")
+
+// frameWindow is the half open frame range [Start, Stop), Stop of -1 runs to the end
+type frameWindow struct {
+	Start int
+	Stop  int
+}
+
+// splitRange parses "a:b" where either side may be left out
+func splitRange(s string) (start, stop string, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return "", "", errBadWindow
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseFrameWindow reads ?frames= in frames or ?t= in seconds, which goes through the scan's fps
+// it gives nil when neither is set
+func parseFrameWindow(r *http.Request, scanID int) (*frameWindow, error) {
+	return parseWindow(r, scanID, nil)
+}
+
+// parseStreamWindow is parseFrameWindow for a recording that isn't on the imaging frame clock, like pupil and treadmill
+// ?frames= counts its own samples and ?t= assumes the samples span the scan's nframes evenly, as getBehavior does
+func parseStreamWindow(r *http.Request, scanID int, blob []byte) (*frameWindow, error) {
+	return parseWindow(r, scanID, func() (int, error) {
+		array, err := decodeArray(blob)
+		if err != nil {
+			return 0, err
+		}
+		return array.Shape[array.frameAxis()], nil
+	})
+}
+
+// parseWindow converts ?t= with samples per frame from samples, or one per frame when it is nil
+func parseWindow(r *http.Request, scanID int, samples func() (int, error)) (*frameWindow, error) {
+	query := r.URL.Query()
+	frames, seconds := query.Get("frames"), query.Get("t")
+
+	if frames != "" && seconds != "" {
+		return nil, errBadWindow
+	}
+
+	if frames != "" {
+		return parseFrames(frames)
+	}
+
+	if seconds == "" {
+		return nil, nil
+	}
+
+	start, stop, err := splitRange(seconds)
+	if err != nil {
+		return nil, err
+	}
+
+	window := &frameWindow{Start: 0, Stop: -1}
+	var t0, t1 float64
+
+	if start != "" {
+		if t0, err = strconv.ParseFloat(start, 64); err != nil {
+			return nil, errBadWindow
+		}
+	}
+	if stop != "" {
+		if t1, err = strconv.ParseFloat(stop, 64); err != nil {
+			return nil, errBadWindow
+		}
+	}
+
+	metadata, err := functionalStore.GetScanMetadata(scanID)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Fps <= 0 {
+		return nil, errNoFps
+	}
+
+	rate := metadata.Fps
+
+	if samples != nil {
+		if metadata.NFrames <= 0 {
+			return nil, errNoFps
+		}
+
+		n, err := samples()
+		if err != nil {
+			return nil, err
+		}
+
+		rate *= float64(n) / float64(metadata.NFrames)
+	}
+
+	// every sample that overlaps the interval
+	window.Start = int(math.Floor(t0 * rate))
+	if stop != "" {
+		window.Stop = int(math.Ceil(t1 * rate))
+	}
+
+	return window, window.check()
+}
+
+func parseFrames(frames string) (*frameWindow, error) {
+	start, stop, err := splitRange(frames)
+	if err != nil {
+		return nil, err
+	}
+
+	window := &frameWindow{Start: 0, Stop: -1}
+
+	if start != "" {
+		if window.Start, err = strconv.Atoi(start); err != nil {
+			return nil, errBadWindow
+		}
+	}
+	if stop != "" {
+		if window.Stop, err = strconv.Atoi(stop); err != nil {
+			return nil, errBadWindow
+		}
+	}
+
+	return window, window.check()
+}
+
+func (f frameWindow) check() error {
+	if f.Start < 0 || f.Stop != -1 && f.Stop < f.Start {
+		return errBadWindow
+	}
+	return nil
+}
+
+// frameAxis is the last dimension that isn't 1, time for both row and column vectors and for movies
+func (a blobArray) frameAxis() int {
+	for d := len(a.Shape) - 1; d > 0; d-- {
+		if a.Shape[d] != 1 {
+			return d
+		}
+	}
+	return 0
+}
+
+// frames cuts the array down to the window along its frame axis, clamped to the frames there are
+// every dimension after the frame axis is 1 so the window is one contiguous run of the fortran ordered data
+func (a blobArray) frames(window frameWindow) blobArray {
+	return a.framesAlong(a.frameAxis(), window)
+}
+
+// framesAlong cuts the array down to the window along axis, every dimension after it has to be 1
+func (a blobArray) framesAlong(axis int, window frameWindow) blobArray {
+	n := a.Shape[axis]
+
+	start, stop := Min2(window.Start, n), n
+	if window.Stop != -1 {
+		stop = Min2(window.Stop, n)
+	}
+
+	frameBytes := mxNumericClasses[a.Class].size
+	for _, dim := range a.Shape[:axis] {
+		frameBytes *= dim
+	}
+
+	shape := append([]int{}, a.Shape...)
+	shape[axis] = stop - start
+
+	return blobArray{Shape: shape, Class: a.Class, Data: a.Data[start*frameBytes : stop*frameBytes]}
+}
+
+// blob encodes the array back into an uncompressed mYm blob of the same class
+func (a blobArray) blob() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("mYm\x00A")
+	binary.Write(&buf, binary.LittleEndian, uint64(len(a.Shape)))
+	for _, dim := range a.Shape {
+		binary.Write(&buf, binary.LittleEndian, uint64(dim))
+	}
+	binary.Write(&buf, binary.LittleEndian, a.Class)
+	binary.Write(&buf, binary.LittleEndian, uint32(0)) // not complex
+	buf.Write(a.Data)
+
+	return buf.Bytes()
+}
+
+// writeBlobWindow is writeBlob with the data cut down to a frame window first, if there is one
+func writeBlobWindow(w http.ResponseWriter, blob []byte, format string, window *frameWindow) {
+	if window == nil {
+		writeBlob(w, blob, format)
+		return
+	}
+
+	array, err := decodeArray(blob)
+	if err == errUnsupportedBlob {
+		httpError(w, http.StatusNotAcceptable, err)
+		return
+	} else if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	writeArray(w, array.frames(*window), format)
+}